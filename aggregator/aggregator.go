@@ -31,6 +31,15 @@ func (a AggregatorFunc) Aggregate(n *node.Node) Aggregate {
 	return a(n)
 }
 
+// Merger is implemented by aggregators that can aggregate a document part by
+// part, adding each part to the aggregate of the parts before it.
+type Merger interface {
+	// Merge adds the aggregate of n, the part of a document that follows
+	// the part aggregated in a, to a and returns the result. It may modify
+	// a.
+	Merge(a Aggregate, n *node.Node) Aggregate
+}
+
 // Aggregates is a map of aggregate names to Aggregates.
 type Aggregates map[string]Aggregate
 
@@ -47,3 +56,22 @@ func Apply(n *node.Node, aggregators Aggregators) Aggregates {
 	}
 	return m
 }
+
+// Merge applies the given aggregators to n, the part of a document that
+// follows the part aggregated in a, and adds the aggregates to a by the
+// aggregators that implement the Merger interface. The aggregates of other
+// aggregators are replaced by those of n. Merge modifies a (a new map is
+// returned if a is nil).
+func Merge(a Aggregates, n *node.Node, aggregators Aggregators) Aggregates {
+	if a == nil {
+		a = Aggregates{}
+	}
+	for name, ar := range aggregators {
+		if mr, ok := ar.(Merger); ok && a[name] != nil {
+			a[name] = mr.Merge(a[name], n)
+		} else {
+			a[name] = ar.Aggregate(n)
+		}
+	}
+	return a
+}
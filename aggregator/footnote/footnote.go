@@ -0,0 +1,160 @@
+// Package footnote provides a footnote aggregator. The aggregate is used to
+// render the footnotes section at the end of the document.
+package footnote
+
+import (
+	"sort"
+
+	"github.com/touchmarine/to/aggregator"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/transformer/footnote"
+)
+
+// Aggregator aggregates the numbered footnote definitions of elements that
+// belong to the Elements set.
+type Aggregator struct {
+	Elements []string
+}
+
+// Aggregate implements the Aggregator interface.
+func (ar Aggregator) Aggregate(n *node.Node) aggregator.Aggregate {
+	ae := ar.definitions(nil, n)
+	sort.SliceStable(ae, func(i, j int) bool {
+		return ae[i].Number < ae[j].Number
+	})
+	return aggregator.Aggregate(ae)
+}
+
+// Merge implements the aggregator.Merger interface. The particles of n are
+// inserted in number order and the references in n to the definitions
+// aggregated in a are added to their particles, so a streamed document
+// (see transformer/footnote.Transformer.Stream) gets the particles Aggregate
+// returns for the whole document.
+func (ar Aggregator) Merge(a aggregator.Aggregate, n *node.Node) aggregator.Aggregate {
+	ae := aggregate(Particles(a))
+	k := len(ae) // particles of a
+	walk(n, func(n *node.Node) bool {
+		target, ok := n.Data[footnote.KeyTarget].(string)
+		if !ok {
+			return true
+		}
+		num, _ := n.Data[footnote.KeyNumber].(int)
+		refID, _ := n.Data[footnote.KeyID].(string)
+		if i := search(ae[:k], num, func(p Particle) bool { return p.ID == target }); i >= 0 {
+			ae[i].References = append(ae[i].References, refID)
+		}
+		return true
+	})
+
+	ae = ar.definitions(ae, n)
+	for i := k; i < len(ae); i++ {
+		p := ae[i]
+		j := sort.Search(i, func(j int) bool {
+			return ae[j].Number > p.Number
+		})
+		copy(ae[j+1:i+1], ae[j:i])
+		ae[j] = p
+	}
+	return aggregator.Aggregate(ae)
+}
+
+// Detach replaces the definition nodes of the particles in a that were
+// aggregated from the tree n by the nodes fn returns for them, so that a does
+// not keep n. fn usually returns a node that holds the rendered definition.
+func Detach(a aggregator.Aggregate, n *node.Node, fn func(def *node.Node) (*node.Node, error)) error {
+	ae := Particles(a)
+	var err error
+	walk(n, func(n *node.Node) bool {
+		if err != nil {
+			return false
+		}
+		num, ok := n.Data[footnote.KeyNumber].(int)
+		if !ok {
+			return true
+		}
+		if i := search(ae, num, func(p Particle) bool { return p.Node == n }); i >= 0 {
+			ae[i].Node, err = fn(n)
+		}
+		return true
+	})
+	return err
+}
+
+// definitions appends the particles of the numbered definitions in n to ae in
+// document order.
+func (ar Aggregator) definitions(ae aggregate, n *node.Node) aggregate {
+	walk(n, func(n *node.Node) bool {
+		if ar.isTargetElement(n.Element) {
+			if num, ok := n.Data[footnote.KeyNumber].(int); ok {
+				label, _ := n.Data[footnote.KeyLabel].(string)
+				id, _ := n.Data[footnote.KeyID].(string)
+				ae = append(ae, Particle{
+					Element: n.Element,
+					Label:   label,
+					Number:  num,
+					ID:      id,
+					// copied as Merge appends to it
+					References: append([]string(nil), footnote.References(n)...),
+					Node:       n,
+				})
+			}
+		}
+		return true
+	})
+	return ae
+}
+
+// search returns the index of the particle with the given number for which
+// match returns true in ae, sorted by number, or -1 if there is none.
+func search(ae aggregate, number int, match func(p Particle) bool) int {
+	i := sort.Search(len(ae), func(i int) bool {
+		return ae[i].Number >= number
+	})
+	for ; i < len(ae) && ae[i].Number == number; i++ {
+		if match(ae[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (a Aggregator) isTargetElement(s string) bool {
+	for _, e := range a.Elements {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+type aggregate []Particle
+
+// Particles returns the particles of the given footnote aggregate in number
+// order. It returns nil if a is not a footnote aggregate.
+func Particles(a aggregator.Aggregate) []Particle {
+	if ae, ok := a.(aggregate); ok {
+		return ae
+	}
+	return nil
+}
+
+// AnAggregate implements the Aggregate interface.
+func (aggregate) AnAggregate() {}
+
+// Particle is an aggregated footnote definition.
+type Particle struct {
+	Element    string
+	Label      string
+	Number     int
+	ID         string     // definition ID, the target of the references
+	References []string   // reference IDs, the targets of the back-links
+	Node       *node.Node // the definition, its first child is the label (see Detach)
+}
+
+func walk(n *node.Node, fn func(n *node.Node) bool) {
+	if fn(n) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, fn)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package footnote
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/transformer/footnote"
+)
+
+func TestAggregate(t *testing.T) {
+	cases := []struct {
+		name string
+		in   []*node.Node
+		out  []string // particles as Element:Label:Number:ID:References
+	}{
+		{
+			"no footnotes",
+			nil,
+			nil,
+		},
+		{
+			"unnumbered",
+			[]*node.Node{
+				definition("A", "a", 0, nil),
+			},
+			nil,
+		},
+		{
+			"numbered",
+			[]*node.Node{
+				definition("A", "a", 1, []string{"fnref-a"}),
+			},
+			[]string{"A:a:1:fn-a:fnref-a"},
+		},
+		{
+			"number order",
+			[]*node.Node{
+				definition("A", "b", 2, []string{"fnref-b"}),
+				definition("A", "a", 1, []string{"fnref-a", "fnref-a-2"}),
+			},
+			[]string{
+				"A:a:1:fn-a:fnref-a,fnref-a-2",
+				"A:b:2:fn-b:fnref-b",
+			},
+		},
+		{
+			"other element",
+			[]*node.Node{
+				definition("B", "a", 1, []string{"fnref-a"}),
+			},
+			nil,
+		},
+		{
+			"references from json",
+			[]*node.Node{
+				{Element: "A", Type: node.TypeContainer, Data: node.Data{
+					footnote.KeyLabel:      "a",
+					footnote.KeyNumber:     1,
+					footnote.KeyID:         "fn-a",
+					footnote.KeyReferences: []interface{}{"fnref-a"},
+				}},
+			},
+			[]string{"A:a:1:fn-a:fnref-a"},
+		},
+		{
+			"nested",
+			[]*node.Node{
+				appendChildren(
+					&node.Node{Type: node.TypeContainer},
+					[]*node.Node{
+						definition("A", "a", 1, []string{"fnref-a"}),
+					},
+				),
+			},
+			[]string{"A:a:1:fn-a:fnref-a"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			root := appendChildren(&node.Node{Type: node.TypeContainer}, c.in)
+			particles := Particles(Aggregator{[]string{"A"}}.Aggregate(root))
+
+			var got []string
+			for _, p := range particles {
+				if p.Node == nil || p.Node.Element != p.Element {
+					t.Errorf("particle %d: unexpected node %v", p.Number, p.Node)
+				}
+				got = append(got, fmt.Sprintf("%s:%s:%d:%s:%s", p.Element, p.Label, p.Number, p.ID, strings.Join(p.References, ",")))
+			}
+			if g, w := strings.Join(got, "\n"), strings.Join(c.out, "\n"); g != w {
+				t.Errorf("\ngot\n%s\nwant\n%s", g, w)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	ar := Aggregator{[]string{"A"}}
+	a := ar.Aggregate(appendChildren(&node.Node{Type: node.TypeContainer}, []*node.Node{
+		definition("A", "b", 2, []string{"fnref-b"}),
+	}))
+	a = ar.Merge(a, appendChildren(&node.Node{Type: node.TypeContainer}, []*node.Node{
+		reference("b", 2, "fnref-b-2"),
+		definition("A", "c", 3, []string{"fnref-c"}),
+		definition("A", "a", 1, []string{"fnref-a"}),
+	}))
+
+	var got []string
+	for _, p := range Particles(a) {
+		got = append(got, fmt.Sprintf("%s:%d:%s", p.Label, p.Number, strings.Join(p.References, ",")))
+	}
+	want := []string{
+		"a:1:fnref-a",
+		"b:2:fnref-b,fnref-b-2",
+		"c:3:fnref-c",
+	}
+	if g, w := strings.Join(got, "\n"), strings.Join(want, "\n"); g != w {
+		t.Errorf("\ngot\n%s\nwant\n%s", g, w)
+	}
+}
+
+func TestDetach(t *testing.T) {
+	ar := Aggregator{[]string{"A"}}
+	first := appendChildren(&node.Node{Type: node.TypeContainer}, []*node.Node{
+		definition("A", "a", 1, []string{"fnref-a"}),
+	})
+	second := appendChildren(&node.Node{Type: node.TypeContainer}, []*node.Node{
+		definition("A", "b", 2, []string{"fnref-b"}),
+	})
+	a := ar.Merge(ar.Aggregate(first), second)
+
+	detached := &node.Node{Type: node.TypeContainer}
+	err := Detach(a, second, func(def *node.Node) (*node.Node, error) {
+		if def != second.FirstChild {
+			t.Errorf("unexpected definition %v", def)
+		}
+		return detached, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	particles := Particles(a)
+	if particles[0].Node != first.FirstChild {
+		t.Errorf("particle 1: got node %v, want the definition", particles[0].Node)
+	}
+	if particles[1].Node != detached {
+		t.Errorf("particle 2: got node %v, want the detached node", particles[1].Node)
+	}
+}
+
+func definition(element, label string, number int, references []string) *node.Node {
+	data := node.Data{
+		footnote.KeyLabel: label,
+		footnote.KeyID:    "fn-" + label,
+	}
+	if number > 0 {
+		data[footnote.KeyNumber] = number
+		data[footnote.KeyReferences] = references
+	}
+	return &node.Node{Element: element, Type: node.TypeContainer, Data: data}
+}
+
+func reference(label string, number int, id string) *node.Node {
+	return &node.Node{Element: "R", Type: node.TypeEscaped, Data: node.Data{
+		footnote.KeyLabel:  label,
+		footnote.KeyNumber: number,
+		footnote.KeyID:     id,
+		footnote.KeyTarget: "fn-" + label,
+	}}
+}
+
+func appendChildren(n *node.Node, children []*node.Node) *node.Node {
+	for _, child := range children {
+		n.AppendChild(child)
+	}
+	return n
+}
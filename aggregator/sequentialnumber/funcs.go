@@ -16,7 +16,7 @@ func (g *grouper) group(base int) group {
 	var gr group
 	for g.pos < len(g.a) {
 		p := g.a[g.pos]
-		if depth := p.depth(); depth > base {
+		if depth := p.Depth(); depth > base {
 			gr = append(gr, g.group(depth))
 		} else if depth == base {
 			gr = append(gr, p)
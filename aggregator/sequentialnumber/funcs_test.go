@@ -20,7 +20,7 @@ func TestGroup(t *testing.T) {
 				},
 			},
 			group{
-				particle{
+				Particle{
 					Element:          "A",
 					SequentialNumber: "1",
 				},
@@ -39,11 +39,11 @@ func TestGroup(t *testing.T) {
 				},
 			},
 			group{
-				particle{
+				Particle{
 					Element:          "A",
 					SequentialNumber: "1",
 				},
-				particle{
+				Particle{
 					Element:          "A",
 					SequentialNumber: "2",
 				},
@@ -62,12 +62,12 @@ func TestGroup(t *testing.T) {
 				},
 			},
 			group{
-				particle{
+				Particle{
 					Element:          "A",
 					SequentialNumber: "1",
 				},
 				group{
-					particle{
+					Particle{
 						Element:          "A",
 						SequentialNumber: "1.1",
 					},
@@ -91,17 +91,17 @@ func TestGroup(t *testing.T) {
 				},
 			},
 			group{
-				particle{
+				Particle{
 					Element:          "A",
 					SequentialNumber: "1",
 				},
 				group{
-					particle{
+					Particle{
 						Element:          "A",
 						SequentialNumber: "1.1",
 					},
 					group{
-						particle{
+						Particle{
 							Element:          "A",
 							SequentialNumber: "1.1.1",
 						},
@@ -123,12 +123,12 @@ func TestGroup(t *testing.T) {
 			},
 			group{
 				group{
-					particle{
+					Particle{
 						Element:          "A",
 						SequentialNumber: "1.1",
 					},
 				},
-				particle{
+				Particle{
 					Element:          "A",
 					SequentialNumber: "1",
 				},
@@ -152,16 +152,16 @@ func TestGroup(t *testing.T) {
 			},
 			group{
 				group{
-					particle{
+					Particle{
 						Element:          "A",
 						SequentialNumber: "1.1",
 					},
 				},
-				particle{
+				Particle{
 					Element:          "A",
 					SequentialNumber: "1",
 				},
-				particle{
+				Particle{
 					Element:          "A",
 					SequentialNumber: "2",
 				},
@@ -185,17 +185,17 @@ func TestGroup(t *testing.T) {
 			},
 			group{
 				group{
-					particle{
+					Particle{
 						Element:          "A",
 						SequentialNumber: "1.1",
 					},
 				},
-				particle{
+				Particle{
 					Element:          "A",
 					SequentialNumber: "1",
 				},
 				group{
-					particle{
+					Particle{
 						Element:          "A",
 						SequentialNumber: "2.1",
 					},
@@ -219,17 +219,17 @@ func TestGroup(t *testing.T) {
 				},
 			},
 			group{
-				particle{
+				Particle{
 					Element:          "A",
 					SequentialNumber: "1",
 				},
 				group{
-					particle{
+					Particle{
 						Element:          "A",
 						SequentialNumber: "1.1",
 					},
 				},
-				particle{
+				Particle{
 					Element:          "A",
 					SequentialNumber: "2",
 				},
@@ -256,22 +256,22 @@ func TestGroup(t *testing.T) {
 				},
 			},
 			group{
-				particle{
+				Particle{
 					Element:          "A",
 					SequentialNumber: "1",
 				},
 				group{
-					particle{
+					Particle{
 						Element:          "A",
 						SequentialNumber: "1.1",
 					},
 				},
-				particle{
+				Particle{
 					Element:          "A",
 					SequentialNumber: "2",
 				},
 				group{
-					particle{
+					Particle{
 						Element:          "A",
 						SequentialNumber: "2.1",
 					},
@@ -8,6 +8,7 @@ import (
 	"github.com/touchmarine/to/aggregator"
 	"github.com/touchmarine/to/node"
 	"github.com/touchmarine/to/transformer/sequentialnumber"
+	"github.com/touchmarine/to/transformer/slug"
 )
 
 // Aggregator aggregates sequential numbers of elements that belong to the
@@ -23,9 +24,14 @@ func (ar Aggregator) Aggregate(n *node.Node) aggregator.Aggregate {
 		if ar.isTargetElement(n.Element) {
 			if v, ok := n.Data[sequentialnumber.Key]; ok {
 				seqnum := v.(string)
-				ae = append(ae, particle{
+				id, ok := n.Data[slug.Key].(string)
+				if !ok {
+					// slugs not attached
+					id = n.TextContent()
+				}
+				ae = append(ae, Particle{
 					Element:          n.Element,
-					ID:               n.TextContent(),
+					ID:               id,
 					Text:             n.TextContent(),
 					SequentialNumber: seqnum,
 				})
@@ -36,6 +42,11 @@ func (ar Aggregator) Aggregate(n *node.Node) aggregator.Aggregate {
 	return aggregator.Aggregate(ae)
 }
 
+// Merge implements the aggregator.Merger interface.
+func (ar Aggregator) Merge(a aggregator.Aggregate, n *node.Node) aggregator.Aggregate {
+	return aggregator.Aggregate(aggregate(append(Particles(a), Particles(ar.Aggregate(n))...)))
+}
+
 func (a Aggregator) isTargetElement(s string) bool {
 	for _, e := range a.Elements {
 		if e == s {
@@ -45,19 +56,30 @@ func (a Aggregator) isTargetElement(s string) bool {
 	return false
 }
 
-type aggregate []particle
+type aggregate []Particle
+
+// Particles returns the particles of the given sequential number aggregate in
+// document order. It returns nil if a is not a sequential number aggregate.
+func Particles(a aggregator.Aggregate) []Particle {
+	if ae, ok := a.(aggregate); ok {
+		return ae
+	}
+	return nil
+}
 
 // AnAggregate implements the Aggregate interface.
 func (aggregate) AnAggregate() {}
 
-type particle struct {
+// Particle is an aggregated element.
+type Particle struct {
 	Element          string
-	ID               string
+	ID               string // slug, or text content if the element has none
 	Text             string
 	SequentialNumber string
 }
 
-func (p particle) depth() int {
+// Depth returns the number of parts in the sequential number.
+func (p Particle) Depth() int {
 	return len(strings.Split(p.SequentialNumber, "."))
 }
 
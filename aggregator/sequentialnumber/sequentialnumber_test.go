@@ -7,6 +7,7 @@ import (
 	"github.com/touchmarine/to/node"
 	"github.com/touchmarine/to/parser"
 	"github.com/touchmarine/to/transformer/sequentialnumber"
+	"github.com/touchmarine/to/transformer/slug"
 )
 
 func TestAggregate(t *testing.T) {
@@ -60,6 +61,32 @@ func TestAggregate(t *testing.T) {
 				},
 			},
 		},
+		{
+			"1 sequential number with slug",
+			appendChildren(
+				&node.Node{Element: "A", Type: node.TypeRankedHanging, Data: node.Data{
+					parser.KeyRank:       2,
+					sequentialnumber.Key: "1",
+					slug.Key:             "a-1",
+				}},
+				[]*node.Node{
+					appendChildren(
+						&node.Node{Element: "T", Type: node.TypeLeaf},
+						[]*node.Node{
+							&node.Node{Element: "MT", Type: node.TypeText, Value: "a"},
+						},
+					),
+				},
+			),
+			&aggregate{
+				{
+					Element:          "A",
+					ID:               "a-1",
+					Text:             "a",
+					SequentialNumber: "1",
+				},
+			},
+		},
 		{
 			"1 sequential number with multiple inlines",
 			appendChildren(
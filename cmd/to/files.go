@@ -0,0 +1,144 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/touchmarine/to/parser"
+)
+
+// sourceFile is a Touch file given on the command line or found in a given
+// directory.
+type sourceFile struct {
+	path string // path to the file
+	rel  string // path relative to the given directory or the file name
+}
+
+// findFiles returns the files in the given paths. Directories are walked
+// recursively for files with the given extension (e.g. .to), files are used as
+// given.
+func findFiles(paths []string, ext string) ([]sourceFile, error) {
+	var files []sourceFile
+	for _, root := range paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, sourceFile{
+				path: root,
+				rel:  filepath.Base(root),
+			})
+			continue
+		}
+
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || filepath.Ext(path) != ext {
+				return nil
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, sourceFile{
+				path: path,
+				rel:  rel,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// fileResult is the result of processing a single file.
+type fileResult struct {
+	file sourceFile
+	src  []byte           // file content
+	out  []byte           // processed content
+	warn parser.ErrorList // problems that do not fail the file
+	err  error
+}
+
+// processFiles reads and processes the files concurrently. It calls report for
+// each result in the order of the files.
+func processFiles(files []sourceFile, process func(f sourceFile, src []byte) ([]byte, parser.ErrorList, error), report func(r fileResult)) {
+	results := make([]chan fileResult, len(files))
+	for i := range results {
+		results[i] = make(chan fileResult, 1)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				r := fileResult{file: files[i]}
+				r.src, r.err = os.ReadFile(files[i].path)
+				if r.err == nil {
+					r.out, r.warn, r.err = process(files[i], r.src)
+				}
+				results[i] <- r
+			}
+		}()
+	}
+	go func() {
+		for i := range files {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	for _, c := range results {
+		report(<-c)
+	}
+	wg.Wait()
+}
+
+// writeFile writes the data to a temporary file in the same directory and
+// renames it to the given name so that the file is replaced atomically. The
+// file mode of an existing file is kept.
+func writeFile(name string, data []byte) error {
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(name); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.CreateTemp(dir, "."+base+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // no-op after successful rename
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -7,6 +7,13 @@
 // 	build  	convert Touch formatted text
 // 	fmt    	format Touch formatted text (prettify)
 // 	tree   	print node tree
+// 	meta   	print document metadata
+// 	query  	find elements by selector
+// 	lsp    	run the language server
+// 	import 	convert other formats to Touch formatted text
+// 	site   	generate a static site
+// 	serve  	serve a live preview
+// 	config 	inspect configs
 // 	tool    run specified Touch tool
 // 	help   	print help
 // 	version	print version
@@ -15,28 +22,36 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"html/template"
 	"io"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 
-	"github.com/touchmarine/to/aggregator"
-	seqnumaggregator "github.com/touchmarine/to/aggregator/sequentialnumber"
 	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/importer/markdown"
+	"github.com/touchmarine/to/internal/diff"
+	"github.com/touchmarine/to/lsp"
 	"github.com/touchmarine/to/matcher"
 	"github.com/touchmarine/to/node"
 	"github.com/touchmarine/to/parser"
 	"github.com/touchmarine/to/printer"
-	totemplate "github.com/touchmarine/to/template"
+	"github.com/touchmarine/to/query"
+	"github.com/touchmarine/to/registry"
+	"github.com/touchmarine/to/render"
+	"github.com/touchmarine/to/serve"
+	"github.com/touchmarine/to/site"
 	"github.com/touchmarine/to/tools/extjson"
+	"github.com/touchmarine/to/tools/textmate"
 	"github.com/touchmarine/to/transformer"
-	"github.com/touchmarine/to/transformer/group"
-	"github.com/touchmarine/to/transformer/paragraph"
-	"github.com/touchmarine/to/transformer/sequentialnumber"
-	"github.com/touchmarine/to/transformer/sticky"
+	"github.com/touchmarine/to/transformer/filter"
+	"github.com/touchmarine/to/transformer/metadata"
 )
 
 const version = "1.0.0-beta.1"
@@ -64,13 +79,15 @@ func main() {
 	cmd, args := args[0], args[1:]
 
 	switch cmd {
-	case "build", "fmt", "tree":
+	case "build", "fmt", "tree", "meta", "query", "lsp", "import", "site", "serve", "config":
 		var (
 			configs  string
+			shallow  bool
 			tabWidth int
 		)
 		registerWorkFlags := func(fs *flag.FlagSet) {
 			fs.StringVar(&configs, "config", "", "comma-separated list of configs to use")
+			fs.BoolVar(&shallow, "shallow", false, "shallow merge configs (replace whole objects)")
 			fs.IntVar(&tabWidth, "tabwidth", 0, "tab=tabwidth x spaces") // default set in parse()
 		}
 
@@ -80,7 +97,7 @@ func main() {
 				fmt.Fprintln(os.Stderr, strings.TrimSpace(`
 to build: missing <format>
 
-usage:   to build <format> [options] stdin
+usage:   to build <format> [options] [path ...]
 example: to build html < file.to
 Run 'to help build' for details.
 `))
@@ -92,110 +109,317 @@ Run 'to help build' for details.
 			fs := flag.NewFlagSet("to build", flag.ContinueOnError)
 			fs.Usage = func() {
 				fmt.Fprintln(os.Stderr, strings.TrimSpace(`
-usage: to build <format> [options] stdin
+usage: to build <format> [options] [path ...]
 Run 'to help build' for details.
 `))
 			}
+			outDir := fs.String("o", "", "output directory")
+			input := fs.String("input", "to", "input format: to or json")
+			var filterCommands []string
+			fs.Func("filter", "external filter to pipe the tree through (repeatable)", func(s string) error {
+				filterCommands = append(filterCommands, s)
+				return nil
+			})
+			sourceMap := fs.Bool("sourcemap", false, "annotate the output with source lines and write source maps")
+			stream := fs.Bool("stream", false, "render the top-level blocks of stdin as they are read")
 			registerWorkFlags(fs)
 			if err := fs.Parse(args); err != nil {
 				os.Exit(2)
 				return
 			}
-			args = fs.Args()
-			if len(args) > 0 {
+			paths := fs.Args()
+			ext := inputExtension("to build", *input) // exits on error
+			if *stream && (len(paths) > 0 || *input != "to" || len(filterCommands) > 0 || *sourceMap) {
 				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
-to build %s: unexpected arguments: %s
+to build %s: -stream reads only stdin and cannot be used with -input json, -filter, or -sourcemap
 Run 'to help build' for details.
-`)+"\n", format, strings.Join(args, " "))
+`)+"\n", format)
 				os.Exit(2)
 				return
 			}
 
-			if isStdinEmpty() {
-				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+			if len(paths) == 0 {
+				if *outDir != "" {
+					fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to build %s: cannot use -o with standard input
+Run 'to help build' for details.
+`)+"\n", format)
+					os.Exit(2)
+					return
+				}
+				if isStdinEmpty() {
+					fmt.Fprintf(os.Stderr, strings.TrimSpace(`
 to build: empty stdin
 
-usage:   to build <format> [options] stdin
+usage:   to build <format> [options] [path ...]
 example: to build html < file.to
 Run 'to help build' for details.
 `)+"\n")
-				os.Exit(2)
+					os.Exit(2)
+					return
+				}
+			}
+
+			cfg, prov := loadConfig(configs, shallow) // exits on error
+			elements := cfg.Elements.ParserElements()
+			t := transformers(cfg.Elements)        // exits on error
+			renderer := newRenderer(cfg, tabWidth) // exits on error
+			filters := filterChain(cfg, prov, filterCommands, format)
+
+			if *stream {
+				if len(filters) > 0 {
+					fmt.Fprintf(os.Stderr, "to build %s: cannot use -stream with the filters of the config\n", format)
+					os.Exit(2)
+					return
+				}
+				if err := renderer.RenderStream(context.Background(), os.Stdin, format, os.Stdout); err != nil {
+					parser.PrintError(os.Stderr, err)
+					os.Exit(1)
+					return
+				}
 				return
 			}
 
-			cfg := &config.Default
-			for _, p := range strings.Split(configs, ",") {
-				if p == "" {
-					continue
+			if len(paths) == 0 {
+				src, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "read stdint failed: %v\n", err)
+					os.Exit(1)
+					return
+				}
+				root, err := parseInput(*input, "", src, elements, tabWidth)
+				if err != nil {
+					parser.PrintErrorSource(os.Stderr, src, err)
+					os.Exit(1)
+					return
+				}
+				if *input == "to" {
+					root = t.Transform(root)
+					problems := check(t, root, "")
+					parser.PrintErrorSource(os.Stderr, src, problems)
+					if hasErrors(problems) {
+						os.Exit(1)
+						return
+					}
+				}
+				root, err = filters.Run(root)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "to build %s: %v\n", format, err)
+					os.Exit(1)
+					return
+				}
+
+				if _, err := build(renderer, root, format, os.Stdout, *sourceMap); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+					return
 				}
-				c := jsonDecodeConfigFile(p) // exits on error
-				config.ShallowMerge(cfg, c)
+				return
 			}
-			src, err := io.ReadAll(os.Stdin)
+
+			files, err := findFiles(paths, ext)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "read stdint failed: %v\n", err)
+				fmt.Fprintf(os.Stderr, "to build %s: %v\n", format, err)
 				os.Exit(1)
 				return
 			}
-			root := parse(src, cfg.Elements.ParserElements(), tabWidth)
-			root = transformers(cfg.Elements).Transform(root)
+			if *outDir == "" && (len(paths) > 1 || len(files) != 1 || files[0].path != paths[0]) {
+				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to build %s: -o is required to build directories or multiple files
+Run 'to help build' for details.
+`)+"\n", format)
+				os.Exit(2)
+				return
+			}
+
+			var (
+				mu   sync.Mutex
+				maps = map[string][]byte{} // source maps by file path
+			)
+			exitCode := 0
+			processFiles(files, func(f sourceFile, src []byte) ([]byte, parser.ErrorList, error) {
+				root, err := parseInput(*input, f.path, src, elements, tabWidth)
+				if err != nil {
+					return nil, nil, err
+				}
+				var warn parser.ErrorList
+				if *input == "to" {
+					root = t.Transform(root)
+					warn = check(t, root, f.path)
+					if hasErrors(warn) {
+						return nil, nil, warn
+					}
+				}
+				root, err = filters.Run(root)
+				if err != nil {
+					return nil, warn, fmt.Errorf("%s: %w", f.path, err)
+				}
 
-			build(cfg, root, format) // exits on error
+				var b bytes.Buffer
+				sm, err := build(renderer, root, format, &b, *sourceMap)
+				if err != nil {
+					return nil, warn, fmt.Errorf("%s: %w", f.path, err)
+				}
+				if sm != nil && *outDir != "" {
+					j, err := json.Marshal(sm)
+					if err != nil {
+						return nil, warn, fmt.Errorf("%s: %w", f.path, err)
+					}
+					mu.Lock()
+					maps[f.path] = j
+					mu.Unlock()
+				}
+				return b.Bytes(), warn, nil
+			}, func(r fileResult) {
+				parser.PrintErrorSource(os.Stderr, r.src, r.warn)
+				if r.err != nil {
+					parser.PrintErrorSource(os.Stderr, r.src, r.err)
+					exitCode = 1
+					return
+				}
+				if *outDir == "" {
+					os.Stdout.Write(r.out)
+					return
+				}
+				name := filepath.Join(*outDir, strings.TrimSuffix(r.file.rel, filepath.Ext(r.file.rel))+cfg.Extension(format))
+				if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+					fmt.Fprintf(os.Stderr, "to build %s: %v\n", format, err)
+					exitCode = 1
+					return
+				}
+				if err := writeFile(name, r.out); err != nil {
+					fmt.Fprintf(os.Stderr, "to build %s: %v\n", format, err)
+					exitCode = 1
+					return
+				}
+				mu.Lock()
+				j, ok := maps[r.file.path]
+				mu.Unlock()
+				if ok {
+					if err := writeFile(name+".map.json", j); err != nil {
+						fmt.Fprintf(os.Stderr, "to build %s: %v\n", format, err)
+						exitCode = 1
+						return
+					}
+				}
+			})
+			if exitCode != 0 {
+				os.Exit(exitCode)
+			}
 			return
 		case "fmt":
 			fs := flag.NewFlagSet("to fmt", flag.ContinueOnError)
 			fs.Usage = func() {
 				fmt.Fprintln(os.Stderr, strings.TrimSpace(`
-usage: to fmt [options] stdin
+usage: to fmt [options] [path ...]
 Run 'to help fmt' for details.
 `))
 			}
 			lineLength := fs.Int("linelength", 0, "prose line length (hard-wrap)")
+			var opts fmtOptions
+			fs.BoolVar(&opts.write, "w", false, "write result to source files instead of stdout")
+			fs.BoolVar(&opts.list, "l", false, "list files whose formatting differs")
+			fs.BoolVar(&opts.diff, "d", false, "display diffs instead of rewriting files")
+			input := fs.String("input", "to", "input format: to or json")
 			registerWorkFlags(fs)
 			if err := fs.Parse(args); err != nil {
 				os.Exit(2)
 				return
 			}
-			args := fs.Args()
-			if len(args) > 0 {
+			paths := fs.Args()
+			ext := inputExtension("to fmt", *input) // exits on error
+			if *input != "to" && (opts.write || opts.list || opts.diff) {
 				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
-to fmt: unexpected arguments: %s
+to fmt: cannot use -w, -l, or -d with -input %s
 Run 'to help fmt' for details.
-`)+"\n", strings.Join(args, " "))
+`)+"\n", *input)
 				os.Exit(2)
 				return
 			}
 
-			if isStdinEmpty() {
-				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+			if len(paths) == 0 {
+				if opts.write {
+					fmt.Fprintln(os.Stderr, strings.TrimSpace(`
+to fmt: cannot use -w with standard input
+Run 'to help fmt' for details.
+`))
+					os.Exit(2)
+					return
+				}
+				if isStdinEmpty() {
+					fmt.Fprintf(os.Stderr, strings.TrimSpace(`
 to fmt: empty stdin
 
-usage:   to fmt [options] stdin
+usage:   to fmt [options] [path ...]
 example: to fmt < file.to
 Run 'to help fmt' for details.
 `)+"\n")
-				os.Exit(2)
-				return
+					os.Exit(2)
+					return
+				}
 			}
 
-			cfg := &config.Default
-			for _, p := range strings.Split(configs, ",") {
-				if p == "" {
-					continue
+			cfg, _ := loadConfig(configs, shallow) // exits on error
+			elements := cfg.Elements.ParserElements()
+			t := transformers(cfg.Elements) // exits on error
+			process := func(uri string, src []byte) ([]byte, error) {
+				return formatSource(*input, uri, src, elements, tabWidth, t, *lineLength)
+			}
+
+			if len(paths) == 0 {
+				src, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "read stdint failed: %v\n", err)
+					os.Exit(1)
+					return
+				}
+				out, err := process("", src)
+				if err != nil {
+					parser.PrintErrorSource(os.Stderr, src, err)
+					os.Exit(1)
+					return
 				}
-				c := jsonDecodeConfigFile(p) // exits on error
-				config.ShallowMerge(cfg, c)
+				changed, err := reportFormatted("<standard input>", src, out, opts)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "to fmt: %v\n", err)
+					os.Exit(1)
+					return
+				}
+				if changed && opts.reportsChanges() {
+					os.Exit(3)
+				}
+				return
 			}
-			src, err := io.ReadAll(os.Stdin)
+
+			files, err := findFiles(paths, ext)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "read stdint failed: %v\n", err)
+				fmt.Fprintf(os.Stderr, "to fmt: %v\n", err)
 				os.Exit(1)
 				return
 			}
-			root := parse(src, cfg.Elements.ParserElements(), tabWidth)
-			root = transformers(cfg.Elements).Transform(root)
-
-			format(cfg.Elements.ParserElements(), *lineLength, root) // exits on error
+			exitCode := 0
+			processFiles(files, func(f sourceFile, src []byte) ([]byte, parser.ErrorList, error) {
+				out, err := process(f.path, src)
+				return out, nil, err
+			}, func(r fileResult) {
+				if r.err != nil {
+					parser.PrintErrorSource(os.Stderr, r.src, r.err)
+					exitCode = 1
+					return
+				}
+				changed, err := reportFormatted(r.file.path, r.src, r.out, opts)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "to fmt: %v\n", err)
+					exitCode = 1
+					return
+				}
+				if changed && opts.reportsChanges() && exitCode == 0 {
+					exitCode = 3
+				}
+			})
+			if exitCode != 0 {
+				os.Exit(exitCode)
+			}
 			return
 		case "tree":
 			fs := flag.NewFlagSet("to tree", flag.ContinueOnError)
@@ -206,6 +430,7 @@ Run 'to help tree' for details.
 `))
 			}
 			modes := fs.String("mode", "", "comma-separated list of modes to use")
+			format := fs.String("format", "text", "output format: text or json")
 			registerWorkFlags(fs)
 			if err := fs.Parse(args); err != nil {
 				os.Exit(2)
@@ -233,29 +458,563 @@ Run 'to help tree' for details.
 				return
 			}
 
-			cfg := &config.Default
-			for _, p := range strings.Split(configs, ",") {
-				if p == "" {
-					continue
+			cfg, _ := loadConfig(configs, shallow) // exits on error
+			src, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "read stdint failed: %v\n", err)
+				os.Exit(1)
+				return
+			}
+			root := parse(src, cfg.Elements.ParserElements(), tabWidth)
+			root = transformers(cfg.Elements).Transform(root)
+
+			switch *format {
+			case "text":
+				var m []string
+				if *modes != "" {
+					m = strings.Split(*modes, ",")
+				}
+				tree(root, m) // exits on error
+			case "json":
+				if *modes != "" {
+					fmt.Fprintln(os.Stderr, strings.TrimSpace(`
+to tree: cannot use -mode with -format json
+Run 'to help tree' for details.
+`))
+					os.Exit(2)
+					return
+				}
+				if err := node.IndentJSON(os.Stdout, root, "\t"); err != nil {
+					fmt.Fprintf(os.Stderr, "print tree failed: %v\n", err)
+					os.Exit(1)
+					return
+				}
+			default:
+				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to tree: invalid format: %q
+
+valid formats: text, json
+Run 'to help tree' for details.
+`)+"\n", *format)
+				os.Exit(2)
+				return
+			}
+			return
+		case "meta":
+			fs := flag.NewFlagSet("to meta", flag.ContinueOnError)
+			fs.Usage = func() {
+				fmt.Fprintln(os.Stderr, strings.TrimSpace(`
+usage: to meta [options] stdin
+Run 'to help meta' for details.
+`))
+			}
+			registerWorkFlags(fs)
+			if err := fs.Parse(args); err != nil {
+				os.Exit(2)
+				return
+			}
+			args := fs.Args()
+			if len(args) > 0 {
+				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to meta: unexpected arguments: %s
+Run 'to help meta' for details.
+`)+"\n", strings.Join(args, " "))
+				os.Exit(2)
+				return
+			}
+
+			if isStdinEmpty() {
+				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to meta: empty stdin
+
+usage:   to meta [options] stdin
+example: to meta < file.to
+Run 'to help meta' for details.
+`)+"\n")
+				os.Exit(2)
+				return
+			}
+
+			cfg, _ := loadConfig(configs, shallow) // exits on error
+			src, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "read stdin failed: %v\n", err)
+				os.Exit(1)
+				return
+			}
+			root := parse(src, cfg.Elements.ParserElements(), tabWidth)
+			root = transformers(cfg.Elements).Transform(root)
+
+			meta := root.Data[metadata.Key]
+			if meta == nil {
+				// no metadata elements
+				meta = map[string]interface{}{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetEscapeHTML(false)
+			enc.SetIndent("", "\t")
+			if err := enc.Encode(meta); err != nil {
+				fmt.Fprintf(os.Stderr, "print metadata failed: %v\n", err)
+				os.Exit(1)
+				return
+			}
+			return
+		case "query":
+			if len(args) < 1 {
+				fmt.Fprintln(os.Stderr, strings.TrimSpace(`
+to query: missing <selector>
+
+usage:   to query <selector> [options] [path ...]
+example: to query 'CodeBlock[openingText=go]' < file.to
+Run 'to help query' for details.
+`))
+				os.Exit(2)
+				return
+			}
+			selector, args := args[0], args[1:]
+			sel, err := query.Compile(selector)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to query: invalid selector %q: %v
+Run 'to help query' for details.
+`)+"\n", selector, err)
+				os.Exit(2)
+				return
+			}
+
+			fs := flag.NewFlagSet("to query", flag.ContinueOnError)
+			fs.Usage = func() {
+				fmt.Fprintln(os.Stderr, strings.TrimSpace(`
+usage: to query <selector> [options] [path ...]
+Run 'to help query' for details.
+`))
+			}
+			format := fs.String("format", "text", "output format: text, location, or json")
+			registerWorkFlags(fs)
+			if err := fs.Parse(args); err != nil {
+				os.Exit(2)
+				return
+			}
+			paths := fs.Args()
+			switch *format {
+			case "text", "location", "json":
+			default:
+				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to query: invalid format: %q
+
+valid formats: text, location, json
+Run 'to help query' for details.
+`)+"\n", *format)
+				os.Exit(2)
+				return
+			}
+
+			if len(paths) == 0 && isStdinEmpty() {
+				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to query: empty stdin
+
+usage:   to query <selector> [options] [path ...]
+example: to query 'CodeBlock[openingText=go]' < file.to
+Run 'to help query' for details.
+`)+"\n")
+				os.Exit(2)
+				return
+			}
+
+			cfg, _ := loadConfig(configs, shallow) // exits on error
+			elements := cfg.Elements.ParserElements()
+			t := transformers(cfg.Elements) // exits on error
+			process := func(uri string, src []byte) ([]byte, error) {
+				root, err := parseFile(uri, src, elements, tabWidth)
+				if err != nil {
+					return nil, err
+				}
+				root = t.Transform(root)
+
+				var b bytes.Buffer
+				if err := printMatches(&b, sel.All(root), *format, uri); err != nil {
+					return nil, fmt.Errorf("print matches failed: %w", err)
+				}
+				return b.Bytes(), nil
+			}
+
+			if len(paths) == 0 {
+				src, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "read stdint failed: %v\n", err)
+					os.Exit(1)
+					return
+				}
+				out, err := process("", src)
+				if err != nil {
+					parser.PrintErrorSource(os.Stderr, src, err)
+					os.Exit(1)
+					return
+				}
+				os.Stdout.Write(out)
+				return
+			}
+
+			files, err := findFiles(paths, ".to")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "to query: %v\n", err)
+				os.Exit(1)
+				return
+			}
+			exitCode := 0
+			processFiles(files, func(f sourceFile, src []byte) ([]byte, parser.ErrorList, error) {
+				out, err := process(f.path, src)
+				return out, nil, err
+			}, func(r fileResult) {
+				if r.err != nil {
+					parser.PrintErrorSource(os.Stderr, r.src, r.err)
+					exitCode = 1
+					return
+				}
+				os.Stdout.Write(r.out)
+			})
+			if exitCode != 0 {
+				os.Exit(exitCode)
+			}
+			return
+		case "lsp":
+			fs := flag.NewFlagSet("to lsp", flag.ContinueOnError)
+			fs.Usage = func() {
+				fmt.Fprintln(os.Stderr, strings.TrimSpace(`
+usage: to lsp [options]
+Run 'to help lsp' for details.
+`))
+			}
+			lineLength := fs.Int("linelength", 0, "prose line length (hard-wrap)")
+			registerWorkFlags(fs)
+			if err := fs.Parse(args); err != nil {
+				os.Exit(2)
+				return
+			}
+			args := fs.Args()
+			if len(args) > 0 {
+				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to lsp: unexpected arguments: %s
+Run 'to help lsp' for details.
+`)+"\n", strings.Join(args, " "))
+				os.Exit(2)
+				return
+			}
+
+			cfg, _ := loadConfig(configs, shallow) // exits on error
+			if tabWidth <= 0 {
+				tabWidth = 8
+			}
+
+			s := lsp.Server{
+				Name:        "to",
+				Version:     version,
+				Config:      cfg,
+				Matchers:    matchers(),
+				Transformer: transformers(cfg.Elements),
+				TabWidth:    tabWidth,
+				LineLength:  *lineLength,
+			}
+			if err := s.Serve(os.Stdin, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "to lsp: %v\n", err)
+				os.Exit(1)
+				return
+			}
+			return
+		case "import":
+			if len(args) < 1 {
+				fmt.Fprintln(os.Stderr, strings.TrimSpace(`
+to import: missing <format>
+
+usage:   to import <format> [options] stdin
+example: to import markdown < file.md > file.to
+Run 'to help import' for details.
+`))
+				os.Exit(2)
+				return
+			}
+			from, args := args[0], args[1:]
+			if from != "markdown" {
+				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to import %s: unsupported format
+Run 'to help import' for details.
+`)+"\n", from)
+				os.Exit(2)
+				return
+			}
+
+			fs := flag.NewFlagSet("to import", flag.ContinueOnError)
+			fs.Usage = func() {
+				fmt.Fprintln(os.Stderr, strings.TrimSpace(`
+usage: to import <format> [options] stdin
+Run 'to help import' for details.
+`))
+			}
+			lineLength := fs.Int("linelength", 0, "prose line length (hard-wrap)")
+			fs.StringVar(&configs, "config", "", "comma-separated list of configs to use")
+			fs.BoolVar(&shallow, "shallow", false, "shallow merge configs (replace whole objects)")
+			if err := fs.Parse(args); err != nil {
+				os.Exit(2)
+				return
+			}
+			args = fs.Args()
+			if len(args) > 0 {
+				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to import %s: unexpected arguments: %s
+Run 'to help import' for details.
+`)+"\n", from, strings.Join(args, " "))
+				os.Exit(2)
+				return
+			}
+
+			if isStdinEmpty() {
+				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to import: empty stdin
+
+usage:   to import <format> [options] stdin
+example: to import markdown < file.md > file.to
+Run 'to help import' for details.
+`)+"\n")
+				os.Exit(2)
+				return
+			}
+
+			cfg, _ := loadConfig(configs, shallow) // exits on error
+			for _, name := range markdown.Elements {
+				if e, ok := cfg.Elements[name]; !ok || e.Disabled {
+					fmt.Fprintf(os.Stderr, "to import %s: config is missing element %q\n", from, name)
+					os.Exit(2)
+					return
+				}
+			}
+			src, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "read stdint failed: %v\n", err)
+				os.Exit(1)
+				return
+			}
+			root, warnings := markdown.Import(src)
+			for _, w := range warnings {
+				fmt.Fprintf(os.Stderr, "to import %s: %s\n", from, w)
+			}
+
+			format(cfg.Elements.ParserElements(), *lineLength, root) // exits on error
+			return
+		case "site":
+			fs := flag.NewFlagSet("to site", flag.ContinueOnError)
+			fs.Usage = func() {
+				fmt.Fprintln(os.Stderr, strings.TrimSpace(`
+usage: to site [options] <srcdir> <outdir>
+Run 'to help site' for details.
+`))
+			}
+			layout := fs.String("layout", "", "layout template file")
+			toc := fs.String("toc", strings.Join(site.DefaultTOC, ","), "comma-separated list of table of contents elements")
+			registerWorkFlags(fs)
+			if err := fs.Parse(args); err != nil {
+				os.Exit(2)
+				return
+			}
+			args := fs.Args()
+			if len(args) != 2 {
+				fmt.Fprintln(os.Stderr, strings.TrimSpace(`
+to site: expected <srcdir> and <outdir>
+
+usage:   to site [options] <srcdir> <outdir>
+example: to site docs public
+Run 'to help site' for details.
+`))
+				os.Exit(2)
+				return
+			}
+
+			cfg, _ := loadConfig(configs, shallow) // exits on error
+			s := site.Site{
+				Config:   cfg,
+				Matchers: matchers(), // exits on error
+				TabWidth: tabWidth,
+				TOC:      []string{},
+			}
+			for _, e := range strings.Split(*toc, ",") {
+				if e != "" {
+					s.TOC = append(s.TOC, e)
+				}
+			}
+			if *layout != "" {
+				b, err := os.ReadFile(*layout)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "to site: %v\n", err)
+					os.Exit(2)
+					return
+				}
+				s.Layout = string(b)
+			}
+			if err := s.Build(args[0], args[1]); err != nil {
+				parser.PrintError(os.Stderr, err)
+				os.Exit(1)
+				return
+			}
+			return
+		case "serve":
+			fs := flag.NewFlagSet("to serve", flag.ContinueOnError)
+			fs.Usage = func() {
+				fmt.Fprintln(os.Stderr, strings.TrimSpace(`
+usage: to serve [options] [dir]
+Run 'to help serve' for details.
+`))
+			}
+			addr := fs.String("addr", "localhost:8080", "address to listen on")
+			registerWorkFlags(fs)
+			if err := fs.Parse(args); err != nil {
+				os.Exit(2)
+				return
+			}
+			args := fs.Args()
+			if len(args) > 1 {
+				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to serve: unexpected arguments: %s
+Run 'to help serve' for details.
+`)+"\n", strings.Join(args[1:], " "))
+				os.Exit(2)
+				return
+			}
+			dir := "."
+			if len(args) == 1 {
+				dir = args[0]
+			}
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				fmt.Fprintf(os.Stderr, "to serve: not a directory: %s\n", dir)
+				os.Exit(2)
+				return
+			}
+
+			m := matchers() // exits on error
+			s := &serve.Server{
+				Dir: dir,
+				Load: func() (*render.Renderer, []string, error) {
+					cfg, _, files, err := readConfig(configs, shallow)
+					if err != nil {
+						return nil, files, err
+					}
+					r, err := render.New(cfg, render.Options{
+						Matchers: m,
+						TabWidth: tabWidth,
+					})
+					if err != nil {
+						return nil, files, fmt.Errorf("invalid config: %w", err)
+					}
+					return r, files, nil
+				},
+			}
+			go s.Watch(context.Background())
+
+			fmt.Fprintf(os.Stderr, "serving %s at http://%s/\n", dir, *addr)
+			if err := http.ListenAndServe(*addr, s); err != nil {
+				fmt.Fprintf(os.Stderr, "to serve: %v\n", err)
+				os.Exit(1)
+				return
+			}
+			return
+		case "config":
+			if len(args) < 1 {
+				fmt.Fprintln(os.Stderr, strings.TrimSpace(`
+to config: missing <command>
+
+usage:   to config <command> [options]
+example: to config check -config to.json
+Run 'to help config' for details.
+`))
+				os.Exit(2)
+				return
+			}
+			sub, args := args[0], args[1:]
+
+			switch sub {
+			case "check":
+				fs := flag.NewFlagSet("to config check", flag.ContinueOnError)
+				fs.Usage = func() {
+					fmt.Fprintln(os.Stderr, strings.TrimSpace(`
+usage: to config check [options]
+Run 'to help config' for details.
+`))
+				}
+				formats := fs.String("format", "", "comma-separated list of formats to check")
+				registerWorkFlags(fs)
+				if err := fs.Parse(args); err != nil {
+					os.Exit(2)
+					return
+				}
+				if args := fs.Args(); len(args) > 0 {
+					fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to config check: unexpected arguments: %s
+Run 'to help config' for details.
+`)+"\n", strings.Join(args, " "))
+					os.Exit(2)
+					return
+				}
+
+				cfg, prov := loadConfig(configs, shallow) // exits on error
+				var f []string
+				if *formats != "" {
+					f = strings.Split(*formats, ",")
+				}
+				errs := cfg.Validate(registry.Types(), f, prov)
+				for _, err := range errs {
+					fmt.Fprintln(os.Stderr, err)
+				}
+				if len(errs) > 0 {
+					os.Exit(1)
+					return
 				}
-				c := jsonDecodeConfigFile(p) // exits on error
-				config.ShallowMerge(cfg, c)
-			}
-			src, err := io.ReadAll(os.Stdin)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "read stdint failed: %v\n", err)
-				os.Exit(1)
 				return
-			}
-			root := parse(src, cfg.Elements.ParserElements(), tabWidth)
-			root = transformers(cfg.Elements).Transform(root)
+			case "show":
+				fs := flag.NewFlagSet("to config show", flag.ContinueOnError)
+				fs.Usage = func() {
+					fmt.Fprintln(os.Stderr, strings.TrimSpace(`
+usage: to config show [options]
+Run 'to help config' for details.
+`))
+				}
+				registerWorkFlags(fs)
+				if err := fs.Parse(args); err != nil {
+					os.Exit(2)
+					return
+				}
+				if args := fs.Args(); len(args) > 0 {
+					fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to config show: unexpected arguments: %s
+Run 'to help config' for details.
+`)+"\n", strings.Join(args, " "))
+					os.Exit(2)
+					return
+				}
 
-			var m []string
-			if *modes != "" {
-				m = strings.Split(*modes, ",")
+				cfg, prov := loadConfig(configs, shallow) // exits on error
+				if err := showProvenance(os.Stdout, cfg, prov); err != nil {
+					fmt.Fprintf(os.Stderr, "to config show: %v\n", err)
+					os.Exit(1)
+					return
+				}
+				return
+			case "schema":
+				if len(args) > 0 {
+					fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to config schema: unexpected arguments: %s
+Run 'to help config' for details.
+`)+"\n", strings.Join(args, " "))
+					os.Exit(2)
+					return
+				}
+				os.Stdout.Write(config.Schema)
+				return
+			default:
+				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to config %s: unknown command
+Run 'to help config' for details.
+`)+"\n", sub)
+				os.Exit(2)
+				return
 			}
-			tree(root, m) // exits on error
-			return
 		default:
 			panic("unexpected cmd " + cmd)
 		}
@@ -293,6 +1052,37 @@ Run 'to help tool extjson' for details.
 			}
 
 			extjson.Convert(os.Stdout, os.Stdin)
+		case "textmate":
+			fs := flag.NewFlagSet("to tool textmate", flag.ContinueOnError)
+			fs.Usage = func() {
+				fmt.Fprintln(os.Stderr, strings.TrimSpace(`
+usage: to tool textmate [options]
+Run 'to help tool textmate' for details.
+`))
+			}
+			scopeName := fs.String("scope", textmate.DefaultScopeName, "scope name of the grammar")
+			configs := fs.String("config", "", "comma-separated list of configs to use")
+			shallow := fs.Bool("shallow", false, "shallow merge configs (replace whole objects)")
+			if err := fs.Parse(args); err != nil {
+				os.Exit(2)
+				return
+			}
+			args = fs.Args()
+			if len(args) > 0 {
+				fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+to tool textmate: unexpected arguments: %s
+Run 'to help tool textmate' for details.
+`)+"\n", strings.Join(args, " "))
+				os.Exit(2)
+				return
+			}
+
+			cfg, _ := loadConfig(*configs, *shallow) // exits on error
+			if err := textmate.New(cfg.Elements, *scopeName).Encode(os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "write grammar failed: %v\n", err)
+				os.Exit(1)
+				return
+			}
 		default:
 			fmt.Fprintf(os.Stderr, strings.TrimSpace(`
 to tool %s: unknown tool
@@ -312,39 +1102,127 @@ Run 'to help tool'.
 		switch cmd {
 		case "build":
 			fmt.Println(strings.TrimSpace(`
-usage:   to build <format> [options] stdin
+usage:   to build <format> [options] [path ...]
 example: to build html < file.to
+         to build html -o site docs
 
-Build converts Touch formatted text to the given format.
+Build converts Touch formatted text to the given format. The default
+config includes the html and markdown formats.
+
+Without paths, build reads from stdin and writes to stdout. Given a
+single file, it writes to stdout unless -o is set. Directories are
+walked recursively for .to files. Files are processed concurrently.
+
+Problems found by the transformers are reported on stderr. Errors, such
+as unresolved references to labels, stop the build of the file; warnings,
+such as references to undefined footnotes, do not.
 
 Options:
 	-config file,list
-		a comma-separated list of configs to use. Configs are
-		shallow merged (sequentially) into the default config.
-		(Shallow merge adds or overrides only whole objects, it
-		cannot override specific properties.)
+		a comma-separated list of configs (.json or .extjson) to
+		use. Configs are deep merged (sequentially) into the
+		default config: objects are merged property by property
+		and null deletes a property (e.g. an element or a
+		template). Configs can extend other configs with the
+		"Extends" property. Without -config, the nearest
+		to.extjson or to.json in the working directory or its
+		parents is used.
+	-shallow
+		shallow merge the configs instead. (Shallow merge adds
+		or overrides only whole objects, it cannot override
+		specific properties.)
 	-tabwidth int
 		tab=<tabwidth> x spaces (default=8)
+	-o dir
+		write the built files to dir, keeping their paths
+		relative to the given directories; required when
+		building directories or multiple files
+	-input format
+		input format: to (default) or json—node trees as
+		printed by "to tree -format json", which are used
+		as-is (not transformed again); directories are walked
+		for .json files
+	-filter command
+		pipe the node tree through the external command after
+		the transformers; can be repeated—filters run in order,
+		after the filters declared in the config's "Filters"
+	-sourcemap
+		annotate the block elements in the output with their
+		source lines (data-to-line="3-5"); with -o, also write
+		the source map of each built file, mapping output byte
+		offsets to source ranges, to <file>.map.json; HTML
+		formats only
+	-stream
+		render stdin while it is read, for documents too large
+		to hold in memory (see below)
+
+Filters read the node tree, encoded as by "to tree -format json", from
+stdin and write the transformed tree, in the same encoding, to stdout.
+The output format is passed in the TO_FORMAT environment variable. A
+filter that exits with a non-zero status fails the build. For example:
+	to build html -filter ./smallcaps.py < file.to
+
+With -stream, each top-level block is rendered as soon as the following
+lines show that it is complete, in batches of blocks that transformers
+may join (e.g. list items). Sequential numbers, heading slugs, footnote
+numbers, and figure numbers continue from batch to batch and the root
+template is rendered around the batches, so the output is the same as
+without -stream unless a block depends on later ones: a reference to a
+label defined later is not resolved and a reference to a footnote that
+is never defined is numbered. Transformer problems are not reported.
+
+Exit status is 0 on success, 1 if any file failed to build, and 2 on
+usage errors.
 `))
 			return
 		case "fmt":
 			fmt.Println(strings.TrimSpace(`
-usage:           to fmt [options] stdin
-format in place: to fmt < file.to 1<> file.to
+usage:           to fmt [options] [path ...]
+format in place: to fmt -w file.to
+check a project: to fmt -l .
 
 Fmt formats Touch formatted text into its canonical form. Fmt is like
 what is commonly known as prettify, but opinionated.
 
+Without paths, fmt reads from stdin. Directories are walked recursively
+for .to files. Files are processed concurrently. By default, the
+formatted text is written to stdout.
+
 Options:
 	-config file,list
-		a comma-separated list of configs to use. Configs are
-		shallow merged (sequentially) into the default config.
-		(Shallow merge adds or overrides only whole objects, it
-		cannot override specific properties.)
+		a comma-separated list of configs (.json or .extjson) to
+		use. Configs are deep merged (sequentially) into the
+		default config: objects are merged property by property
+		and null deletes a property (e.g. an element or a
+		template). Configs can extend other configs with the
+		"Extends" property. Without -config, the nearest
+		to.extjson or to.json in the working directory or its
+		parents is used.
+	-shallow
+		shallow merge the configs instead. (Shallow merge adds
+		or overrides only whole objects, it cannot override
+		specific properties.)
 	-tabwidth int
 		tab=<tabwidth> x spaces (default=8)
 	-linelength int
 		hard-wrap prose at <linelength> column (default=0)
+	-w
+		write the result to the source files (atomically)
+		instead of stdout
+	-l
+		list files whose formatting differs from the canonical
+		form
+	-d
+		display unified diffs instead of the formatted text
+	-input format
+		input format: to (default) or json—node trees as
+		printed by "to tree -format json", which are used
+		as-is (not transformed again); directories are walked
+		for .json files; cannot be used with -w, -l, or -d
+
+Exit status is 0 on success, 1 if any file failed to format, 2 on
+usage errors, and 3 if -w, -l, or -d found files whose formatting
+differed.
 `))
 			return
 		case "tree":
@@ -354,17 +1232,314 @@ example: to tree -mode printdata < file.to
 
 Tree prints the node tree representation of Touch formatted text.
 
+The text format is a debug dump that can change at any time. The json
+format is a stable, versioned encoding of the (transformed) node tree
+for other tools:
+	{"version": 1, "root": <node>}
+where a node is an object with the properties element, type, data,
+value, start, end, location (uri and range of start and end offset,
+line, and column), and children. Omitted properties are empty. Build
+and fmt read such trees with -input json.
+
 Options:
 	-config file,list
-		a comma-separated list of configs to use. Configs are
-		shallow merged (sequentially) into the default config.
-		(Shallow merge adds or overrides only whole objects, it
-		cannot override specific properties.)
+		a comma-separated list of configs (.json or .extjson) to
+		use. Configs are deep merged (sequentially) into the
+		default config: objects are merged property by property
+		and null deletes a property (e.g. an element or a
+		template). Configs can extend other configs with the
+		"Extends" property. Without -config, the nearest
+		to.extjson or to.json in the working directory or its
+		parents is used.
+	-shallow
+		shallow merge the configs instead. (Shallow merge adds
+		or overrides only whole objects, it cannot override
+		specific properties.)
 	-tabwidth int
 		tab=<tabwidth> x spaces (default=8)
 	-mode   mode,list
 		a comma-separated list of modes to use:
 		printdata, printoffsets, printlocation
+	-format format
+		output format: text (default) or json; -mode applies
+		only to text
+`))
+			return
+		case "meta":
+			fmt.Println(strings.TrimSpace(`
+usage:   to meta [options] stdin
+example: to meta < file.to
+
+Meta prints the metadata of Touch formatted text as a JSON object of
+keys to string values.
+
+The metadata is the front matter of the document—lines at its start
+that begin with a metadata element delimiter (% by default), each
+holding a key and a value:
+	% title Touch
+	% author A. Writer
+Templates can use it through the root node, e.g. .Data.meta.title.
+
+Options:
+	-config file,list
+		a comma-separated list of configs (.json or .extjson) to
+		use. Configs are deep merged (sequentially) into the
+		default config: objects are merged property by property
+		and null deletes a property (e.g. an element or a
+		template). Configs can extend other configs with the
+		"Extends" property. Without -config, the nearest
+		to.extjson or to.json in the working directory or its
+		parents is used.
+	-shallow
+		shallow merge the configs instead. (Shallow merge adds
+		or overrides only whole objects, it cannot override
+		specific properties.)
+	-tabwidth int
+		tab=<tabwidth> x spaces (default=8)
+`))
+			return
+		case "query":
+			fmt.Println(strings.TrimSpace(`
+usage:   to query <selector> [options] [path ...]
+example: to query 'CodeBlock[openingText=go]' < file.to
+
+Query prints the elements of Touch formatted text that match the
+selector. Without paths, it reads the standard input; directories are
+searched recursively for .to files. The matches are printed in
+document order after the tree is transformed (as by build).
+
+Selectors are like CSS selectors over the element tree:
+	Heading                      elements named Heading
+	*                            any element
+	Note Link                    Links inside Notes (descendants)
+	List > ListItem              ListItems that are children of Lists
+	CodeBlock[openingText=go]    node data key=value; also [key],
+	                             [key!=v], [key^=v], [key$=v], [key*=v]
+	Heading, CodeBlock           either
+	:has(S), :has(> S)           has a descendant (child) matching S
+	:not(S), :is(S)              does not match (matches) S
+	:nth-child(An+B), :nth-last-child(An+B),
+	:first-child, :last-child    position among the sibling elements
+	:contains(text)              the text content contains text
+	:type(T)                     the node type is T, e.g. fenced
+Values with spacing or special characters are quoted with " or '.
+Unnamed nodes (e.g. table rows) are skipped: the parent of an
+element is its nearest element ancestor.
+
+The exit status is 2 if the selector is invalid and 1 if a file
+cannot be read or parsed; it does not depend on whether any element
+matched.
+
+Options:
+	-format text|location|json
+		the output format (default=text):
+		text prints the text content of each match,
+		location prints "path:line:column: Element" (one-based),
+		json prints each match as a node tree encoded as by
+		"to tree -format json", one per line
+	-config file,list
+		a comma-separated list of configs (.json or .extjson) to
+		use. Configs are deep merged (sequentially) into the
+		default config: objects are merged property by property
+		and null deletes a property (e.g. an element or a
+		template). Configs can extend other configs with the
+		"Extends" property. Without -config, the nearest
+		to.extjson or to.json in the working directory or its
+		parents is used.
+	-shallow
+		shallow merge the configs instead. (Shallow merge adds
+		or overrides only whole objects, it cannot override
+		specific properties.)
+	-tabwidth int
+		tab=<tabwidth> x spaces (default=8)
+`))
+			return
+		case "lsp":
+			fmt.Println(strings.TrimSpace(`
+usage:   to lsp [options]
+example: to lsp -config project.json
+
+Lsp runs a Language Server Protocol server that communicates with the
+editor over stdin and stdout. It provides diagnostics, document symbols
+(headings), formatting, folding ranges, hover information, and semantic
+tokens for syntax highlighting.
+
+Options:
+	-config file,list
+		a comma-separated list of configs (.json or .extjson) to
+		use. Configs are deep merged (sequentially) into the
+		default config: objects are merged property by property
+		and null deletes a property (e.g. an element or a
+		template). Configs can extend other configs with the
+		"Extends" property. Without -config, the nearest
+		to.extjson or to.json in the working directory or its
+		parents is used.
+	-shallow
+		shallow merge the configs instead. (Shallow merge adds
+		or overrides only whole objects, it cannot override
+		specific properties.)
+	-tabwidth int
+		tab=<tabwidth> x spaces (default=8)
+	-linelength int
+		hard-wrap prose at <linelength> column when formatting
+		(default=0)
+`))
+			return
+		case "import":
+			fmt.Println(strings.TrimSpace(`
+usage:   to import <format> [options] stdin
+example: to import markdown < file.md > file.to
+
+Import converts the given format to Touch formatted text in its canonical
+form. The only supported format is markdown (CommonMark).
+
+Constructs that have no Touch equivalent, such as thematic breaks, link
+titles, or raw HTML, are reported on stderr. Raw HTML is kept as comments.
+
+Options:
+	-config file,list
+		a comma-separated list of configs (.json or .extjson) to
+		use. Configs are deep merged (sequentially) into the
+		default config: objects are merged property by property
+		and null deletes a property (e.g. an element or a
+		template). Configs can extend other configs with the
+		"Extends" property. Without -config, the nearest
+		to.extjson or to.json in the working directory or its
+		parents is used.
+	-shallow
+		shallow merge the configs instead. (Shallow merge adds
+		or overrides only whole objects, it cannot override
+		specific properties.)
+	-linelength int
+		hard-wrap prose at <linelength> column (default=0)
+`))
+			return
+		case "site":
+			fmt.Println(strings.TrimSpace(`
+usage:   to site [options] <srcdir> <outdir>
+example: to site -layout layout.html docs public
+
+Site generates a static HTML site from the files in srcdir and writes it
+to outdir.
+
+Each .to file is rendered with the html templates of the config and
+wrapped in the layout. Relative links to .to files are rewritten to link
+to the generated .html files. Other files are copied as they are; hidden
+files are skipped. If srcdir has no index.to, an index.html with the
+table of contents of the whole site is generated.
+
+The output does not depend on anything but the inputs; building the same
+sources twice produces identical files.
+
+Options:
+	-config file,list
+		a comma-separated list of configs (.json or .extjson) to
+		use. Configs are deep merged (sequentially) into the
+		default config: objects are merged property by property
+		and null deletes a property (e.g. an element or a
+		template). Configs can extend other configs with the
+		"Extends" property. Without -config, the nearest
+		to.extjson or to.json in the working directory or its
+		parents is used.
+	-shallow
+		shallow merge the configs instead. (Shallow merge adds
+		or overrides only whole objects, it cannot override
+		specific properties.)
+	-tabwidth int
+		tab=<tabwidth> x spaces (default=8)
+	-layout file
+		an html/template file the pages are wrapped in. It is
+		executed with the page (.Page.Title, .Page.Content,
+		.Page.Path), the path to outdir (.Root), and the table
+		of contents (.TOC). The "toc" template renders a table
+		of contents.
+	-toc element,list
+		a comma-separated list of elements in the table of
+		contents (default=Heading,NumberedHeading)
+`))
+			return
+		case "serve":
+			fmt.Println(strings.TrimSpace(`
+usage:   to serve [options] [dir]
+example: to serve -addr localhost:3000 docs
+
+Serve serves a live preview of the files in dir (default: the working
+directory) over HTTP.
+
+Each .to file is rendered to HTML with the html templates of the config
+when requested, e.g. http://localhost:8080/guide/intro.to, so links
+between .to files work as they are. Directories are served as their
+index.to or, if there is none, as a list of their .to files and
+subdirectories. Other files are served as they are.
+
+The files in dir and the config files are checked for changes every
+half second. On a change, the configs are reloaded if they changed and
+the open pages reload themselves. Parse, template, and config errors
+are shown on the pages instead of stopping the server.
+
+Options:
+	-addr host:port
+		the address to listen on (default=localhost:8080)
+	-config file,list
+		a comma-separated list of configs (.json or .extjson) to
+		use. Configs are deep merged (sequentially) into the
+		default config: objects are merged property by property
+		and null deletes a property (e.g. an element or a
+		template). Configs can extend other configs with the
+		"Extends" property. Without -config, the nearest
+		to.extjson or to.json in the working directory or its
+		parents is used.
+	-shallow
+		shallow merge the configs instead. (Shallow merge adds
+		or overrides only whole objects, it cannot override
+		specific properties.)
+	-tabwidth int
+		tab=<tabwidth> x spaces (default=8)
+`))
+			return
+		case "config":
+			fmt.Println(strings.TrimSpace(`
+usage:   to config <command> [options]
+example: to config check -config to.json -format html
+
+Config inspects configs.
+
+Commands:
+	check   check the merged config for errors
+	show    print the merged properties and the configs that set them
+	schema  print the JSON Schema of configs
+
+Check reports delimiter conflicts, invalid delimiters, unknown types and
+matchers, group elements referencing nonexistent elements, and missing or
+invalid templates. Each error is reported with the config file that set
+the invalid property. Exit status is 1 if any errors were found.
+
+Show prints a line for each property of the merged config: its path
+(dot-separated keys, e.g. Elements.Heading.Delimiter), a tab, and the
+config file that set it or "default" for the default config. Properties
+deleted with null are followed by "(deleted)". Objects replaced by a
+shallow merge are attributed whole to the config that replaced them.
+
+Options for check and show:
+	-config file,list
+		a comma-separated list of configs (.json or .extjson) to
+		use. Configs are deep merged (sequentially) into the
+		default config: objects are merged property by property
+		and null deletes a property (e.g. an element or a
+		template). Configs can extend other configs with the
+		"Extends" property. Without -config, the nearest
+		to.extjson or to.json in the working directory or its
+		parents is used.
+	-shallow
+		shallow merge the configs instead. (Shallow merge adds
+		or overrides only whole objects, it cannot override
+		specific properties.)
+	-format format,list
+		a comma-separated list of formats whose templates to
+		check (default=all formats of the root templates)
+
+Schema prints the JSON Schema that editors can use to validate and
+autocomplete configs.
 `))
 			return
 		case "tool":
@@ -376,7 +1551,8 @@ usage: to tool <tool> [arguments]
 Tool runs the Touch tool.
 
 Tools:
-	extjson  convert extended JSON to plain JSON
+	extjson   convert extended JSON to plain JSON
+	textmate  generate a TextMate grammar for syntax highlighting
 
 Use "to help tool <tool>" for details about a tool.
 `))
@@ -422,6 +1598,39 @@ Features:
 		"Templates": {
 			"html": "<blockquote>\n\t{{template \"children\" .}}\n</blockquote>\n"
 		}
+`))
+				return
+			case "textmate":
+				fmt.Println(strings.TrimSpace(`
+textmate prints a TextMate grammar for Touch formatted text generated
+from the elements of the config.
+
+usage:   to tool textmate [options]
+example: to tool textmate > touch.tmLanguage.json
+
+Editors like VS Code and Sublime Text use TextMate grammars for syntax
+highlighting. The grammar matches blocks at the start of lines and
+inlines anywhere, so it approximates the parser; for exact highlighting,
+use the semantic tokens of 'to lsp'. Scopes are derived from the
+element types and end with the element names, e.g.
+markup.heading.heading.touch.
+
+Options:
+	-config file,list
+		a comma-separated list of configs (.json or .extjson) to
+		use. Configs are deep merged (sequentially) into the
+		default config: objects are merged property by property
+		and null deletes a property (e.g. an element or a
+		template). Configs can extend other configs with the
+		"Extends" property. Without -config, the nearest
+		to.extjson or to.json in the working directory or its
+		parents is used.
+	-scope name
+		the scope name of the grammar (default=text.touch)
+	-shallow
+		shallow merge the configs instead. (Shallow merge adds
+		or overrides only whole objects, it cannot override
+		specific properties.)
 `))
 				return
 			default:
@@ -478,6 +1687,13 @@ Commands:
 	build  	convert Touch formatted text
 	fmt    	format Touch formatted text (prettify)
 	tree   	print node tree
+	meta   	print document metadata
+	query  	find elements by selector
+	lsp    	run the language server
+	import 	convert other formats to Touch formatted text
+	site   	generate a static site
+	serve  	serve a live preview
+	config 	inspect configs
 	tool    run specified Touch tool
 	help   	print help
 	version	print version
@@ -494,126 +1710,375 @@ func isStdinEmpty() bool {
 	return (stat.Mode() & os.ModeCharDevice) != 0
 }
 
-func jsonDecodeConfigFile(path string) *config.Config {
-	var c config.Config
-	f, err := os.Open(path)
+// loadConfig merges the comma-separated list of config files into the default
+// config and records which config file set each property. If the list is
+// empty, the project config found in the working directory or its parents is
+// used. The configs are deep merged unless shallow is set. It exits on error.
+func loadConfig(configs string, shallow bool) (*config.Config, config.Provenance) {
+	cfg, prov, _, err := readConfig(configs, shallow)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "cannot open config file (%s): %v\n", path, err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(2)
-		return nil
+		return nil, nil
 	}
-	if err := json.NewDecoder(f).Decode(&c); err != nil {
-		fmt.Fprintf(os.Stderr, "cannot decode JSON from config file (%s): %v\n", path, err)
-		os.Exit(2)
-		return nil
+	return cfg, prov
+}
+
+// readConfig is like loadConfig but returns the error instead of exiting. It
+// also returns the names of the config files it read, including the extended
+// ones; on error, the names of the files read so far.
+func readConfig(configs string, shallow bool) (*config.Config, config.Provenance, []string, error) {
+	prov, err := config.NewProvenance(&config.Default, "default")
+	if err != nil {
+		panic(fmt.Sprintf("default config provenance failed: %v", err))
+	}
+
+	var paths []string
+	for _, p := range strings.Split(configs, ",") {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		p, err := config.Find(".")
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("cannot find project config: %v", err)
+		}
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+
+	var (
+		files []config.File
+		names []string
+	)
+	for _, p := range paths {
+		f, err := config.ReadFile(p)
+		if err != nil {
+			return nil, nil, append(names, p), fmt.Errorf("cannot open config file (%s): %v", p, err)
+		}
+		files = append(files, f...)
+		for _, ff := range f {
+			names = append(names, ff.Name)
+		}
+	}
+
+	if shallow {
+		// copy the default config, ShallowMerge modifies its maps
+		cfg := config.ShallowMerge(&config.Config{}, &config.Default)
+		for _, f := range files {
+			var c config.Config
+			if err := json.Unmarshal(f.JSON, &c); err != nil {
+				return nil, nil, names, fmt.Errorf("cannot decode JSON from config file (%s): %v", f.Name, err)
+			}
+			config.ShallowMerge(cfg, &c)
+			if err := prov.Replace(&c, f.Name); err != nil {
+				panic(fmt.Sprintf("config provenance failed: %v", err))
+			}
+		}
+		return cfg, prov, names, nil
+	}
+
+	cfg := config.Default
+	for _, f := range files {
+		if err := config.DeepMerge(&cfg, f.JSON, f.Name, prov); err != nil {
+			return nil, nil, names, fmt.Errorf("cannot decode JSON from config file (%s): %v", f.Name, err)
+		}
+	}
+	return &cfg, prov, names, nil
+}
+
+// showProvenance writes the property paths of the merged config with the
+// layers that set them, one per line and in sorted order. Paths recorded in
+// prov but missing in cfg were deleted by their layer.
+func showProvenance(w io.Writer, cfg *config.Config, prov config.Provenance) error {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	var m interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	for _, path := range prov.Paths() {
+		v, ok := m, true
+		for _, k := range strings.Split(path, ".") {
+			o, _ := v.(map[string]interface{})
+			if v, ok = o[k]; !ok {
+				break
+			}
+		}
+		if !ok {
+			fmt.Fprintf(&out, "%s\t%s (deleted)\n", path, prov[path])
+		} else {
+			fmt.Fprintf(&out, "%s\t%s\n", path, prov[path])
+		}
 	}
-	return &c
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+// check returns the problems the transformers found in the transformed tree.
+// Node locations do not hold the document URI, so it is set here.
+func check(t transformer.Group, root *node.Node, uri string) parser.ErrorList {
+	list := t.Check(root)
+	for _, e := range list {
+		if e.Location.URI == "" {
+			e.Location.URI = node.DocumentURI(uri)
+		}
+	}
+	return list
+}
+
+// hasErrors reports whether the list contains problems with the error
+// severity.
+func hasErrors(list parser.ErrorList) bool {
+	for _, e := range list {
+		if e.Severity == parser.SeverityError {
+			return true
+		}
+	}
+	return false
 }
 
 func parse(src []byte, elements parser.Elements, tabWidth int) *node.Node {
+	root, err := parseFile("", src, elements, tabWidth)
+	if err != nil {
+		parser.PrintErrorSource(os.Stderr, src, err)
+		os.Exit(1)
+		return nil
+	}
+	return root
+}
+
+// parseInput parses the Touch formatted src or, if input is "json", decodes the
+// JSON-encoded (transformed) node tree; the uri is used in error locations.
+func parseInput(input, uri string, src []byte, elements parser.Elements, tabWidth int) (*node.Node, error) {
+	if input == "json" {
+		root, err := node.DecodeJSON(bytes.NewReader(src))
+		if err != nil && uri != "" {
+			err = fmt.Errorf("%s: %w", uri, err)
+		}
+		return root, err
+	}
+	return parseFile(uri, src, elements, tabWidth)
+}
+
+// inputExtension returns the file extension of the input format. It exits if
+// the input format is invalid.
+func inputExtension(cmd, input string) string {
+	switch input {
+	case "to":
+		return ".to"
+	case "json":
+		return ".json"
+	}
+	fmt.Fprintf(os.Stderr, strings.TrimSpace(`
+%s: invalid input format: %q
+
+valid input formats: to, json
+Run 'to help %s' for details.
+`)+"\n", cmd, input, strings.TrimPrefix(cmd, "to "))
+	os.Exit(2)
+	return ""
+}
+
+// parseFile parses the src; the uri is used in error locations.
+func parseFile(uri string, src []byte, elements parser.Elements, tabWidth int) (*node.Node, error) {
 	p := parser.Parser{
 		Elements: elements,
-		Matchers: matcher.Defaults(),
+		Matchers: matchers(),
+		URI:      node.DocumentURI(uri),
 	}
 	if tabWidth > 0 {
 		p.TabWidth = tabWidth
 	} else {
 		p.TabWidth = 8
 	}
-	root, err := p.Parse(nil, src)
+	return p.Parse(nil, src)
+}
+
+// transformers returns the transformers of the group elements (see
+// render.Transformers). It exits if an element type is unknown.
+func transformers(elements config.Elements) transformer.Group {
+	g, err := render.Transformers(elements)
 	if err != nil {
-		parser.PrintError(os.Stderr, err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "invalid config: %v\n", err)
+		os.Exit(2)
 		return nil
 	}
-	return root
+	return g
 }
 
-func transformers(elements config.Elements) transformer.Group {
-	paragraphs := paragraph.Map{}
-	lists := group.Map{}
-	stickies := sticky.Map{}
-	for n, e := range elements {
-		if e.Disabled {
-			continue
-		}
-		var x node.Type
-		if err := (&x).UnmarshalText([]byte(e.Type)); err == nil {
-			// is a node element (can't be a group)
-			continue
-		}
+// build renders the node tree in the given format to w. If sourceMap is true,
+// the output is annotated with source lines and its source map is returned.
+func build(r *render.Renderer, root *node.Node, format string, w io.Writer, sourceMap bool) (*render.SourceMap, error) {
+	if sourceMap {
+		return r.RenderNodeMapped(context.Background(), root, format, w)
+	}
+	return nil, r.RenderNode(context.Background(), root, format, w)
+}
 
-		switch e.Type {
-		case "paragraph":
-			var t node.Type
-			if err := (&t).UnmarshalText([]byte(e.Option)); err != nil {
-				fmt.Fprintf(os.Stderr, "invalid paragraph option (%q)\n", e.Option)
-				os.Exit(2)
-				return transformer.Group{}
-			}
-			paragraphs[n] = t
-		case "list":
-			lists[n] = e.Element
-		case "sticky":
-			stickies[n] = sticky.Sticky{
-				Element: e.Element,
-				Target:  e.Target,
-				After:   e.Option == "after",
+// newRenderer returns a renderer for the config. It exits on error.
+func newRenderer(cfg *config.Config, tabWidth int) *render.Renderer {
+	r, err := render.New(cfg, render.Options{
+		Matchers: matchers(),
+		TabWidth: tabWidth,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config: %v\n", err)
+		os.Exit(2)
+		return nil
+	}
+	return r
+}
+
+// filterChain returns the filters declared in the config followed by the
+// given commands. Relative config commands with a path separator are resolved
+// against the directory of the config that declares them.
+func filterChain(cfg *config.Config, prov config.Provenance, commands []string, format string) filter.Chain {
+	var chain filter.Chain
+	dir := ""
+	if layer := prov.Layer("Filters"); layer != "" && layer != "default" {
+		dir = filepath.Dir(layer)
+	}
+	for _, f := range cfg.Filters {
+		command := f.Command
+		if dir != "" && strings.ContainsRune(command, filepath.Separator) && !filepath.IsAbs(command) {
+			command = filepath.Join(dir, command)
+			if !filepath.IsAbs(command) {
+				// keep a separator so that it is not looked up in PATH
+				command = "." + string(filepath.Separator) + command
 			}
-		default:
-			fmt.Fprintf(os.Stderr, "unsupported group type: %q (element=%q)\n", e.Type, n)
-			os.Exit(2)
-			return transformer.Group{}
 		}
+		chain = append(chain, filter.Filter{
+			Command: command,
+			Args:    f.Args,
+			Format:  format,
+			Stderr:  os.Stderr,
+		})
 	}
-	return transformer.Group{
-		paragraph.Transformer{paragraphs},
-		group.Transformer{lists},
-		sticky.Transformer{stickies},
-		transformer.Func(sequentialnumber.Transform),
+	for _, c := range commands {
+		chain = append(chain, filter.Filter{
+			Command: c,
+			Format:  format,
+			Stderr:  os.Stderr,
+		})
 	}
+	return chain
 }
 
-func build(cfg *config.Config, root *node.Node, format string) {
-	aggregators := aggregator.Aggregators{}
-	for n, a := range cfg.Aggregates {
-		switch a.Type {
-		case "sequentialNumber":
-			aggregators[n] = seqnumaggregator.Aggregator{a.Elements}
-		default:
-			fmt.Fprintf(os.Stderr, "invalid config: unsupported aggregate type: %q\n", a.Type)
-			os.Exit(2)
-			return
-		}
+// matchers returns the registered matchers. It exits on error.
+func matchers() matcher.Map {
+	m, err := registry.Matchers()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+		return nil
 	}
-	aggregates := aggregator.Apply(root, aggregators)
+	return m
+}
+
+// fmtOptions are the fmt flags for reporting formatted files.
+type fmtOptions struct {
+	write bool // write result to source file
+	list  bool // list files whose formatting differs
+	diff  bool // display diffs
+}
 
-	tmpl := template.New(format)
-	global := map[string]interface{}{
-		"aggregates": aggregates,
+// reportsChanges reports whether changed files are reported (or rewritten)
+// instead of printing the formatted text.
+func (o fmtOptions) reportsChanges() bool {
+	return o.write || o.list || o.diff
+}
+
+// reportFormatted reports the formatted output of a single file and reports
+// whether its formatting differs.
+func reportFormatted(name string, src, out []byte, opts fmtOptions) (bool, error) {
+	changed := !bytes.Equal(src, out)
+	if !opts.reportsChanges() {
+		_, err := os.Stdout.Write(out)
+		return changed, err
+	}
+	if !changed {
+		return false, nil
+	}
+	if opts.list {
+		fmt.Println(name)
+	}
+	if opts.write {
+		if err := writeFile(name, out); err != nil {
+			return changed, err
+		}
+	}
+	if opts.diff {
+		if _, err := os.Stdout.Write(diff.Unified(name+".orig", name, src, out)); err != nil {
+			return changed, err
+		}
 	}
-	tmpl.Funcs(totemplate.Funcs(tmpl, global))
-	_, err := cfg.ParseTemplates(tmpl, format)
+	return changed, nil
+}
+
+// formatSource parses, transforms (unless the input is a JSON node tree), and
+// prints the src in the canonical form; the uri is used in error locations.
+func formatSource(input, uri string, src []byte, elements parser.Elements, tabWidth int, t transformer.Transformer, lineLength int) ([]byte, error) {
+	root, err := parseInput(input, uri, src, elements, tabWidth)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "parse templates failed (format=%q): %v\n", format, err)
-		os.Exit(1)
-		return
+		return nil, err
 	}
-	if err := tmpl.Execute(os.Stdout, root); err != nil {
-		fmt.Fprintf(os.Stderr, "execute template failed: %v\n", err)
-		os.Exit(1)
-		return
+	if input == "to" {
+		root = t.Transform(root)
+	}
+
+	var b bytes.Buffer
+	if err := fprint(&b, elements, lineLength, root); err != nil {
+		return nil, fmt.Errorf("fmt failed: %w", err)
 	}
+	return b.Bytes(), nil
+}
+
+// fprint prints the node tree in the canonical form, ending with a newline
+// unless it is empty, to w.
+func fprint(w io.Writer, elements parser.Elements, lineLength int, root *node.Node) error {
+	return printer.Printer{Elements: elements, LineLength: lineLength}.FprintDocument(w, root)
 }
 
 func format(elements parser.Elements, lineLength int, root *node.Node) {
-	if err := (printer.Printer{Elements: elements, LineLength: lineLength}).Fprint(os.Stdout, root); err != nil {
+	if err := fprint(os.Stdout, elements, lineLength, root); err != nil {
 		fmt.Fprintf(os.Stderr, "fmt failed: %v\n", err)
 		os.Exit(1)
 		return
 	}
 }
 
+// printMatches prints the matched nodes in the format (text, location, or
+// json); the uri is used in locations.
+func printMatches(w io.Writer, nodes []*node.Node, format, uri string) error {
+	for _, n := range nodes {
+		var err error
+		switch format {
+		case "text":
+			_, err = fmt.Fprintln(w, n.TextContent())
+		case "location":
+			pos := n.Location.Range.Start
+			prefix := ""
+			if uri != "" {
+				prefix = uri + ":"
+			}
+			_, err = fmt.Fprintf(w, "%s%d:%d: %s\n", prefix, pos.Line+1, pos.Column+1, n.Element)
+		case "json":
+			err = node.EncodeJSON(w, n)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func tree(root *node.Node, modes []string) {
 	var m node.PrinterMode
 	for _, s := range modes {
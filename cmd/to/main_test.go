@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/config"
+)
+
+func TestFormatCanonical(t *testing.T) {
+	elements := config.Default.Elements.ParserElements()
+	tr := transformers(config.Default.Elements)
+
+	cases := []string{
+		"a\n",
+		"= Title\n\nSome **strong** text.\n\n- a\n- b\n\n`go\nx\n`\n",
+	}
+	for _, src := range cases {
+		out, err := formatSource("to", "", []byte(src), elements, 0, tr, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != src {
+			t.Errorf("formatted %q, want it unchanged, got %q", src, out)
+		}
+		changed, err := reportFormatted("file.to", []byte(src), out, fmtOptions{list: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed {
+			t.Errorf("canonical %q reported as changed", src)
+		}
+	}
+}
+
+func TestFormatFinalNewline(t *testing.T) {
+	elements := config.Default.Elements.ParserElements()
+	tr := transformers(config.Default.Elements)
+
+	for src, want := range map[string]string{
+		"a":     "a\n",
+		"a\n\n": "a\n",
+		"":      "",
+	} {
+		out, err := formatSource("to", "", []byte(src), elements, 0, tr, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != want {
+			t.Errorf("formatted %q to %q, want %q", src, out, want)
+		}
+	}
+}
+
+func TestShowProvenance(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.json")
+	b := filepath.Join(dir, "b.json")
+	for name, src := range map[string]string{
+		a: `{"Elements": {"Heading": {"Delimiter": "#"}, "Note": null}}`,
+		b: `{"Elements": {"Heading": {"Templates": {"html": "<h9>{{.}}</h9>"}}}}`,
+	} {
+		if err := os.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg, prov, _, err := readConfig(a+","+b, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := showProvenance(&out, cfg, prov); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := map[string]bool{}
+	for _, l := range strings.Split(out.String(), "\n") {
+		lines[l] = true
+	}
+	for _, want := range []string{
+		"Elements.Heading.Delimiter\t" + a,
+		"Elements.Heading.Templates.html\t" + b,
+		"Elements.Heading.Templates.markdown\tdefault",
+		"Elements.Note\t" + a + " (deleted)",
+		"Filters\tdefault",
+	} {
+		if !lines[want] {
+			t.Errorf("missing line %q in:\n%s", want, out.String())
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
+	texttemplate "text/template"
 
 	"github.com/touchmarine/to/node"
 	"github.com/touchmarine/to/parser"
@@ -16,6 +17,12 @@ import (
 //go:embed to.json
 var b []byte
 
+// Schema is the JSON Schema of configs. Editors can use it to validate and
+// autocomplete configs.
+//
+//go:embed to.schema.json
+var Schema []byte
+
 // Default is the default Config.
 var Default = defaultConfig()
 
@@ -34,31 +41,81 @@ type Config struct {
 	Templates  Templates
 	Elements   Elements
 	Aggregates Aggregates
+	Formats    Formats
+	Filters    []Filter
 }
 
 // ParseTemplates parses config templates that match the given format as
 // template bodies for the given template.
 func (c Config) ParseTemplates(t *template.Template, format string) (*template.Template, error) {
+	root, elements, err := c.templates(format)
+	if err != nil {
+		return nil, err
+	}
+	for n, s := range elements {
+		if _, err := t.New(n).Parse(s); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := t.Parse(root); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ParseTextTemplates is like ParseTemplates but for text templates. It is used
+// for text formats (see IsTextFormat).
+func (c Config) ParseTextTemplates(t *texttemplate.Template, format string) (*texttemplate.Template, error) {
+	root, elements, err := c.templates(format)
+	if err != nil {
+		return nil, err
+	}
+	for n, s := range elements {
+		if _, err := t.New(n).Parse(s); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := t.Parse(root); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// templates returns the root template and the element templates (by element
+// name) of the given format.
+func (c Config) templates(format string) (string, map[string]string, error) {
+	elements := map[string]string{}
 	for n, e := range c.Elements {
 		if e.Disabled {
 			continue
 		}
 		s, ok := e.Templates[format]
 		if !ok {
-			return nil, fmt.Errorf("template not found: name=%q format=%q", n, format)
-		}
-		if _, err := t.New(n).Parse(s); err != nil {
-			return nil, err
+			return "", nil, fmt.Errorf("template not found: name=%q format=%q", n, format)
 		}
+		elements[n] = s
 	}
 	s, ok := c.Templates[format]
 	if !ok {
-		return nil, fmt.Errorf("template not found: format=%q", format)
+		return "", nil, fmt.Errorf("template not found: format=%q", format)
 	}
-	if _, err := t.Parse(s); err != nil {
-		return nil, err
+	return s, elements, nil
+}
+
+// IsTextFormat reports whether the templates of the given format are text
+// templates (text/template) instead of HTML templates (html/template).
+func (c Config) IsTextFormat(format string) bool {
+	f, ok := c.Formats[format]
+	return ok && f.Text
+}
+
+// Extension returns the file name extension (including the dot) of the files
+// built in the given format.
+func (c Config) Extension(format string) string {
+	if f, ok := c.Formats[format]; ok && f.Extension != "" {
+		return f.Extension
 	}
-	return t, nil
+	return "." + format
 }
 
 // Templates is a map of formats to template strings.
@@ -97,11 +154,26 @@ func (es Elements) ParserElements() parser.Elements {
 			Type:      t,
 			Delimiter: e.Delimiter,
 			Matcher:   e.Matcher,
+			Comment:   e.Option == OptionComment,
 		}
 	}
 	return m
 }
 
+// Formats is a map of format names to Formats.
+type Formats map[string]Format
+
+// Format holds format options. Formats without options are HTML formats.
+type Format struct {
+	// Text reports whether the templates are text templates
+	// (text/template) which, unlike HTML templates (html/template), do
+	// not escape their output.
+	Text bool
+	// Extension is the file name extension (including the dot) of the
+	// built files. If empty, the format name is used (e.g. ".html").
+	Extension string
+}
+
 // Aggregates is a map of aggregate names to Aggregates.
 type Aggregates map[string]Aggregate
 
@@ -110,3 +182,13 @@ type Aggregate struct {
 	Type     string   // which aggregator (aggregator name)
 	Elements []string // allowed elements to aggregate from
 }
+
+// Filter is an external program that transforms node trees after the
+// transformers (see package transformer/filter).
+type Filter struct {
+	// Command is the program name (looked up in PATH) or path. Relative
+	// paths are relative to the directory of the config that sets the
+	// filters.
+	Command string
+	Args    []string // program arguments
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/touchmarine/to/tools/extjson"
+)
+
+// FileNames are the names of project config files in the order of preference.
+var FileNames = []string{"to.extjson", "to.json"}
+
+// ExtendsKey is the config property that lists the configs a config extends.
+// Its value is a path or a list of paths relative to the directory of the
+// config.
+const ExtendsKey = "Extends"
+
+// Find looks for a project config file (see FileNames) in dir and then in its
+// parent directories. It returns the path of the nearest one or "" if there is
+// none.
+func Find(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		for _, n := range FileNames {
+			p := filepath.Join(dir, n)
+			info, err := os.Stat(p)
+			if err == nil && !info.IsDir() {
+				return p, nil
+			}
+			if err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// File is a config file converted to plain JSON.
+type File struct {
+	Name string // file path
+	JSON []byte // config without the Extends property
+}
+
+// ReadFile reads the named config file and, recursively, the config files it
+// extends. Files with the .extjson extension are converted from extended JSON.
+//
+// The files are returned in the order they should be merged—the extended
+// configs before the configs that extend them. A config extended more than
+// once is returned only once.
+func ReadFile(name string) ([]File, error) {
+	r := reader{
+		seen: map[string]bool{},
+	}
+	if err := r.read(name, nil); err != nil {
+		return nil, err
+	}
+	return r.files, nil
+}
+
+type reader struct {
+	files []File
+	seen  map[string]bool // absolute paths of read files
+}
+
+// read reads the config file after the configs it extends; stack holds the
+// absolute paths of the extending configs.
+func (r *reader) read(name string, stack []string) error {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	for _, s := range stack {
+		if s == abs {
+			return fmt.Errorf("config extends itself: %s", strings.Join(append(stack, abs), " -> "))
+		}
+	}
+	if r.seen[abs] {
+		return nil
+	}
+	r.seen[abs] = true
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if filepath.Ext(name) == ".extjson" {
+		var buf bytes.Buffer
+		extjson.Convert(&buf, bytes.NewReader(b))
+		b = buf.Bytes()
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	extends, err := parseExtends(m[ExtendsKey])
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if _, ok := m[ExtendsKey]; ok {
+		delete(m, ExtendsKey)
+		if b, err = json.Marshal(m); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	for _, e := range extends {
+		if !filepath.IsAbs(e) {
+			e = filepath.Join(filepath.Dir(name), e)
+		}
+		if err := r.read(e, append(stack, abs)); err != nil {
+			return err
+		}
+	}
+	r.files = append(r.files, File{
+		Name: name,
+		JSON: b,
+	})
+	return nil
+}
+
+// parseExtends parses the Extends value—a path or a list of paths.
+func parseExtends(v json.RawMessage) ([]string, error) {
+	if v == nil || string(v) == "null" {
+		return nil, nil
+	}
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		return []string{s}, nil
+	}
+	var a []string
+	if err := json.Unmarshal(v, &a); err != nil {
+		return nil, fmt.Errorf("%s must be a path or a list of paths", ExtendsKey)
+	}
+	return a, nil
+}
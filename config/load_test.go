@@ -0,0 +1,138 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/config"
+)
+
+func TestFind(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"to.json":          `{}`,
+		"a/to.extjson":     `{}`,
+		"a/to.json":        `{}`, // to.extjson preferred
+		"a/b/c/file.to":    ``,
+		"d/to.json/README": ``, // directory named to.json
+	})
+
+	cases := []struct {
+		dir  string
+		want string
+	}{
+		{"", "to.json"},
+		{"a", "a/to.extjson"},
+		{"a/b/c", "a/to.extjson"},
+		{"d", "to.json"},
+	}
+	for _, c := range cases {
+		t.Run(c.dir, func(t *testing.T) {
+			got, err := config.Find(filepath.Join(root, c.dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(root, c.want); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"base.json": `{"Templates": {"html": "base"}}`,
+		"ext.extjson": `{
+	"Extends": "base.json",
+	"Templates": {
+		"html": '''
+ext'''
+	}
+}`,
+		"sub/to.json":    `{"Extends": ["../ext.extjson", "../base.json", "local.json"], "Elements": {}}`,
+		"sub/local.json": `{"Extends": null}`,
+	})
+
+	files, err := config.ReadFile(filepath.Join(root, "sub", "to.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names, contents []string
+	for _, f := range files {
+		rel, err := filepath.Rel(root, f.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, filepath.ToSlash(rel))
+		contents = append(contents, string(f.JSON))
+	}
+	if want := []string{"base.json", "ext.extjson", "sub/local.json", "sub/to.json"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got files %q, want %q", names, want)
+	}
+	if want := []string{
+		`{"Templates": {"html": "base"}}`,
+		`{"Templates":{"html":"ext"}}`,
+		`{}`,
+		`{"Elements":{}}`,
+	}; !reflect.DeepEqual(contents, want) {
+		t.Errorf("got %q, want %q", contents, want)
+	}
+
+	// merged in order
+	cfg := config.Config{}
+	for _, f := range files {
+		if err := config.DeepMerge(&cfg, f.JSON, f.Name, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := cfg.Templates["html"]; got != "ext" {
+		t.Errorf("got html template %q, want %q", got, "ext")
+	}
+}
+
+func TestReadFileError(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"a.json":       `{"Extends": "b.json"}`,
+		"b.json":       `{"Extends": "a.json"}`,
+		"extends.json": `{"Extends": 1}`,
+		"missing.json": `{"Extends": "none.json"}`,
+		"syntax.json":  `{`,
+	})
+
+	cases := []struct {
+		name string
+		err  string
+	}{
+		{"a.json", "config extends itself: "},
+		{"extends.json", "Extends must be a path or a list of paths"},
+		{"missing.json", "none.json"},
+		{"syntax.json", "syntax.json: unexpected end of JSON input"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := config.ReadFile(filepath.Join(root, c.name))
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("got error %v, want %q", err, c.err)
+			}
+		})
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
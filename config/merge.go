@@ -1,5 +1,12 @@
 package config
 
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
 // ShallowMerge merges src into dst but can only add or override whole objects.
 // It does not support more granularity.
 func ShallowMerge(dst, src *Config) *Config {
@@ -27,5 +34,192 @@ func ShallowMerge(dst, src *Config) *Config {
 		}
 		dst.Aggregates[n] = v
 	}
+	for n, f := range src.Formats {
+		if dst.Formats == nil {
+			dst.Formats = Formats{}
+		}
+		dst.Formats[n] = f
+	}
+	if src.Filters != nil {
+		dst.Filters = src.Filters
+	}
 	return dst
 }
+
+// DeepMerge merges the JSON encoded config src into dst property by property.
+// Objects (such as Elements, an Element, or Templates) are merged key by key,
+// other values (strings, booleans, and arrays) replace the values in dst. A
+// null value deletes the property, e.g.:
+// 	{"Elements": {"Heading": {"Templates": {"html": "<h2>..."}}}}
+// changes only the html template of Heading and
+// 	{"Elements": {"Note": null}}
+// removes the Note element.
+//
+// If prov is not nil, each property set or deleted by src is recorded in prov
+// as set by the given layer (usually the config file name).
+func DeepMerge(dst *Config, src []byte, layer string, prov Provenance) error {
+	var s interface{}
+	if err := json.Unmarshal(src, &s); err != nil {
+		return err
+	}
+	m, ok := s.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("config is not an object")
+	}
+
+	d, err := toMap(dst)
+	if err != nil {
+		return err
+	}
+	merge(d, m, "", layer, prov)
+
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	var c Config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return err
+	}
+	*dst = c
+	return nil
+}
+
+// merge merges src into dst recursively and records the set properties in
+// prov.
+func merge(dst, src map[string]interface{}, path, layer string, prov Provenance) {
+	for k, v := range src {
+		p := joinPath(path, k)
+		if v == nil {
+			delete(dst, k)
+			prov.delete(p)
+			prov.set(p, layer) // deleted by layer
+			continue
+		}
+		if sm, ok := v.(map[string]interface{}); ok {
+			dm, ok := dst[k].(map[string]interface{})
+			if !ok {
+				// new object or replaced non-object value
+				dm = map[string]interface{}{}
+				dst[k] = dm
+				prov.delete(p)
+			}
+			merge(dm, sm, p, layer, prov)
+			continue
+		}
+		dst[k] = v
+		prov.delete(p)
+		prov.set(p, layer)
+	}
+}
+
+// toMap returns the config as a generic JSON object.
+func toMap(c *Config) (map[string]interface{}, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// Provenance is a map of property paths to the layers that set them. Paths are
+// dot-separated JSON keys, e.g. "Elements.Heading.Templates.html".
+type Provenance map[string]string
+
+// NewProvenance returns provenance that records all properties of c as set by
+// the given layer. It is used to record the base config (usually the default
+// config) before deep merging other layers into it.
+func NewProvenance(c *Config, layer string) (Provenance, error) {
+	m, err := toMap(c)
+	if err != nil {
+		return nil, err
+	}
+	prov := Provenance{}
+	merge(map[string]interface{}{}, m, "", layer, prov)
+	return prov, nil
+}
+
+// Layer returns the layer that set the property at the given path or, if the
+// property is not set, the layer that set the nearest enclosing property or
+// one of its properties (e.g. the layer that added an element for its missing
+// properties). Top-level properties are considered only if set directly.
+func (p Provenance) Layer(path string) string {
+	for q := path; ; {
+		if l, ok := p[q]; ok {
+			return l
+		}
+		i := strings.LastIndexByte(q, '.')
+		if i < 0 {
+			return ""
+		}
+		for _, r := range p.Paths() {
+			if strings.HasPrefix(r, q+".") {
+				return p[r]
+			}
+		}
+		q = q[:i]
+	}
+}
+
+// Paths returns the recorded property paths in sorted order.
+func (p Provenance) Paths() []string {
+	paths := make([]string, 0, len(p))
+	for path := range p {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (p Provenance) set(path, layer string) {
+	if p != nil {
+		p[path] = layer
+	}
+}
+
+// delete deletes the path and all paths under it.
+func (p Provenance) delete(path string) {
+	for k := range p {
+		if k == path || strings.HasPrefix(k, path+".") {
+			delete(p, k)
+		}
+	}
+}
+
+// Replace records the properties of c as set by the given layer the way
+// ShallowMerge merges them—the provenance of whole objects is replaced. It is
+// used to record the provenance of shallow merged configs.
+func (p Provenance) Replace(c *Config, layer string) error {
+	m, err := toMap(c)
+	if err != nil {
+		return err
+	}
+	for section, v := range m {
+		if a, ok := v.([]interface{}); ok {
+			// arrays (Filters) are replaced whole
+			p.delete(section)
+			merge(map[string]interface{}{}, map[string]interface{}{section: a}, "", layer, p)
+			continue
+		}
+		objects, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for k, o := range objects {
+			p.delete(joinPath(section, k))
+			merge(map[string]interface{}{}, map[string]interface{}{k: o}, section, layer, p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package config_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/config"
+)
+
+var base = config.Config{
+	Templates: config.Templates{
+		"html": "<html>",
+	},
+	Elements: config.Elements{
+		"Heading": {
+			Type:      "rankedHanging",
+			Delimiter: "=",
+			Templates: config.Templates{
+				"html":     "<h1>",
+				"markdown": "#",
+			},
+		},
+		"Note": {
+			Type:      "hanging",
+			Delimiter: "*",
+			Templates: config.Templates{
+				"html": "<div>",
+			},
+		},
+	},
+}
+
+func TestDeepMerge(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		out  config.Config
+	}{
+		{
+			"empty",
+			`{}`,
+			base,
+		},
+		{
+			"template",
+			`{"Elements": {"Heading": {"Templates": {"html": "<h2>"}}}}`,
+			config.Config{
+				Templates: base.Templates,
+				Elements: config.Elements{
+					"Heading": {
+						Type:      "rankedHanging",
+						Delimiter: "=",
+						Templates: config.Templates{
+							"html":     "<h2>",
+							"markdown": "#",
+						},
+					},
+					"Note": base.Elements["Note"],
+				},
+			},
+		},
+		{
+			"new format",
+			`{"Templates": {"text": ""}, "Elements": {"Note": {"Templates": {"text": "note"}}}}`,
+			config.Config{
+				Templates: config.Templates{
+					"html": "<html>",
+					"text": "",
+				},
+				Elements: config.Elements{
+					"Heading": base.Elements["Heading"],
+					"Note": {
+						Type:      "hanging",
+						Delimiter: "*",
+						Templates: config.Templates{
+							"html": "<div>",
+							"text": "note",
+						},
+					},
+				},
+			},
+		},
+		{
+			"field",
+			`{"Elements": {"Note": {"Delimiter": "%", "Disabled": true}}}`,
+			config.Config{
+				Templates: base.Templates,
+				Elements: config.Elements{
+					"Heading": base.Elements["Heading"],
+					"Note": {
+						Disabled:  true,
+						Type:      "hanging",
+						Delimiter: "%",
+						Templates: config.Templates{
+							"html": "<div>",
+						},
+					},
+				},
+			},
+		},
+		{
+			"new element",
+			`{"Elements": {"Term": {"Type": "hanging", "Delimiter": "?"}}}`,
+			config.Config{
+				Templates: base.Templates,
+				Elements: config.Elements{
+					"Heading": base.Elements["Heading"],
+					"Note":    base.Elements["Note"],
+					"Term": {
+						Type:      "hanging",
+						Delimiter: "?",
+					},
+				},
+			},
+		},
+		{
+			"delete element",
+			`{"Elements": {"Note": null}}`,
+			config.Config{
+				Templates: base.Templates,
+				Elements: config.Elements{
+					"Heading": base.Elements["Heading"],
+				},
+			},
+		},
+		{
+			"delete template",
+			`{"Elements": {"Heading": {"Templates": {"markdown": null}}}}`,
+			config.Config{
+				Templates: base.Templates,
+				Elements: config.Elements{
+					"Heading": {
+						Type:      "rankedHanging",
+						Delimiter: "=",
+						Templates: config.Templates{
+							"html": "<h1>",
+						},
+					},
+					"Note": base.Elements["Note"],
+				},
+			},
+		},
+		{
+			"replace array",
+			`{"Aggregates": {"a": {"Type": "sequentialNumber", "Elements": ["Heading"]}}}`,
+			config.Config{
+				Templates: base.Templates,
+				Elements:  base.Elements,
+				Aggregates: config.Aggregates{
+					"a": {
+						Type:     "sequentialNumber",
+						Elements: []string{"Heading"},
+					},
+				},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := base
+			if err := config.DeepMerge(&cfg, []byte(c.in), "a.json", nil); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cfg, c.out) {
+				t.Errorf("got %+v, want %+v", cfg, c.out)
+			}
+		})
+	}
+
+	// base is not modified
+	if got := base.Elements["Heading"].Templates["html"]; got != "<h1>" {
+		t.Errorf("base modified: got %q", got)
+	}
+}
+
+func TestDeepMergeDefault(t *testing.T) {
+	cfg := config.Default
+	if err := config.DeepMerge(&cfg, []byte(`{}`), "a.json", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, config.Default) {
+		t.Error("empty merge changed the default config")
+	}
+}
+
+func TestDeepMergeError(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		err  string
+	}{
+		{"syntax", `{`, "unexpected end of JSON input"},
+		{"not an object", `[]`, "config is not an object"},
+		{"type", `{"Elements": {"Note": {"Disabled": "yes"}}}`, "cannot unmarshal string"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := base
+			err := config.DeepMerge(&cfg, []byte(c.in), "a.json", nil)
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("got error %v, want %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	prov, err := config.NewProvenance(&base, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := base
+	layers := []struct {
+		name string
+		src  string
+	}{
+		{"a.json", `{"Elements": {"Heading": {"Templates": {"html": "<h2>"}}, "Term": {"Delimiter": "?"}}}`},
+		{"b.json", `{"Elements": {"Note": null, "Term": {"Delimiter": "!"}}}`},
+	}
+	for _, l := range layers {
+		if err := config.DeepMerge(&cfg, []byte(l.src), l.name, prov); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		path  string
+		layer string
+	}{
+		{"Templates.html", "default"},
+		{"Elements.Heading.Type", "default"},
+		{"Elements.Heading.Templates.html", "a.json"},
+		{"Elements.Heading.Templates.markdown", "default"},
+		{"Elements.Term.Delimiter", "b.json"},
+		{"Elements.Note.Type", "b.json"},                // deleted
+		{"Elements.Heading.Templates.html.x", "a.json"}, // nearest enclosing
+	}
+	for _, c := range cases {
+		if got := prov.Layer(c.path); got != c.layer {
+			t.Errorf("%s: got %q, want %q", c.path, got, c.layer)
+		}
+	}
+
+	for _, p := range prov.Paths() {
+		if strings.HasPrefix(p, "Elements.Note.") {
+			t.Errorf("deleted property %s recorded", p)
+		}
+	}
+}
+
+func TestProvenanceReplace(t *testing.T) {
+	prov, err := config.NewProvenance(&base, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := config.Config{
+		Elements: config.Elements{"Term": {Type: "hanging", Delimiter: "?"}},
+		Filters:  []config.Filter{{Command: "./f"}},
+	}
+	if err := prov.Replace(&c, "a.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path  string
+		layer string
+	}{
+		{"Elements.Heading.Type", "default"},
+		{"Elements.Term.Delimiter", "a.json"},
+		{"Filters.0.Command", "a.json"},
+	}
+	for _, c := range cases {
+		if got := prov.Layer(c.path); got != c.layer {
+			t.Errorf("%s: got %q, want %q", c.path, got, c.layer)
+		}
+	}
+}
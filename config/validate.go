@@ -0,0 +1,316 @@
+package config
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+	texttemplate "text/template"
+	"unicode/utf8"
+
+	"github.com/touchmarine/to/node"
+	totemplate "github.com/touchmarine/to/template"
+)
+
+// Built-in group element types (transformer names).
+const (
+	TypeParagraph = "paragraph"
+	TypeList      = "list"
+	TypeSticky    = "sticky"
+	TypeMetadata  = "metadata"
+	TypeFootnote  = "footnote"
+	TypeLabel     = "label"
+)
+
+// OptionComment is the option that marks node elements as comments (e.g. for
+// syntax highlighting).
+const OptionComment = "comment"
+
+// Types holds the names of the available group element, aggregate, and
+// matcher types. Package registry provides the registered ones.
+type Types struct {
+	Groups     []string
+	Aggregates []string
+	Matchers   []string
+}
+
+// Error is a config error found by Validate.
+type Error struct {
+	File     string // config file that set the property (if known)
+	Property string // dot-separated property path, e.g. Elements.Note.Delimiter
+	Message  string // human-readable description
+}
+
+// Error returns the error message prefixed with the file and property in the
+// form file: property: message.
+func (e Error) Error() string {
+	s := e.Message
+	if e.Property != "" {
+		s = e.Property + ": " + s
+	}
+	if e.File != "" {
+		s = e.File + ": " + s
+	}
+	return s
+}
+
+// ErrorList is a list of config errors.
+type ErrorList []*Error
+
+// Error returns a summary of errors.
+func (el ErrorList) Error() string {
+	switch len(el) {
+	case 0:
+		return "no errors"
+	case 1:
+		return el[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", el[0], len(el)-1)
+}
+
+// Err returns this error list as an error type.
+func (el ErrorList) Err() error {
+	if len(el) == 0 {
+		return nil
+	}
+	return el
+}
+
+// Add adds the error to the error list.
+func (el *ErrorList) Add(err *Error) {
+	*el = append(*el, err)
+}
+
+// Validate checks the config for errors that would otherwise surface only when
+// parsing or building—as panics, exits, or template errors:
+// 	- unknown element, aggregate, and matcher types
+// 	- missing and invalid delimiters (inline delimiters must be one
+// 	  character long unless the element is prefixed)
+// 	- delimiter conflicts between elements
+// 	- group elements referencing nonexistent elements
+// 	- filters without a command
+// 	- missing templates and template syntax errors
+//
+// The templates are checked for the given formats or, if formats is nil, for
+// all formats of the root templates. If prov is not nil, the errors include
+// the config files that set the invalid properties.
+func (c Config) Validate(types Types, formats []string, prov Provenance) ErrorList {
+	v := validator{
+		c:     c,
+		types: types,
+		prov:  prov,
+	}
+	v.elements()
+	v.aggregates()
+	v.filters()
+	if formats == nil {
+		for f := range c.Templates {
+			formats = append(formats, f)
+		}
+		sort.Strings(formats)
+	}
+	for _, f := range formats {
+		v.templates(f)
+	}
+	return v.errors
+}
+
+type validator struct {
+	c      Config
+	types  Types
+	prov   Provenance
+	errors ErrorList
+}
+
+func (v *validator) errorf(property, format string, a ...interface{}) {
+	v.errors.Add(&Error{
+		File:     v.prov.Layer(property),
+		Property: property,
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+// names returns the enabled element names in sorted order.
+func (v *validator) names() []string {
+	var names []string
+	for n, e := range v.c.Elements {
+		if !e.Disabled {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (v *validator) exists(name string) bool {
+	e, ok := v.c.Elements[name]
+	return ok && !e.Disabled
+}
+
+func (v *validator) elements() {
+	delimiters := map[string]string{} // parser delimiters to element names
+	for _, n := range v.names() {
+		e := v.c.Elements[n]
+		p := "Elements." + n
+
+		var t node.Type
+		if err := (&t).UnmarshalText([]byte(e.Type)); err != nil {
+			v.group(n, e)
+			continue
+		}
+
+		if e.Option != "" && e.Option != OptionComment {
+			v.errorf(p+".Option", "invalid option %q (want %q or none)", e.Option, OptionComment)
+		}
+
+		if e.Matcher != "" {
+			if t != node.TypePrefixed {
+				v.errorf(p+".Matcher", "matcher is supported only by prefixed elements")
+			} else if !contains(v.types.Matchers, e.Matcher) {
+				v.errorf(p+".Matcher", "unknown matcher %q", e.Matcher)
+			}
+		}
+
+		// the delimiters as registered by the parser
+		var key string
+		switch {
+		case t == node.TypeLeaf:
+			key = "leaf"
+		case t == node.TypeText:
+			key = "text"
+		case e.Delimiter == "":
+			v.errorf(p+".Delimiter", "missing delimiter")
+			continue
+		case t == node.TypeRankedHanging:
+			key = e.Delimiter + e.Delimiter
+		case node.IsInline(t) && t != node.TypePrefixed:
+			if utf8.RuneCountInString(e.Delimiter) != 1 {
+				v.errorf(p+".Delimiter", "inline delimiter %q must be one character long", e.Delimiter)
+				continue
+			}
+			key = e.Delimiter + e.Delimiter
+		default:
+			key = e.Delimiter
+		}
+		if other, ok := delimiters[key]; ok {
+			switch key {
+			case "leaf", "text":
+				v.errorf(p+".Type", "only one %s element allowed, also %s", key, other)
+			default:
+				v.errorf(p+".Delimiter", "delimiter %q conflicts with %s", e.Delimiter, other)
+			}
+			continue
+		}
+		delimiters[key] = n
+	}
+}
+
+// group checks the group element.
+func (v *validator) group(n string, e Element) {
+	p := "Elements." + n
+	if !contains(v.types.Groups, e.Type) {
+		v.errorf(p+".Type", "unknown type %q", e.Type)
+		return
+	}
+	switch e.Type {
+	case TypeParagraph:
+		var t node.Type
+		if err := (&t).UnmarshalText([]byte(e.Option)); err != nil {
+			v.errorf(p+".Option", "invalid paragraph type %q", e.Option)
+		}
+	case TypeList, TypeMetadata:
+		v.reference(p+".Element", e.Element)
+	case TypeSticky:
+		v.reference(p+".Element", e.Element)
+		if e.Target != "" {
+			// no target=sticks to any element
+			v.reference(p+".Target", e.Target)
+		}
+		if e.Option != "" && e.Option != "after" {
+			v.errorf(p+".Option", "invalid sticky option %q (want \"after\" or none)", e.Option)
+		}
+	case TypeFootnote, TypeLabel:
+		v.reference(p+".Element", e.Element)
+		v.reference(p+".Target", e.Target)
+	}
+}
+
+// reference checks that the referenced element exists.
+func (v *validator) reference(property, name string) {
+	if name == "" {
+		v.errorf(property, "missing element")
+	} else if e, ok := v.c.Elements[name]; !ok {
+		v.errorf(property, "element %q does not exist", name)
+	} else if e.Disabled {
+		v.errorf(property, "element %q is disabled", name)
+	}
+}
+
+func (v *validator) aggregates() {
+	var names []string
+	for n := range v.c.Aggregates {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		a := v.c.Aggregates[n]
+		p := "Aggregates." + n
+		if !contains(v.types.Aggregates, a.Type) {
+			v.errorf(p+".Type", "unknown type %q", a.Type)
+		}
+		for _, e := range a.Elements {
+			if !v.exists(e) {
+				v.errorf(p+".Elements", "element %q does not exist", e)
+			}
+		}
+	}
+}
+
+func (v *validator) filters() {
+	for i, f := range v.c.Filters {
+		if f.Command == "" {
+			v.errorf(fmt.Sprintf("Filters.%d.Command", i), "missing command")
+		}
+	}
+}
+
+// templates checks that the templates of the given format exist and parse.
+func (v *validator) templates(format string) {
+	text := v.c.IsTextFormat(format)
+	parse := func(property, name, s string) {
+		var err error
+		if text {
+			t := texttemplate.New(name)
+			_, err = t.Funcs(totemplate.TextFuncs(t, nil)).Parse(s)
+		} else {
+			t := template.New(name)
+			_, err = t.Funcs(totemplate.Funcs(t, nil)).Parse(s)
+		}
+		if err != nil {
+			v.errorf(property, "%v", err)
+		}
+	}
+
+	if s, ok := v.c.Templates[format]; ok {
+		parse("Templates."+format, format, s)
+	} else {
+		v.errorf("Templates."+format, "missing template")
+	}
+	for _, n := range v.names() {
+		s, ok := v.c.Elements[n].Templates[format]
+		if !ok {
+			v.errorf("Elements."+n+".Templates."+format, "missing template")
+			continue
+		}
+		parse("Elements."+n+".Templates."+format, n, s)
+	}
+}
+
+func contains(a []string, s string) bool {
+	for _, x := range a {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package config_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/registry"
+)
+
+func TestValidateDefault(t *testing.T) {
+	for _, err := range config.Default.Validate(registry.Types(), nil, nil) {
+		t.Error(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      string
+		formats []string
+		out     []string
+	}{
+		{
+			"valid",
+			`{"Elements": {"Heading": {"Templates": {"html": "<h2>"}}}}`,
+			nil,
+			nil,
+		},
+		{
+			"delimiter conflict",
+			`{"Elements": {"Note": {"Delimiter": ">"}}}`,
+			[]string{},
+			[]string{`a.json: Elements.Note.Delimiter: delimiter ">" conflicts with Blockquote`},
+		},
+		{
+			"ranked delimiter conflict",
+			`{"Elements": {"Note": {"Delimiter": "=="}}}`,
+			[]string{},
+			[]string{`a.json: Elements.Note.Delimiter: delimiter "==" conflicts with Heading`},
+		},
+		{
+			"inline delimiter conflict",
+			`{"Elements": {"X": {"Type": "uniform", "Delimiter": "*"}}}`,
+			[]string{},
+			[]string{`a.json: Elements.X.Delimiter: delimiter "*" conflicts with Strong`},
+		},
+		{
+			"inline delimiter length",
+			`{"Elements": {"Strong": {"Delimiter": "**"}}}`,
+			[]string{},
+			[]string{`a.json: Elements.Strong.Delimiter: inline delimiter "**" must be one character long`},
+		},
+		{
+			"missing delimiter",
+			`{"Elements": {"Note": {"Delimiter": null}}}`,
+			[]string{},
+			[]string{`a.json: Elements.Note.Delimiter: missing delimiter`},
+		},
+		{
+			"unknown type",
+			`{"Elements": {"Note": {"Type": "hung"}}}`,
+			[]string{},
+			[]string{`a.json: Elements.Note.Type: unknown type "hung"`},
+		},
+		{
+			"unknown matcher",
+			`{"Elements": {"HTTP": {"Matcher": "uri"}}}`,
+			[]string{},
+			[]string{`a.json: Elements.HTTP.Matcher: unknown matcher "uri"`},
+		},
+		{
+			"matcher of non-prefixed",
+			`{"Elements": {"Note": {"Matcher": "url"}}}`,
+			[]string{},
+			[]string{`a.json: Elements.Note.Matcher: matcher is supported only by prefixed elements`},
+		},
+		{
+			"list element",
+			`{"Elements": {"List": {"Element": "Item"}}}`,
+			[]string{},
+			[]string{`a.json: Elements.List.Element: element "Item" does not exist`},
+		},
+		{
+			"disabled list element",
+			`{"Elements": {"ListItem": {"Disabled": true}}}`,
+			[]string{},
+			[]string{`default: Elements.List.Element: element "ListItem" is disabled`},
+		},
+		{
+			"metadata element",
+			`{"Elements": {"FrontMatter": {"Element": "Meta"}}}`,
+			[]string{},
+			[]string{`a.json: Elements.FrontMatter.Element: element "Meta" does not exist`},
+		},
+		{
+			"sticky target",
+			`{"Elements": {"NamedLink": {"Target": "Anchor"}}}`,
+			[]string{},
+			[]string{`a.json: Elements.NamedLink.Target: element "Anchor" does not exist`},
+		},
+		{
+			"footnote reference",
+			`{"Elements": {"Footnotes": {"Target": ""}}}`,
+			[]string{},
+			[]string{`a.json: Elements.Footnotes.Target: missing element`},
+		},
+		{
+			"label element",
+			`{"Elements": {"FigureLabel": {"Element": "Picture"}}}`,
+			[]string{},
+			[]string{`a.json: Elements.FigureLabel.Element: element "Picture" does not exist`},
+		},
+		{
+			"paragraph option",
+			`{"Elements": {"Paragraph": {"Option": "block"}}}`,
+			[]string{},
+			[]string{`a.json: Elements.Paragraph.Option: invalid paragraph type "block"`},
+		},
+		{
+			"element option",
+			`{"Elements": {"Code": {"Option": "comments"}}}`,
+			[]string{},
+			[]string{`a.json: Elements.Code.Option: invalid option "comments" (want "comment" or none)`},
+		},
+		{
+			"aggregate",
+			`{"Aggregates": {"a": {"Type": "count", "Elements": ["Heading", "Section"]}}}`,
+			[]string{},
+			[]string{
+				`a.json: Aggregates.a.Type: unknown type "count"`,
+				`a.json: Aggregates.a.Elements: element "Section" does not exist`,
+			},
+		},
+		{
+			"filter command",
+			`{"Filters": [{"Command": "a"}, {"Args": ["x"]}]}`,
+			[]string{},
+			[]string{`a.json: Filters.1.Command: missing command`},
+		},
+		{
+			"missing template",
+			`{"Elements": {"X": {"Type": "walled", "Delimiter": "&", "Templates": {"html": ""}}}}`,
+			nil,
+			[]string{`a.json: Elements.X.Templates.markdown: missing template`},
+		},
+		{
+			"missing root template",
+			`{"Templates": {"markdown": null}}`,
+			[]string{"markdown"},
+			[]string{`a.json: Templates.markdown: missing template`},
+		},
+		{
+			"template syntax",
+			`{"Elements": {"Emphasis": {"Templates": {"html": "{{.Foo"}}}}`,
+			[]string{"html"},
+			[]string{`a.json: Elements.Emphasis.Templates.html: template: Emphasis:1: unclosed action`},
+		},
+		{
+			"template function",
+			`{"Elements": {"Emphasis": {"Templates": {"markdown": "{{foo .}}"}}}}`,
+			[]string{"markdown"},
+			[]string{`a.json: Elements.Emphasis.Templates.markdown: template: Emphasis:1: function "foo" not defined`},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			prov, err := config.NewProvenance(&config.Default, "default")
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := config.Default
+			if err := config.DeepMerge(&cfg, []byte(c.in), "a.json", prov); err != nil {
+				t.Fatal(err)
+			}
+
+			var out []string
+			for _, err := range cfg.Validate(registry.Types(), c.formats, prov) {
+				out = append(out, err.Error())
+			}
+			if !reflect.DeepEqual(out, c.out) {
+				t.Errorf("got %q, want %q", out, c.out)
+			}
+		})
+	}
+}
+
+func TestErrorList(t *testing.T) {
+	el := config.ErrorList{
+		{File: "a.json", Property: "Elements.A", Message: "x"},
+		{Property: "Elements.B", Message: "y"},
+	}
+	if got, want := el.Error(), "a.json: Elements.A: x (and 1 more errors)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := el[1].Error(), "Elements.B: y"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := (config.ErrorList{}).Err(); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+}
+
+// TestSchema checks that the schema describes exactly the config fields.
+func TestSchema(t *testing.T) {
+	var schema struct {
+		Properties  map[string]json.RawMessage
+		Definitions map[string]struct {
+			Properties map[string]json.RawMessage
+		}
+	}
+	if err := json.Unmarshal(config.Schema, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name       string
+		typ        interface{}
+		properties map[string]json.RawMessage
+	}{
+		{"Config", config.Config{}, schema.Properties},
+		{"Element", config.Element{}, schema.Definitions["element"].Properties},
+		{"Aggregate", config.Aggregate{}, schema.Definitions["aggregate"].Properties},
+		{"Format", config.Format{}, schema.Definitions["format"].Properties},
+		{"Filter", config.Filter{}, schema.Definitions["filter"].Properties},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var fields, properties []string
+			typ := reflect.TypeOf(c.typ)
+			for i := 0; i < typ.NumField(); i++ {
+				fields = append(fields, typ.Field(i).Name)
+			}
+			for p := range c.properties {
+				if c.name == "Config" && p == config.ExtendsKey {
+					// resolved by ReadFile
+					continue
+				}
+				properties = append(properties, p)
+			}
+			sort.Strings(fields)
+			sort.Strings(properties)
+			if !reflect.DeepEqual(fields, properties) {
+				t.Errorf("got properties %s, want %s", strings.Join(properties, ","), strings.Join(fields, ","))
+			}
+		})
+	}
+}
@@ -0,0 +1,656 @@
+package markdown
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The block parser follows the parsing strategy described in the CommonMark
+// spec: each line is first matched against the open container blocks, then
+// new block starts are looked for, and finally the rest of the line is added
+// to the innermost block (or a lazy paragraph continuation).
+//
+// https://spec.commonmark.org/0.31.2/#appendix-a-parsing-strategy
+
+var (
+	reATXHeading        = regexp.MustCompile(`^#{1,6}(?:[ \t]+|$)`)
+	reATXClosing        = regexp.MustCompile(`(?:^[ \t]*#+[ \t]*$)|(?:[ \t]+#+[ \t]*$)`)
+	reSetextHeading     = regexp.MustCompile(`^(?:=+|-+)[ \t]*$`)
+	reThematicBreak     = regexp.MustCompile(`^(?:(?:\*[ \t]*){3,}|(?:_[ \t]*){3,}|(?:-[ \t]*){3,})$`)
+	reClosingCodeFence  = regexp.MustCompile("^(?:`{3,}|~{3,})[ \t]*$")
+	reOrderedListMarker = regexp.MustCompile(`^(\d{1,9})([.)])`)
+)
+
+var reHTMLBlockOpen = []*regexp.Regexp{
+	nil, // types are 1-based
+	regexp.MustCompile(`(?i)^<(?:script|pre|textarea|style)(?:\s|>|$)`),
+	regexp.MustCompile(`^<!--`),
+	regexp.MustCompile(`^<[?]`),
+	regexp.MustCompile(`^<![A-Za-z]`),
+	regexp.MustCompile(`^<!\[CDATA\[`),
+	regexp.MustCompile(`(?i)^</?(?:address|article|aside|base|basefont|blockquote|body|caption|center|col|colgroup|dd|details|dialog|dir|div|dl|dt|fieldset|figcaption|figure|footer|form|frame|frameset|h[123456]|head|header|hr|html|iframe|legend|li|link|main|menu|menuitem|nav|noframes|ol|optgroup|option|p|param|search|section|summary|table|tbody|td|tfoot|th|thead|title|tr|track|ul)(?:\s|/?>|$)`),
+	regexp.MustCompile(`(?i)^(?:` + openTag + `|` + closeTag + `)\s*$`),
+}
+
+var reHTMLBlockClose = []*regexp.Regexp{
+	nil,
+	regexp.MustCompile(`(?i)</(?:script|pre|textarea|style)>`),
+	regexp.MustCompile(`-->`),
+	regexp.MustCompile(`\?>`),
+	regexp.MustCompile(`>`),
+	regexp.MustCompile(`\]\]>`),
+}
+
+type listData struct {
+	ordered      bool
+	bulletChar   byte
+	start        int
+	delimiter    byte
+	markerOffset int
+	padding      int
+}
+
+type blockParser struct {
+	doc *mdNode
+	tip *mdNode // innermost open block
+
+	oldtip               *mdNode
+	lastMatchedContainer *mdNode
+	allClosed            bool
+
+	line       string
+	lineNumber int
+
+	offset               int // byte offset in line
+	column               int // column in line (tabs expanded)
+	nextNonspace         int
+	nextNonspaceColumn   int
+	indent               int
+	indented             bool
+	blank                bool
+	partiallyConsumedTab bool
+
+	refmap map[string]linkReference
+}
+
+// parse parses the src into a tree of block nodes whose inline content is
+// parsed as well.
+func parse(src []byte) *mdNode {
+	s := strings.ReplaceAll(string(src), "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	s = strings.ReplaceAll(s, "\x00", "�")
+	lines := strings.Split(s, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	doc := &mdNode{kind: kindDocument, line: 1, open: true}
+	p := &blockParser{
+		doc:    doc,
+		tip:    doc,
+		refmap: map[string]linkReference{},
+	}
+	for _, line := range lines {
+		p.incorporateLine(line)
+	}
+	for p.tip != nil {
+		p.finalize(p.tip)
+	}
+
+	walk(doc, func(n *mdNode) {
+		if n.kind == kindParagraph || n.kind == kindHeading {
+			parseInlines(n, p.refmap)
+		}
+	})
+	return doc
+}
+
+func (p *blockParser) incorporateLine(line string) {
+	p.line = line
+	p.lineNumber++
+	p.offset = 0
+	p.column = 0
+	p.blank = false
+	p.partiallyConsumedTab = false
+	p.oldtip = p.tip
+
+	// try to continue the open blocks
+	allMatched := true
+	container := p.doc
+	for {
+		last := container.lastChild
+		if last == nil || !last.open {
+			break
+		}
+		container = last
+
+		p.findNextNonspace()
+		switch p.continueBlock(container) {
+		case continueMatched:
+		case continueNotMatched:
+			allMatched = false
+		case continueLineConsumed:
+			// e.g. closing code fence
+			return
+		}
+		if !allMatched {
+			container = container.parent
+			break
+		}
+	}
+
+	p.allClosed = container == p.oldtip
+	p.lastMatchedContainer = container
+
+	// look for new block starts
+	matchedLeaf := container.kind != kindParagraph && acceptsLines(container.kind)
+	for !matchedLeaf {
+		p.findNextNonspace()
+		if p.blank || !p.indented && !maybeSpecial(p.line[p.nextNonspace]) {
+			p.advanceNextNonspace()
+			break
+		}
+
+		res := startNone
+		for _, start := range blockStarts {
+			if res = start(p, container); res != startNone {
+				break
+			}
+		}
+		if res == startNone {
+			p.advanceNextNonspace()
+			break
+		}
+		container = p.tip
+		if res == startLeaf {
+			matchedLeaf = true
+		}
+	}
+
+	// add the rest of the line
+	if !p.allClosed && !p.blank && p.tip.kind == kindParagraph {
+		// lazy paragraph continuation
+		p.addLine()
+		return
+	}
+
+	p.closeUnmatchedBlocks()
+	switch {
+	case acceptsLines(container.kind):
+		p.addLine()
+		if container.kind == kindHTMLBlock && container.htmlType >= 1 && container.htmlType <= 5 &&
+			reHTMLBlockClose[container.htmlType].MatchString(p.line[p.offset:]) {
+			p.finalize(container)
+		}
+	case p.offset < len(p.line) && !p.blank:
+		p.addChild(kindParagraph)
+		p.advanceNextNonspace()
+		p.addLine()
+	}
+}
+
+const (
+	continueMatched = iota
+	continueNotMatched
+	continueLineConsumed
+)
+
+// continueBlock reports whether the line continues the given open block and
+// consumes its prefix, e.g. the '>' of a blockquote.
+func (p *blockParser) continueBlock(n *mdNode) int {
+	switch n.kind {
+	case kindBlockquote:
+		if !p.indented && p.peek(p.nextNonspace) == '>' {
+			p.advanceNextNonspace()
+			p.advanceOffset(1, false)
+			if isSpaceOrTab(p.peek(p.offset)) {
+				p.advanceOffset(1, true)
+			}
+			return continueMatched
+		}
+		return continueNotMatched
+	case kindItem:
+		if p.blank {
+			if n.firstChild == nil {
+				// blank line after an empty list item
+				return continueNotMatched
+			}
+			p.advanceNextNonspace()
+		} else if p.indent >= n.list.markerOffset+n.list.padding {
+			p.advanceOffset(n.list.markerOffset+n.list.padding, true)
+		} else {
+			return continueNotMatched
+		}
+		return continueMatched
+	case kindHeading, kindThematicBreak:
+		// single line blocks
+		return continueNotMatched
+	case kindCodeBlock:
+		if n.fenced {
+			rest := p.line[p.nextNonspace:]
+			if p.indent <= 3 && len(rest) > 0 && rest[0] == n.fenceChar &&
+				reClosingCodeFence.MatchString(rest) &&
+				len(strings.TrimRight(rest, " \t")) >= n.fenceLength {
+				p.finalize(n)
+				return continueLineConsumed
+			}
+			// skip optional spaces of fence offset
+			for i := n.fenceOffset; i > 0 && isSpaceOrTab(p.peek(p.offset)); i-- {
+				p.advanceOffset(1, true)
+			}
+			return continueMatched
+		}
+		if p.indent >= 4 {
+			p.advanceOffset(4, true)
+		} else if p.blank {
+			p.advanceNextNonspace()
+		} else {
+			return continueNotMatched
+		}
+		return continueMatched
+	case kindHTMLBlock:
+		if p.blank && (n.htmlType == 6 || n.htmlType == 7) {
+			return continueNotMatched
+		}
+		return continueMatched
+	case kindParagraph:
+		if p.blank {
+			return continueNotMatched
+		}
+		return continueMatched
+	}
+	return continueMatched
+}
+
+const (
+	startNone = iota
+	startContainer
+	startLeaf
+)
+
+var blockStarts = []func(p *blockParser, container *mdNode) int{
+	// blockquote
+	func(p *blockParser, container *mdNode) int {
+		if p.indented || p.peek(p.nextNonspace) != '>' {
+			return startNone
+		}
+		p.advanceNextNonspace()
+		p.advanceOffset(1, false)
+		if isSpaceOrTab(p.peek(p.offset)) {
+			p.advanceOffset(1, true)
+		}
+		p.closeUnmatchedBlocks()
+		p.addChild(kindBlockquote)
+		return startContainer
+	},
+
+	// ATX heading
+	func(p *blockParser, container *mdNode) int {
+		if p.indented {
+			return startNone
+		}
+		m := reATXHeading.FindString(p.line[p.nextNonspace:])
+		if m == "" {
+			return startNone
+		}
+		p.advanceNextNonspace()
+		p.advanceOffset(len(m), false)
+		p.closeUnmatchedBlocks()
+		h := p.addChild(kindHeading)
+		h.level = len(strings.TrimRight(m, " \t"))
+		h.content = reATXClosing.ReplaceAllString(p.line[p.offset:], "")
+		p.advanceOffset(len(p.line)-p.offset, false)
+		return startLeaf
+	},
+
+	// fenced code block
+	func(p *blockParser, container *mdNode) int {
+		if p.indented {
+			return startNone
+		}
+		rest := p.line[p.nextNonspace:]
+		if rest == "" || rest[0] != '`' && rest[0] != '~' {
+			return startNone
+		}
+		ch := rest[0]
+		n := 0
+		for n < len(rest) && rest[n] == ch {
+			n++
+		}
+		if n < 3 || ch == '`' && strings.Contains(rest[n:], "`") {
+			return startNone
+		}
+		p.closeUnmatchedBlocks()
+		c := p.addChild(kindCodeBlock)
+		c.fenced = true
+		c.fenceChar = ch
+		c.fenceLength = n
+		c.fenceOffset = p.indent
+		c.info = unescapeString(strings.TrimSpace(rest[n:]))
+		p.advanceNextNonspace()
+		p.advanceOffset(len(p.line)-p.offset, false)
+		return startLeaf
+	},
+
+	// HTML block
+	func(p *blockParser, container *mdNode) int {
+		if p.indented || p.peek(p.nextNonspace) != '<' {
+			return startNone
+		}
+		rest := p.line[p.nextNonspace:]
+		for t := 1; t <= 7; t++ {
+			if !reHTMLBlockOpen[t].MatchString(rest) {
+				continue
+			}
+			if t == 7 && (container.kind == kindParagraph ||
+				!p.allClosed && !p.blank && p.tip.kind == kindParagraph) {
+				// type 7 cannot interrupt a paragraph
+				continue
+			}
+			p.closeUnmatchedBlocks()
+			// the offset is not advanced, spaces are part of the block
+			b := p.addChild(kindHTMLBlock)
+			b.htmlType = t
+			return startLeaf
+		}
+		return startNone
+	},
+
+	// setext heading
+	func(p *blockParser, container *mdNode) int {
+		if p.indented || container.kind != kindParagraph ||
+			!reSetextHeading.MatchString(p.line[p.nextNonspace:]) {
+			return startNone
+		}
+		p.closeUnmatchedBlocks()
+		container.content = parseReferences(container.content, p.refmap)
+		if strings.TrimSpace(container.content) == "" {
+			return startNone
+		}
+		h := &mdNode{
+			kind:    kindHeading,
+			line:    container.line,
+			open:    true,
+			content: container.content,
+		}
+		if p.line[p.nextNonspace] == '=' {
+			h.level = 1
+		} else {
+			h.level = 2
+		}
+		container.insertAfter(h)
+		container.unlink()
+		p.tip = h
+		p.advanceOffset(len(p.line)-p.offset, false)
+		return startLeaf
+	},
+
+	// thematic break
+	func(p *blockParser, container *mdNode) int {
+		if p.indented || !reThematicBreak.MatchString(p.line[p.nextNonspace:]) {
+			return startNone
+		}
+		p.closeUnmatchedBlocks()
+		p.addChild(kindThematicBreak)
+		p.advanceOffset(len(p.line)-p.offset, false)
+		return startLeaf
+	},
+
+	// list item
+	func(p *blockParser, container *mdNode) int {
+		if p.indented && container.kind != kindList {
+			return startNone
+		}
+		data, ok := p.parseListMarker(container)
+		if !ok {
+			return startNone
+		}
+		p.closeUnmatchedBlocks()
+		if p.tip.kind != kindList || !listsMatch(p.tip.list, data) {
+			l := p.addChild(kindList)
+			l.list = data
+		}
+		item := p.addChild(kindItem)
+		item.list = data
+		return startContainer
+	},
+
+	// indented code block
+	func(p *blockParser, container *mdNode) int {
+		if !p.indented || p.tip.kind == kindParagraph || p.blank {
+			return startNone
+		}
+		p.advanceOffset(4, true)
+		p.closeUnmatchedBlocks()
+		p.addChild(kindCodeBlock)
+		return startLeaf
+	},
+}
+
+// parseListMarker parses a list marker and consumes it and the spaces that
+// follow it.
+func (p *blockParser) parseListMarker(container *mdNode) (listData, bool) {
+	if p.indent >= 4 {
+		return listData{}, false
+	}
+	rest := p.line[p.nextNonspace:]
+	data := listData{markerOffset: p.indent}
+	var markerLength int
+	switch {
+	case rest[0] == '*' || rest[0] == '+' || rest[0] == '-':
+		data.bulletChar = rest[0]
+		markerLength = 1
+	default:
+		m := reOrderedListMarker.FindStringSubmatch(rest)
+		if m == nil || container.kind == kindParagraph && m[1] != "1" {
+			return listData{}, false
+		}
+		data.ordered = true
+		data.start, _ = strconv.Atoi(m[1])
+		data.delimiter = m[2][0]
+		markerLength = len(m[0])
+	}
+
+	// must be followed by spacing or the end of the line
+	if c := p.peek(p.nextNonspace + markerLength); c != 0 && !isSpaceOrTab(c) {
+		return listData{}, false
+	}
+	// an empty list item cannot interrupt a paragraph
+	if container.kind == kindParagraph && strings.TrimLeft(rest[markerLength:], " \t") == "" {
+		return listData{}, false
+	}
+
+	p.advanceNextNonspace()
+	p.advanceOffset(markerLength, true)
+	spacesStartColumn := p.column
+	spacesStartOffset := p.offset
+	for {
+		p.advanceOffset(1, true)
+		if !(p.column-spacesStartColumn < 5 && isSpaceOrTab(p.peek(p.offset))) {
+			break
+		}
+	}
+	blankItem := p.offset >= len(p.line)
+	spacesAfterMarker := p.column - spacesStartColumn
+	if spacesAfterMarker >= 5 || spacesAfterMarker < 1 || blankItem {
+		// content starts after a single space, the rest is part of
+		// an indented code block (or the item is blank)
+		data.padding = markerLength + 1
+		p.column = spacesStartColumn
+		p.offset = spacesStartOffset
+		if isSpaceOrTab(p.peek(p.offset)) {
+			p.advanceOffset(1, true)
+		}
+	} else {
+		data.padding = markerLength + spacesAfterMarker
+	}
+	return data, true
+}
+
+func listsMatch(a, b listData) bool {
+	return a.ordered == b.ordered && a.delimiter == b.delimiter && a.bulletChar == b.bulletChar
+}
+
+func (p *blockParser) peek(i int) byte {
+	if i < len(p.line) {
+		return p.line[i]
+	}
+	return 0
+}
+
+func (p *blockParser) findNextNonspace() {
+	i := p.offset
+	cols := p.column
+	for i < len(p.line) {
+		if c := p.line[i]; c == ' ' {
+			i++
+			cols++
+		} else if c == '\t' {
+			i++
+			cols += 4 - cols%4
+		} else {
+			break
+		}
+	}
+	p.blank = i >= len(p.line)
+	p.nextNonspace = i
+	p.nextNonspaceColumn = cols
+	p.indent = cols - p.column
+	p.indented = p.indent >= 4
+}
+
+func (p *blockParser) advanceNextNonspace() {
+	p.offset = p.nextNonspace
+	p.column = p.nextNonspaceColumn
+	p.partiallyConsumedTab = false
+}
+
+// advanceOffset advances the offset by count bytes or, if columns is true, by
+// count columns in which case tabs may be consumed partially.
+func (p *blockParser) advanceOffset(count int, columns bool) {
+	for count > 0 && p.offset < len(p.line) {
+		if p.line[p.offset] == '\t' {
+			charsToTab := 4 - p.column%4
+			if columns {
+				p.partiallyConsumedTab = charsToTab > count
+				charsToAdvance := charsToTab
+				if count < charsToAdvance {
+					charsToAdvance = count
+				}
+				p.column += charsToAdvance
+				if !p.partiallyConsumedTab {
+					p.offset++
+				}
+				count -= charsToAdvance
+			} else {
+				p.partiallyConsumedTab = false
+				p.column += charsToTab
+				p.offset++
+				count--
+			}
+		} else {
+			p.partiallyConsumedTab = false
+			p.offset++
+			p.column++
+			count--
+		}
+	}
+}
+
+// addLine adds the rest of the line to the content of the innermost block.
+func (p *blockParser) addLine() {
+	if p.partiallyConsumedTab {
+		p.offset++ // skip over the tab
+		// add the spaces the tab stands for
+		p.tip.content += strings.Repeat(" ", 4-p.column%4)
+	}
+	p.tip.content += p.line[p.offset:] + "\n"
+}
+
+// addChild adds a block of the given kind to the innermost block that can
+// contain it, closing the blocks that cannot.
+func (p *blockParser) addChild(k kind) *mdNode {
+	for !canContain(p.tip.kind, k) {
+		p.finalize(p.tip)
+	}
+	n := &mdNode{
+		kind: k,
+		line: p.lineNumber,
+		open: true,
+	}
+	p.tip.appendChild(n)
+	p.tip = n
+	return n
+}
+
+func (p *blockParser) closeUnmatchedBlocks() {
+	if p.allClosed {
+		return
+	}
+	for p.oldtip != p.lastMatchedContainer {
+		parent := p.oldtip.parent
+		p.finalize(p.oldtip)
+		p.oldtip = parent
+	}
+	p.allClosed = true
+}
+
+// finalize closes the block and makes its parent the innermost block.
+func (p *blockParser) finalize(n *mdNode) {
+	parent := n.parent
+	n.open = false
+	switch n.kind {
+	case kindParagraph:
+		n.content = parseReferences(n.content, p.refmap)
+		if strings.TrimSpace(n.content) == "" {
+			// only link reference definitions
+			n.unlink()
+		}
+	case kindCodeBlock:
+		if n.fenced {
+			// the first line is the opening fence
+			if i := strings.IndexByte(n.content, '\n'); i >= 0 {
+				n.content = n.content[i+1:]
+			}
+		} else {
+			// strip trailing blank lines
+			lines := strings.Split(n.content, "\n")
+			for len(lines) > 0 && strings.Trim(lines[len(lines)-1], " \t") == "" {
+				lines = lines[:len(lines)-1]
+			}
+			n.content = strings.Join(lines, "\n")
+			if n.content != "" {
+				n.content += "\n"
+			}
+		}
+	}
+	p.tip = parent
+}
+
+func canContain(parent, child kind) bool {
+	switch parent {
+	case kindDocument, kindBlockquote, kindItem:
+		return child != kindItem
+	case kindList:
+		return child == kindItem
+	}
+	return false
+}
+
+func acceptsLines(k kind) bool {
+	return k == kindParagraph || k == kindCodeBlock || k == kindHTMLBlock
+}
+
+// maybeSpecial reports whether ch can start a block other than a paragraph.
+func maybeSpecial(ch byte) bool {
+	switch ch {
+	case '#', '`', '~', '*', '+', '_', '=', '<', '>', '-':
+		return true
+	}
+	return ch >= '0' && ch <= '9'
+}
+
+func isSpaceOrTab(ch byte) bool {
+	return ch == ' ' || ch == '\t'
+}
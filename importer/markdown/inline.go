@@ -0,0 +1,818 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	tagName            = `[A-Za-z][A-Za-z0-9-]*`
+	attributeName      = `[a-zA-Z_:][a-zA-Z0-9:._-]*`
+	attributeValue     = `(?:[^"'=<>` + "`" + `\x00-\x20]+|'[^']*'|"[^"]*")`
+	attributeValueSpec = `(?:\s*=\s*` + attributeValue + `)`
+	attribute          = `(?:\s+` + attributeName + attributeValueSpec + `?)`
+	openTag            = `<` + tagName + attribute + `*\s*/?>`
+	closeTag           = `</` + tagName + `\s*[>]`
+	htmlComment        = `<!-->|<!--->|<!--[\s\S]*?-->`
+	processingInstr    = `[<][?][\s\S]*?[?][>]`
+	declaration        = `<![A-Za-z]+[^>]*>`
+	cdata              = `<!\[CDATA\[[\s\S]*?\]\]>`
+)
+
+var (
+	reHTMLTag        = regexp.MustCompile(`^(?:` + openTag + `|` + closeTag + `|` + htmlComment + `|` + processingInstr + `|` + declaration + `|` + cdata + `)`)
+	reEmailAutolink  = regexp.MustCompile(`^<([a-zA-Z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*)>`)
+	reAutolink       = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9.+-]{1,31}:[^<>\x00-\x20]*)>`)
+	reEntity         = regexp.MustCompile(`^&(?:#[xX][0-9a-fA-F]{1,6}|#[0-9]{1,7}|[A-Za-z][A-Za-z0-9]{1,31});`)
+	reLinkLabel      = regexp.MustCompile(`^\[(?:[^\\\[\]]|\\.){0,1000}\]`)
+	reLinkTitle      = regexp.MustCompile(`^(?:"(?:\\[\s\S]|[^"\\])*"|'(?:\\[\s\S]|[^'\\])*'|\((?:\\[\s\S]|[^()\\])*\))`)
+	reLinkDestBraces = regexp.MustCompile(`^<(?:[^<>\n\\\x00]|\\.)*>`)
+	reWhitespace     = regexp.MustCompile(`[ \t\r\n]+`)
+)
+
+type linkReference struct {
+	destination string
+	title       string
+}
+
+type delimiter struct {
+	ch        byte
+	count     int // remaining delimiters
+	origCount int
+	node      *mdNode
+	previous  *delimiter
+	next      *delimiter
+	canOpen   bool
+	canClose  bool
+}
+
+type bracket struct {
+	node              *mdNode
+	previous          *bracket
+	previousDelimiter *delimiter
+	index             int // position of '['
+	image             bool
+	active            bool
+	bracketAfter      bool
+}
+
+type inlineParser struct {
+	subject    string
+	pos        int
+	line       int // line of the subject start
+	refmap     map[string]linkReference
+	delimiters *delimiter // top of the delimiter stack
+	brackets   *bracket   // top of the bracket stack
+}
+
+// parseInlines parses the content of the block into inline children.
+func parseInlines(block *mdNode, refmap map[string]linkReference) {
+	p := &inlineParser{
+		subject: strings.TrimSpace(block.content),
+		line:    block.line,
+		refmap:  refmap,
+	}
+	for p.pos < len(p.subject) {
+		if !p.parseInline(block) {
+			// nothing matched, add the character as text
+			p.text(block, p.subject[p.pos:p.pos+1])
+			p.pos++
+		}
+	}
+	p.processEmphasis(nil)
+	mergeText(block)
+}
+
+func (p *inlineParser) peek() byte {
+	if p.pos < len(p.subject) {
+		return p.subject[p.pos]
+	}
+	return 0
+}
+
+// currentLine returns the line number of the current position.
+func (p *inlineParser) currentLine() int {
+	return p.line + strings.Count(p.subject[:p.pos], "\n")
+}
+
+func (p *inlineParser) text(block *mdNode, s string) *mdNode {
+	n := &mdNode{
+		kind:    kindText,
+		literal: s,
+	}
+	block.appendChild(n)
+	return n
+}
+
+func (p *inlineParser) parseInline(block *mdNode) bool {
+	switch p.peek() {
+	case '\n':
+		return p.parseNewline(block)
+	case '\\':
+		return p.parseBackslash(block)
+	case '`':
+		return p.parseBackticks(block)
+	case '*', '_':
+		return p.handleDelimiter(block, p.peek())
+	case '[':
+		p.pos++
+		n := p.text(block, "[")
+		p.addBracket(n, p.pos-1, false)
+		return true
+	case '!':
+		p.pos++
+		if p.peek() == '[' {
+			p.pos++
+			n := p.text(block, "![")
+			p.addBracket(n, p.pos-1, true)
+		} else {
+			p.text(block, "!")
+		}
+		return true
+	case ']':
+		return p.parseCloseBracket(block)
+	case '<':
+		return p.parseAutolink(block) || p.parseHTMLTag(block)
+	case '&':
+		return p.parseEntity(block)
+	}
+	return p.parseString(block)
+}
+
+func (p *inlineParser) parseNewline(block *mdNode) bool {
+	p.pos++ // assume we're at a '\n'
+	last := block.lastChild
+	if last != nil && last.kind == kindText && strings.HasSuffix(last.literal, " ") {
+		hard := strings.HasSuffix(last.literal, "  ")
+		last.literal = strings.TrimRight(last.literal, " ")
+		if hard {
+			block.appendChild(&mdNode{kind: kindHardBreak})
+		} else {
+			block.appendChild(&mdNode{kind: kindSoftBreak})
+		}
+	} else {
+		block.appendChild(&mdNode{kind: kindSoftBreak})
+	}
+	// skip spaces at the beginning of the next line
+	for p.peek() == ' ' {
+		p.pos++
+	}
+	return true
+}
+
+func (p *inlineParser) parseBackslash(block *mdNode) bool {
+	p.pos++
+	switch c := p.peek(); {
+	case c == '\n':
+		p.pos++
+		block.appendChild(&mdNode{kind: kindHardBreak})
+	case isASCIIPunct(c):
+		p.pos++
+		p.text(block, string(c))
+	default:
+		p.text(block, `\`)
+	}
+	return true
+}
+
+func (p *inlineParser) parseBackticks(block *mdNode) bool {
+	start := p.pos
+	for p.peek() == '`' {
+		p.pos++
+	}
+	ticks := p.subject[start:p.pos]
+	after := p.pos
+
+	for i := after; i < len(p.subject); {
+		j := strings.IndexByte(p.subject[i:], '`')
+		if j < 0 {
+			break
+		}
+		j += i
+		k := j
+		for k < len(p.subject) && p.subject[k] == '`' {
+			k++
+		}
+		if k-j == len(ticks) {
+			content := strings.ReplaceAll(p.subject[after:j], "\n", " ")
+			if len(content) > 2 && content[0] == ' ' && content[len(content)-1] == ' ' &&
+				strings.Trim(content, " ") != "" {
+				content = content[1 : len(content)-1]
+			}
+			block.appendChild(&mdNode{
+				kind:    kindCode,
+				line:    p.currentLine(),
+				literal: content,
+			})
+			p.pos = k
+			return true
+		}
+		i = k
+	}
+
+	// no matching backtick string
+	p.text(block, ticks)
+	return true
+}
+
+func (p *inlineParser) handleDelimiter(block *mdNode, ch byte) bool {
+	count, canOpen, canClose := p.scanDelimiters(ch)
+	start := p.pos
+	p.pos += count
+	n := p.text(block, p.subject[start:p.pos])
+	n.line = p.currentLine()
+
+	d := &delimiter{
+		ch:        ch,
+		count:     count,
+		origCount: count,
+		node:      n,
+		previous:  p.delimiters,
+		canOpen:   canOpen,
+		canClose:  canClose,
+	}
+	if d.previous != nil {
+		d.previous.next = d
+	}
+	p.delimiters = d
+	return true
+}
+
+// scanDelimiters returns the length of the delimiter run at the current
+// position and whether it can open and close emphasis.
+func (p *inlineParser) scanDelimiters(ch byte) (int, bool, bool) {
+	count := 0
+	for i := p.pos; i < len(p.subject) && p.subject[i] == ch; i++ {
+		count++
+	}
+
+	before := '\n'
+	if p.pos > 0 {
+		before, _ = utf8.DecodeLastRuneInString(p.subject[:p.pos])
+	}
+	after := '\n'
+	if p.pos+count < len(p.subject) {
+		after, _ = utf8.DecodeRuneInString(p.subject[p.pos+count:])
+	}
+
+	afterIsWhitespace := unicode.IsSpace(after)
+	afterIsPunct := isPunct(after)
+	beforeIsWhitespace := unicode.IsSpace(before)
+	beforeIsPunct := isPunct(before)
+
+	leftFlanking := !afterIsWhitespace && (!afterIsPunct || beforeIsWhitespace || beforeIsPunct)
+	rightFlanking := !beforeIsWhitespace && (!beforeIsPunct || afterIsWhitespace || afterIsPunct)
+	if ch == '_' {
+		return count, leftFlanking && (!rightFlanking || beforeIsPunct),
+			rightFlanking && (!leftFlanking || afterIsPunct)
+	}
+	return count, leftFlanking, rightFlanking
+}
+
+func (p *inlineParser) removeDelimiter(d *delimiter) {
+	if d.previous != nil {
+		d.previous.next = d.next
+	}
+	if d.next == nil {
+		// top of stack
+		p.delimiters = d.previous
+	} else {
+		d.next.previous = d.previous
+	}
+}
+
+// processEmphasis matches the emphasis delimiters above stackBottom.
+//
+// https://spec.commonmark.org/0.31.2/#process-emphasis
+func (p *inlineParser) processEmphasis(stackBottom *delimiter) {
+	type key struct {
+		ch      byte
+		mod3    int
+		canOpen bool
+	}
+	openersBottom := map[key]*delimiter{}
+
+	// find first closer above stackBottom
+	closer := p.delimiters
+	for closer != nil && closer.previous != stackBottom {
+		closer = closer.previous
+	}
+
+	for closer != nil {
+		if !closer.canClose {
+			closer = closer.next
+			continue
+		}
+
+		k := key{closer.ch, closer.origCount % 3, closer.canOpen}
+		bottom, ok := openersBottom[k]
+		if !ok {
+			bottom = stackBottom
+		}
+
+		// look back for the first matching opener
+		opener := closer.previous
+		found := false
+		for opener != nil && opener != stackBottom && opener != bottom {
+			oddMatch := (closer.canOpen || opener.canClose) &&
+				closer.origCount%3 != 0 &&
+				(opener.origCount+closer.origCount)%3 == 0
+			if opener.ch == closer.ch && opener.canOpen && !oddMatch {
+				found = true
+				break
+			}
+			opener = opener.previous
+		}
+
+		oldCloser := closer
+		if found {
+			use := 1
+			if closer.count >= 2 && opener.count >= 2 {
+				use = 2
+			}
+			openerNode, closerNode := opener.node, closer.node
+			opener.count -= use
+			closer.count -= use
+			openerNode.literal = openerNode.literal[:len(openerNode.literal)-use]
+			closerNode.literal = closerNode.literal[:len(closerNode.literal)-use]
+
+			emph := &mdNode{kind: kindEmphasis, line: openerNode.line}
+			if use == 2 {
+				emph.kind = kindStrong
+			}
+			for n := openerNode.nextSibling; n != nil && n != closerNode; {
+				next := n.nextSibling
+				emph.appendChild(n)
+				n = next
+			}
+			openerNode.insertAfter(emph)
+
+			// remove delimiters between opener and closer
+			if opener.next != closer {
+				opener.next = closer
+				closer.previous = opener
+			}
+
+			if opener.count == 0 {
+				openerNode.unlink()
+				p.removeDelimiter(opener)
+			}
+			if closer.count == 0 {
+				closerNode.unlink()
+				next := closer.next
+				p.removeDelimiter(closer)
+				closer = next
+			}
+		} else {
+			closer = closer.next
+			openersBottom[k] = oldCloser.previous
+			if !oldCloser.canOpen {
+				// can be neither opener nor closer anymore
+				p.removeDelimiter(oldCloser)
+			}
+		}
+	}
+
+	// remove all delimiters above stackBottom
+	for p.delimiters != nil && p.delimiters != stackBottom {
+		p.removeDelimiter(p.delimiters)
+	}
+}
+
+func (p *inlineParser) addBracket(n *mdNode, index int, image bool) {
+	if p.brackets != nil {
+		p.brackets.bracketAfter = true
+	}
+	p.brackets = &bracket{
+		node:              n,
+		previous:          p.brackets,
+		previousDelimiter: p.delimiters,
+		index:             index,
+		image:             image,
+		active:            true,
+	}
+}
+
+func (p *inlineParser) parseCloseBracket(block *mdNode) bool {
+	p.pos++
+	start := p.pos
+
+	opener := p.brackets
+	if opener == nil {
+		p.text(block, "]")
+		return true
+	}
+	if !opener.active {
+		p.text(block, "]")
+		p.brackets = opener.previous
+		return true
+	}
+
+	var (
+		matched     bool
+		destination string
+		title       string
+	)
+
+	// inline link
+	if p.peek() == '(' {
+		p.pos++
+		p.spnl()
+		if dest, ok := p.parseLinkDestination(); ok {
+			beforeTitle := p.pos
+			p.spnl()
+			if p.pos > beforeTitle {
+				// title must be separated by spacing
+				if t, ok := p.parseLinkTitle(); ok {
+					title = t
+				}
+			}
+			p.spnl()
+			if p.peek() == ')' {
+				p.pos++
+				destination = dest
+				matched = true
+			}
+		}
+		if !matched {
+			p.pos = start
+			title = ""
+		}
+	}
+
+	// reference link
+	if !matched {
+		beforeLabel := p.pos
+		n := p.parseLinkLabel()
+		var label string
+		if n > 2 {
+			label = p.subject[beforeLabel : beforeLabel+n]
+		} else if !opener.bracketAfter {
+			// collapsed or shortcut reference link
+			label = p.subject[opener.index:start]
+		}
+		if n == 0 {
+			// shortcut reference link, rewind
+			p.pos = start
+		}
+		if label != "" {
+			if ref, ok := p.refmap[normalizeLabel(label)]; ok {
+				destination = ref.destination
+				title = ref.title
+				matched = true
+			}
+		}
+	}
+
+	if !matched {
+		p.brackets = opener.previous
+		p.pos = start
+		p.text(block, "]")
+		return true
+	}
+
+	n := &mdNode{
+		kind:        kindLink,
+		line:        p.currentLine(),
+		destination: destination,
+		title:       title,
+	}
+	if opener.image {
+		n.kind = kindImage
+	}
+	for c := opener.node.nextSibling; c != nil; {
+		next := c.nextSibling
+		n.appendChild(c)
+		c = next
+	}
+	block.appendChild(n)
+	p.processEmphasis(opener.previousDelimiter)
+	p.brackets = opener.previous
+	opener.node.unlink()
+
+	if !opener.image {
+		// links cannot contain other links
+		for b := p.brackets; b != nil; b = b.previous {
+			if !b.image {
+				b.active = false
+			}
+		}
+	}
+	return true
+}
+
+// spnl skips spacing and at most one newline.
+func (p *inlineParser) spnl() {
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.pos++
+	}
+	if p.peek() == '\n' {
+		p.pos++
+	}
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.pos++
+	}
+}
+
+func (p *inlineParser) parseLinkDestination() (string, bool) {
+	if m := reLinkDestBraces.FindString(p.subject[p.pos:]); m != "" {
+		p.pos += len(m)
+		return unescapeString(m[1 : len(m)-1]), true
+	}
+	if p.peek() == '<' {
+		return "", false
+	}
+
+	start := p.pos
+	parens := 0
+loop:
+	for p.pos < len(p.subject) {
+		switch c := p.subject[p.pos]; {
+		case c == '\\' && p.pos+1 < len(p.subject) && isASCIIPunct(p.subject[p.pos+1]):
+			p.pos += 2
+		case c == '(':
+			parens++
+			p.pos++
+		case c == ')':
+			if parens < 1 {
+				break loop
+			}
+			parens--
+			p.pos++
+		case c <= ' ':
+			// spacing or control character
+			break loop
+		default:
+			p.pos++
+		}
+	}
+	if p.pos == start && p.peek() != ')' || parens != 0 {
+		p.pos = start
+		return "", false
+	}
+	return unescapeString(p.subject[start:p.pos]), true
+}
+
+func (p *inlineParser) parseLinkTitle() (string, bool) {
+	m := reLinkTitle.FindString(p.subject[p.pos:])
+	if m == "" {
+		return "", false
+	}
+	p.pos += len(m)
+	return unescapeString(m[1 : len(m)-1]), true
+}
+
+// parseLinkLabel returns the length of the link label at the current position
+// or 0 if there is none.
+func (p *inlineParser) parseLinkLabel() int {
+	m := reLinkLabel.FindString(p.subject[p.pos:])
+	if m == "" {
+		return 0
+	}
+	p.pos += len(m)
+	return len(m)
+}
+
+func (p *inlineParser) parseAutolink(block *mdNode) bool {
+	rest := p.subject[p.pos:]
+	if m := reEmailAutolink.FindStringSubmatch(rest); m != nil {
+		p.pos += len(m[0])
+		n := &mdNode{
+			kind:        kindLink,
+			line:        p.currentLine(),
+			destination: "mailto:" + m[1],
+		}
+		n.appendChild(&mdNode{kind: kindText, literal: m[1]})
+		block.appendChild(n)
+		return true
+	}
+	if m := reAutolink.FindStringSubmatch(rest); m != nil {
+		p.pos += len(m[0])
+		n := &mdNode{
+			kind:        kindLink,
+			line:        p.currentLine(),
+			destination: m[1],
+		}
+		n.appendChild(&mdNode{kind: kindText, literal: m[1]})
+		block.appendChild(n)
+		return true
+	}
+	return false
+}
+
+func (p *inlineParser) parseHTMLTag(block *mdNode) bool {
+	m := reHTMLTag.FindString(p.subject[p.pos:])
+	if m == "" {
+		return false
+	}
+	block.appendChild(&mdNode{
+		kind:    kindHTML,
+		line:    p.currentLine(),
+		literal: m,
+	})
+	p.pos += len(m)
+	return true
+}
+
+func (p *inlineParser) parseEntity(block *mdNode) bool {
+	m := reEntity.FindString(p.subject[p.pos:])
+	if m == "" {
+		return false
+	}
+	s, ok := decodeEntity(m)
+	if !ok {
+		return false
+	}
+	p.pos += len(m)
+	p.text(block, s)
+	return true
+}
+
+// parseString parses a run of characters that have no special meaning.
+func (p *inlineParser) parseString(block *mdNode) bool {
+	start := p.pos
+	for p.pos < len(p.subject) && !isSpecial(p.subject[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return false
+	}
+	p.text(block, p.subject[start:p.pos])
+	return true
+}
+
+func isSpecial(ch byte) bool {
+	switch ch {
+	case '\n', '\\', '`', '*', '_', '[', ']', '!', '<', '&':
+		return true
+	}
+	return false
+}
+
+// parseReferences parses the link reference definitions at the start of the
+// paragraph content, adds them to the refmap, and returns the rest of the
+// content.
+func parseReferences(content string, refmap map[string]linkReference) string {
+	for strings.HasPrefix(content, "[") {
+		p := &inlineParser{subject: content}
+		n := p.parseLinkLabel()
+		if n <= 2 || p.peek() != ':' {
+			break
+		}
+		label := content[:n]
+		if strings.TrimSpace(label[1:n-1]) == "" {
+			break
+		}
+		p.pos++ // ':'
+		p.spnl()
+		dest, ok := p.parseLinkDestination()
+		if !ok || dest == "" && !strings.HasPrefix(content[p.pos-2:], "<>") {
+			break
+		}
+
+		beforeTitle := p.pos
+		p.spnl()
+		var title string
+		hasTitle := false
+		if p.pos > beforeTitle {
+			if t, ok := p.parseLinkTitle(); ok {
+				title = t
+				hasTitle = true
+			}
+		}
+		if !hasTitle {
+			p.pos = beforeTitle
+		}
+
+		// the rest of the line must be blank
+		atLineEnd := func() bool {
+			for p.peek() == ' ' || p.peek() == '\t' {
+				p.pos++
+			}
+			if p.peek() == '\n' {
+				p.pos++
+				return true
+			}
+			return p.pos >= len(p.subject)
+		}
+		if !atLineEnd() {
+			if !hasTitle {
+				break
+			}
+			// the title is not followed by a line end, the
+			// definition might end before the title
+			title = ""
+			p.pos = beforeTitle
+			if !atLineEnd() {
+				break
+			}
+		}
+
+		key := normalizeLabel(label)
+		if _, ok := refmap[key]; !ok && key != "" {
+			// first definition takes precedence
+			refmap[key] = linkReference{
+				destination: dest,
+				title:       title,
+			}
+		}
+		content = content[p.pos:]
+	}
+	return content
+}
+
+// normalizeLabel normalizes the link label (including brackets) for matching.
+func normalizeLabel(label string) string {
+	label = strings.TrimSpace(label[1 : len(label)-1])
+	return strings.ToLower(strings.ToUpper(reWhitespace.ReplaceAllString(label, " ")))
+}
+
+// unescapeString processes backslash escapes and entities.
+func unescapeString(s string) string {
+	if !strings.ContainsAny(s, `\&`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+			b.WriteByte(s[i+1])
+			i++
+		case c == '&':
+			if m := reEntity.FindString(s[i:]); m != "" {
+				if d, ok := decodeEntity(m); ok {
+					b.WriteString(d)
+					i += len(m) - 1
+					continue
+				}
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// decodeEntity decodes the named or numeric character reference.
+func decodeEntity(s string) (string, bool) {
+	if strings.HasPrefix(s, "&#") {
+		var (
+			n   uint64
+			err error
+		)
+		if s[2] == 'x' || s[2] == 'X' {
+			n, err = strconv.ParseUint(s[3:len(s)-1], 16, 32)
+		} else {
+			n, err = strconv.ParseUint(s[2:len(s)-1], 10, 32)
+		}
+		r := rune(n)
+		if err != nil || n == 0 || !utf8.ValidRune(r) {
+			r = utf8.RuneError
+		}
+		return string(r), true
+	}
+	if d := html.UnescapeString(s); d != s {
+		return d, true
+	}
+	return "", false
+}
+
+// mergeText merges adjacent text nodes and removes empty ones.
+func mergeText(n *mdNode) {
+	for c := n.firstChild; c != nil; {
+		next := c.nextSibling
+		if c.kind == kindText {
+			for next != nil && next.kind == kindText {
+				c.literal += next.literal
+				nn := next.nextSibling
+				next.unlink()
+				next = nn
+			}
+			if c.literal == "" {
+				c.unlink()
+			}
+		} else {
+			mergeText(c)
+		}
+		c = next
+	}
+}
+
+// isASCIIPunct determines whether ch is an ASCII punctuation character.
+func isASCIIPunct(ch byte) bool {
+	return ch >= 0x21 && ch <= 0x2F ||
+		ch >= 0x3A && ch <= 0x40 ||
+		ch >= 0x5B && ch <= 0x60 ||
+		ch >= 0x7B && ch <= 0x7E
+}
+
+// isPunct determines whether r is a Unicode punctuation character as defined by
+// CommonMark (punctuation or symbol).
+func isPunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
@@ -0,0 +1,473 @@
+// Package markdown provides an importer that converts CommonMark formatted
+// text to Touch node trees.
+//
+// The node tree uses the element names of the default config and can be
+// printed as Touch formatted text by the printer package. Constructs that have
+// no Touch equivalent, such as thematic breaks or raw HTML, are reported as
+// warnings.
+package markdown
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/transformer/sticky"
+)
+
+// Element names used in the node tree; they match the default config.
+const (
+	ElementTitle            = "Title"
+	ElementHeading          = "Heading"
+	ElementBlockquote       = "Blockquote"
+	ElementList             = "List"
+	ElementListItem         = "ListItem"
+	ElementNumberedList     = "NumberedList"
+	ElementNumberedListItem = "NumberedListItem"
+	ElementCodeBlock        = "CodeBlock"
+	ElementImage            = "Image"
+	ElementAttributes       = "Attributes"
+	ElementStickyAttributes = "StickyAttributes"
+	ElementBlockComment     = "BlockComment"
+	ElementTextBlock        = "TextBlock"
+	ElementEmphasis         = "Emphasis"
+	ElementStrong           = "Strong"
+	ElementCode             = "Code"
+	ElementLink             = "Link"
+	ElementNamedLink        = "NamedLink"
+	ElementGroup            = "Group"
+	ElementLineBreak        = "LineBreak"
+	ElementComment          = "Comment"
+	ElementText             = "Text"
+)
+
+// Elements is the list of element names the node tree may contain.
+var Elements = []string{
+	ElementTitle,
+	ElementHeading,
+	ElementBlockquote,
+	ElementList,
+	ElementListItem,
+	ElementNumberedList,
+	ElementNumberedListItem,
+	ElementCodeBlock,
+	ElementImage,
+	ElementAttributes,
+	ElementStickyAttributes,
+	ElementBlockComment,
+	ElementTextBlock,
+	ElementEmphasis,
+	ElementStrong,
+	ElementCode,
+	ElementLink,
+	ElementNamedLink,
+	ElementGroup,
+	ElementLineBreak,
+	ElementComment,
+	ElementText,
+}
+
+// Warning reports a construct that has no Touch equivalent.
+type Warning struct {
+	Line    int    // line number (1-based)
+	Message string // what was found and how it was converted
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("line %d: %s", w.Line, w.Message)
+}
+
+// Import parses the CommonMark formatted text and returns the equivalent Touch
+// node tree and the warnings for the constructs that could not be converted
+// exactly.
+//
+// Lists and named links are returned as groups (as if the tree was transformed
+// by the group and sticky transformers).
+func Import(src []byte) (*node.Node, []Warning) {
+	c := &converter{}
+	root := c.convert(parse(src))
+	return root, c.warnings
+}
+
+type converter struct {
+	warnings []Warning
+}
+
+func (c *converter) warn(line int, format string, a ...interface{}) {
+	c.warnings = append(c.warnings, Warning{
+		Line:    line,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+func (c *converter) convert(doc *mdNode) *node.Node {
+	root := &node.Node{
+		Type: node.TypeContainer,
+	}
+	c.blocks(root, doc)
+	return root
+}
+
+// blocks appends the converted block children of m to n.
+func (c *converter) blocks(n *node.Node, m *mdNode) {
+	for b := m.firstChild; b != nil; b = b.nextSibling {
+		x := c.block(b)
+		if x == nil {
+			continue
+		}
+		if last := n.LastChild; last != nil && b.kind == kindList && last.Element == x.Element {
+			// Touch lists cannot be placed one after another,
+			// they would be parsed as a single list
+			c.warn(b.line, "list merged with the previous list")
+			for i := x.FirstChild; i != nil; i = x.FirstChild {
+				x.RemoveChild(i)
+				last.AppendChild(i)
+			}
+			continue
+		}
+		n.AppendChild(x)
+	}
+}
+
+// container returns a container with the converted block children of m.
+func (c *converter) container(m *mdNode) *node.Node {
+	n := &node.Node{
+		Type: node.TypeContainer,
+	}
+	c.blocks(n, m)
+	return n
+}
+
+func (c *converter) block(m *mdNode) *node.Node {
+	switch m.kind {
+	case kindParagraph:
+		if img := m.firstChild; img != nil && img.kind == kindImage && img.nextSibling == nil {
+			// a standalone image
+			if img.title != "" {
+				c.warn(m.line, "image title %q dropped", img.title)
+			}
+			n := &node.Node{
+				Element: ElementImage,
+				Type:    node.TypeVerbatimLine,
+			}
+			n.AppendChild(text(" " + img.destination))
+			if alt := strings.Join(strings.Fields(img.textContent()), " "); alt != "" {
+				return altImage(n, alt)
+			}
+			return n
+		}
+		return c.textBlock(m)
+	case kindHeading:
+		if m.level == 1 {
+			n := &node.Node{
+				Element: ElementTitle,
+				Type:    node.TypeHanging,
+			}
+			n.AppendChild(container(c.textBlock(m)))
+			return n
+		}
+		n := &node.Node{
+			Element: ElementHeading,
+			Type:    node.TypeRankedHanging,
+			Data: node.Data{
+				parser.KeyRank: m.level,
+			},
+		}
+		n.AppendChild(container(c.textBlock(m)))
+		return n
+	case kindThematicBreak:
+		c.warn(m.line, "thematic break dropped")
+		return nil
+	case kindBlockquote:
+		n := &node.Node{
+			Element: ElementBlockquote,
+			Type:    node.TypeWalled,
+		}
+		n.AppendChild(c.container(m))
+		return n
+	case kindList:
+		list := &node.Node{
+			Element: ElementList,
+			Type:    node.TypeContainer,
+		}
+		item := ElementListItem
+		if m.list.ordered {
+			list.Element = ElementNumberedList
+			item = ElementNumberedListItem
+			if m.list.start != 1 {
+				c.warn(m.line, "list start number %d dropped", m.list.start)
+			}
+		}
+		for i := m.firstChild; i != nil; i = i.nextSibling {
+			n := &node.Node{
+				Element: item,
+				Type:    node.TypeHanging,
+			}
+			n.AppendChild(c.container(i))
+			list.AppendChild(n)
+		}
+		return list
+	case kindCodeBlock:
+		n := &node.Node{
+			Element: ElementCodeBlock,
+			Type:    node.TypeFenced,
+			Data: node.Data{
+				parser.KeyOpeningText: m.info,
+			},
+		}
+		if content := strings.TrimSuffix(m.content, "\n"); content != "" {
+			n.AppendChild(text(content))
+		}
+		return n
+	case kindHTMLBlock:
+		c.warn(m.line, "raw HTML converted to a comment")
+		lines := strings.Split(strings.TrimRight(m.content, "\n"), "\n")
+		for i, line := range lines {
+			if line != "" {
+				lines[i] = " " + line
+			}
+		}
+		n := &node.Node{
+			Element: ElementBlockComment,
+			Type:    node.TypeVerbatimWalled,
+		}
+		n.AppendChild(text(strings.Join(lines, "\n")))
+		return n
+	}
+	panic(fmt.Sprintf("markdown: unexpected block kind %d", m.kind))
+}
+
+// textBlock returns a text block with the converted inline children of m.
+func (c *converter) textBlock(m *mdNode) *node.Node {
+	n := &node.Node{
+		Element: ElementTextBlock,
+		Type:    node.TypeLeaf,
+	}
+	n.AppendChild(c.inlines(m))
+	return n
+}
+
+// inlines returns a container with the converted inline children of m. Text
+// and soft line breaks are merged into single text nodes.
+func (c *converter) inlines(m *mdNode) *node.Node {
+	n := &node.Node{
+		Type: node.TypeContainer,
+	}
+	var b strings.Builder
+	flush := func() {
+		if b.Len() > 0 {
+			n.AppendChild(text(b.String()))
+			b.Reset()
+		}
+	}
+	for x := m.firstChild; x != nil; x = x.nextSibling {
+		switch x.kind {
+		case kindText:
+			b.WriteString(x.literal)
+		case kindSoftBreak:
+			b.WriteString("\n")
+		case kindHardBreak:
+			flush()
+			n.AppendChild(&node.Node{
+				Element: ElementLineBreak,
+				Type:    node.TypePrefixed,
+			})
+			b.WriteString("\n")
+		default:
+			if name := inlineName(x.kind); name != "" {
+				// the printer separates inline elements from
+				// the adjacent words
+				if spaceBefore(x) {
+					c.warn(x.line, "space inserted before %s", name)
+				}
+				if spaceAfter(x) {
+					c.warn(x.line, "space inserted after %s", name)
+				}
+			}
+			flush()
+			n.AppendChild(c.inline(x))
+		}
+	}
+	flush()
+	return n
+}
+
+// inlineName returns the name of the inline kind used in warnings or "" if
+// the spacing around it is not reported.
+func inlineName(k kind) string {
+	switch k {
+	case kindCode:
+		return "code span"
+	case kindEmphasis:
+		return "emphasis"
+	case kindStrong:
+		return "strong emphasis"
+	case kindLink:
+		return "link"
+	case kindImage:
+		return "image"
+	}
+	return ""
+}
+
+// spaceBefore reports whether a space is printed between the inline m and the
+// word before it.
+func spaceBefore(m *mdNode) bool {
+	prev := m.previousSibling
+	if prev == nil || prev.kind == kindSoftBreak || prev.kind == kindHardBreak {
+		return false
+	}
+	if prev.kind == kindText {
+		r, _ := utf8.DecodeLastRuneInString(prev.literal)
+		return prev.literal != "" && !unicode.IsSpace(r)
+	}
+	return true
+}
+
+// spaceAfter reports whether a space is printed between the inline m and the
+// text after it. Punctuation is not separated.
+func spaceAfter(m *mdNode) bool {
+	next := m.nextSibling
+	if next == nil || next.kind != kindText || next.literal == "" {
+		return false
+	}
+	word := next.literal
+	if i := strings.IndexFunc(word, unicode.IsSpace); i >= 0 {
+		word = word[:i]
+	}
+	if word == "" {
+		return false
+	}
+	for _, r := range word {
+		if !unicode.IsPunct(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *converter) inline(m *mdNode) *node.Node {
+	switch m.kind {
+	case kindCode:
+		n := &node.Node{
+			Element: ElementCode,
+			Type:    node.TypeEscaped,
+		}
+		n.AppendChild(text(m.literal))
+		return n
+	case kindEmphasis, kindStrong:
+		n := &node.Node{
+			Element: ElementEmphasis,
+			Type:    node.TypeUniform,
+		}
+		if m.kind == kindStrong {
+			n.Element = ElementStrong
+		}
+		n.AppendChild(c.inlines(m))
+		return n
+	case kindLink:
+		if m.title != "" {
+			c.warn(m.line, "link title %q dropped", m.title)
+		}
+		return c.link(m, m.destination)
+	case kindImage:
+		c.warn(m.line, "inline image converted to a link")
+		if m.title != "" {
+			c.warn(m.line, "image title %q dropped", m.title)
+		}
+		return c.link(m, m.destination)
+	case kindHTML:
+		c.warn(m.line, "raw HTML converted to a comment")
+		n := &node.Node{
+			Element: ElementComment,
+			Type:    node.TypeEscaped,
+		}
+		n.AppendChild(text(m.literal))
+		return n
+	}
+	panic(fmt.Sprintf("markdown: unexpected inline kind %d", m.kind))
+}
+
+// link returns a link to the destination. If the link text differs from the
+// destination, a named link is returned.
+func (c *converter) link(m *mdNode, destination string) *node.Node {
+	l := &node.Node{
+		Element: ElementLink,
+		Type:    node.TypeEscaped,
+	}
+	l.AppendChild(text(destination))
+
+	if isPlain(m) && m.textContent() == destination {
+		return l
+	}
+	if m.firstChild == nil {
+		return l
+	}
+
+	group := &node.Node{
+		Element: ElementGroup,
+		Type:    node.TypeUniform,
+	}
+	group.AppendChild(c.inlines(m))
+	n := &node.Node{
+		Element: ElementNamedLink,
+		Type:    node.TypeContainer,
+		Data: node.Data{
+			sticky.Key: "before",
+		},
+	}
+	n.AppendChild(group)
+	n.AppendChild(l)
+	return n
+}
+
+// altImage returns the image with the alt text given by attributes.
+func altImage(img *node.Node, alt string) *node.Node {
+	attrs := &node.Node{
+		Element: ElementAttributes,
+		Type:    node.TypeVerbatimWalled,
+	}
+	alt = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(alt)
+	attrs.AppendChild(text(`alt="` + alt + `"`))
+	n := &node.Node{
+		Element: ElementStickyAttributes,
+		Type:    node.TypeContainer,
+		Data: node.Data{
+			sticky.Key: "before",
+		},
+	}
+	n.AppendChild(attrs)
+	n.AppendChild(img)
+	return n
+}
+
+// isPlain reports whether m contains only text.
+func isPlain(m *mdNode) bool {
+	for c := m.firstChild; c != nil; c = c.nextSibling {
+		if c.kind != kindText {
+			return false
+		}
+	}
+	return true
+}
+
+func container(children ...*node.Node) *node.Node {
+	n := &node.Node{
+		Type: node.TypeContainer,
+	}
+	for _, c := range children {
+		n.AppendChild(c)
+	}
+	return n
+}
+
+func text(s string) *node.Node {
+	return &node.Node{
+		Element: ElementText,
+		Type:    node.TypeText,
+		Value:   s,
+	}
+}
@@ -0,0 +1,217 @@
+package markdown_test
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/importer/markdown"
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/printer"
+	"github.com/touchmarine/to/render"
+	"github.com/touchmarine/to/transformer"
+	"github.com/touchmarine/to/transformer/group"
+	"github.com/touchmarine/to/transformer/sticky"
+)
+
+const testdata = "testdata"
+
+// use go test -update to create/update the golden files
+var update = flag.Bool("update", false, "update golden files")
+
+func TestGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join(testdata, "*.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, in := range inputs {
+		basePath := in[:len(in)-len(".md")]
+
+		t.Run(basePath[len(testdata)+1:], func(t *testing.T) {
+			runTest(t, basePath)
+		})
+	}
+}
+
+func runTest(t *testing.T, testPath string) {
+	src, err := os.ReadFile(testPath + ".md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, warnings := markdown.Import(src)
+	res := print(t, root)
+
+	var wb strings.Builder
+	for _, w := range warnings {
+		wb.WriteString(w.String() + "\n")
+	}
+
+	compare(t, testPath+".golden", res)
+	compare(t, testPath+".warnings", wb.String())
+	compareHTML(t, testPath+".html", res)
+
+	// the imported text is in its canonical form
+	p := parser.Parser{
+		Elements: elements(),
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+	}
+	n, err := p.Parse(nil, []byte(res))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n = transformer.Group{
+		group.Transformer{group.Map{
+			"List":         "ListItem",
+			"NumberedList": "NumberedListItem",
+		}},
+		sticky.Transformer{sticky.Map{
+			"NamedLink":        {Element: "Group", Target: "Link"},
+			"StickyAttributes": {Element: "Attributes"},
+		}},
+	}.Transform(n)
+	if got := print(t, n); got != res {
+		t.Errorf("reformatted:\n%s\nwant:\n%s", got, res)
+	}
+}
+
+func compare(t *testing.T, goldenPath, res string) {
+	t.Helper()
+
+	if *update {
+		if err := os.WriteFile(goldenPath, []byte(res), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bg, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if golden := string(bg); res != golden {
+		t.Errorf("%s:\ngot:\n%s\nwant:\n%s", goldenPath, res, golden)
+	}
+}
+
+// compareHTML compares the HTML built from the imported text by the default
+// config with the HTML in htmlPath, written by hand as CommonMark renders the
+// Markdown, except for the constructs reported in warnings. The -update flag
+// does not change it.
+func compareHTML(t *testing.T, htmlPath, res string) {
+	t.Helper()
+
+	r, err := render.New(&config.Default, render.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := r.Render(context.Background(), []byte(res), "html", &b); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := os.ReadFile(htmlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := normalizeHTML(b.String()), normalizeHTML(string(want)); got != want {
+		t.Errorf("%s:\ngot:\n%s\nwant:\n%s", htmlPath, got, want)
+	}
+}
+
+var (
+	reHTMLToken     = regexp.MustCompile(`<!--[\s\S]*?-->|<[^>]*>|[^<]+`)
+	reHTMLTagName   = regexp.MustCompile(`^</?([a-z0-9]+)`)
+	reHTMLAttribute = regexp.MustCompile(` (?:id="[^"]*"|[a-z-]+="")`)
+	reHTMLSpace     = regexp.MustCompile(`\s+`)
+)
+
+// normalizeHTML removes the differences that do not change the meaning of the
+// HTML and those between the Touch and CommonMark paragraph rules:
+//   - comments, the document wrapper, spans, and paragraph tags are dropped
+//     (paragraphs are kept apart by a newline),
+//   - heading ids and empty attributes are dropped,
+//   - character references in tags are decoded,
+//   - spacing is collapsed and removed around block tags and line breaks,
+//   - a trailing newline in preformatted text is dropped.
+func normalizeHTML(s string) string {
+	var (
+		b      []byte
+		pre    bool   // in preformatted text
+		block  = true // at a block boundary
+		inline bool   // b ends with inline content
+	)
+	for _, tok := range reHTMLToken.FindAllString(s, -1) {
+		if strings.HasPrefix(tok, "<!--") {
+			continue
+		}
+		if tok[0] != '<' {
+			if !pre {
+				tok = reHTMLSpace.ReplaceAllString(tok, " ")
+			}
+			if block {
+				tok = strings.TrimLeft(tok, " ")
+			}
+			if tok == "" {
+				continue
+			}
+			if block && inline {
+				b = append(b, '\n')
+			}
+			block, inline = false, true
+			b = append(b, tok...)
+			continue
+		}
+
+		name := reHTMLTagName.FindStringSubmatch(tok)[1]
+		tok = reHTMLAttribute.ReplaceAllString(tok, "")
+		tok = html.UnescapeString(tok) // &quot; and &#34; are the same
+		tok = strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(tok, ">"), "/"), " ") + ">"
+		switch name {
+		case "span":
+		case "a", "em", "strong", "code":
+			if pre && tok[1] == '/' {
+				b = bytes.TrimSuffix(b, []byte("\n"))
+			}
+			if block && inline {
+				b = append(b, '\n')
+			}
+			block, inline = false, true
+			b = append(b, tok...)
+		default:
+			if name == "pre" {
+				pre = tok[1] != '/'
+			}
+			b = bytes.TrimRight(b, " ")
+			block = true
+			if name != "html" && name != "body" && name != "p" {
+				inline = false
+				b = append(b, tok...)
+			}
+		}
+	}
+	return string(b)
+}
+
+func elements() parser.Elements {
+	return config.Default.Elements.ParserElements()
+}
+
+func print(t *testing.T, root *node.Node) string {
+	t.Helper()
+	var b strings.Builder
+	if err := (printer.Printer{Elements: elements()}).Fprint(&b, root); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
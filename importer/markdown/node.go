@@ -0,0 +1,121 @@
+package markdown
+
+type kind int
+
+const (
+	// blocks
+	kindDocument kind = iota
+	kindBlockquote
+	kindList
+	kindItem
+	kindParagraph
+	kindHeading
+	kindThematicBreak
+	kindCodeBlock
+	kindHTMLBlock
+
+	// inlines
+	kindText
+	kindSoftBreak
+	kindHardBreak
+	kindCode
+	kindEmphasis
+	kindStrong
+	kindLink
+	kindImage
+	kindHTML
+)
+
+// mdNode is a node of the Markdown syntax tree. It is used for both blocks and
+// inlines.
+type mdNode struct {
+	kind kind
+	line int // line number (1-based)
+
+	parent          *mdNode
+	firstChild      *mdNode
+	lastChild       *mdNode
+	previousSibling *mdNode
+	nextSibling     *mdNode
+
+	open    bool   // block is open
+	content string // raw block content (paragraphs, headings, code and HTML blocks)
+	literal string // inline content (text, code spans, raw HTML)
+
+	level       int      // heading level
+	list        listData // lists and list items
+	fenced      bool     // code block is fenced
+	fenceChar   byte
+	fenceLength int
+	fenceOffset int
+	info        string // code block info string
+	htmlType    int    // HTML block start condition
+	destination string // link or image destination
+	title       string // link or image title
+}
+
+func (n *mdNode) appendChild(c *mdNode) {
+	c.unlink()
+	c.parent = n
+	if n.lastChild != nil {
+		n.lastChild.nextSibling = c
+		c.previousSibling = n.lastChild
+	} else {
+		n.firstChild = c
+	}
+	n.lastChild = c
+}
+
+func (n *mdNode) insertAfter(s *mdNode) {
+	s.unlink()
+	s.nextSibling = n.nextSibling
+	if s.nextSibling != nil {
+		s.nextSibling.previousSibling = s
+	}
+	s.previousSibling = n
+	n.nextSibling = s
+	s.parent = n.parent
+	if s.nextSibling == nil && s.parent != nil {
+		s.parent.lastChild = s
+	}
+}
+
+func (n *mdNode) unlink() {
+	if n.previousSibling != nil {
+		n.previousSibling.nextSibling = n.nextSibling
+	} else if n.parent != nil {
+		n.parent.firstChild = n.nextSibling
+	}
+	if n.nextSibling != nil {
+		n.nextSibling.previousSibling = n.previousSibling
+	} else if n.parent != nil {
+		n.parent.lastChild = n.previousSibling
+	}
+	n.parent = nil
+	n.nextSibling = nil
+	n.previousSibling = nil
+}
+
+// textContent returns the concatenated literals of n and its descendants.
+func (n *mdNode) textContent() string {
+	var s string
+	switch n.kind {
+	case kindText, kindCode, kindHTML:
+		s = n.literal
+	case kindSoftBreak, kindHardBreak:
+		s = "\n"
+	}
+	for c := n.firstChild; c != nil; c = c.nextSibling {
+		s += c.textContent()
+	}
+	return s
+}
+
+func walk(n *mdNode, fn func(n *mdNode)) {
+	fn(n)
+	for c := n.firstChild; c != nil; {
+		next := c.nextSibling // fn may unlink c
+		walk(c, fn)
+		c = next
+	}
+}
@@ -0,0 +1,216 @@
+// Package diff computes line-based differences and prints them in the unified
+// format.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// context is the number of unchanged lines printed around changes.
+const context = 3
+
+type op byte
+
+const (
+	opEqual  op = ' '
+	opDelete op = '-'
+	opInsert op = '+'
+)
+
+type edit struct {
+	op   op
+	line []byte // including the trailing newline, if any
+}
+
+// Unified returns the unified diff between old and new. The names are used in
+// the file headers. It returns nil if old and new are equal.
+func Unified(oldName, newName string, old, new []byte) []byte {
+	if bytes.Equal(old, new) {
+		return nil
+	}
+
+	edits := lineEdits(splitLines(old), splitLines(new))
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+
+	// line numbers (0-based) at the current edit
+	var oldLine, newLine int
+	for i := 0; i < len(edits); {
+		if edits[i].op == opEqual {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+
+		// hunk start including the leading context
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		oldStart := oldLine - (i - start)
+		newStart := newLine - (i - start)
+
+		// hunk end: extend while changes are within 2*context lines
+		end := i
+		for end < len(edits) {
+			if edits[end].op != opEqual {
+				end++
+				continue
+			}
+			j := end
+			for j < len(edits) && edits[j].op == opEqual {
+				j++
+			}
+			if j == len(edits) || j-end > 2*context {
+				// trailing context
+				end += min(context, j-end)
+				break
+			}
+			end = j
+		}
+
+		var oldCount, newCount int
+		for _, e := range edits[start:end] {
+			if e.op != opInsert {
+				oldCount++
+			}
+			if e.op != opDelete {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, e := range edits[start:end] {
+			b.WriteByte(byte(e.op))
+			b.Write(e.line)
+			if !bytes.HasSuffix(e.line, []byte("\n")) {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		// advance line numbers to end
+		for _, e := range edits[i:end] {
+			if e.op != opInsert {
+				oldLine++
+			}
+			if e.op != opDelete {
+				newLine++
+			}
+		}
+		i = end
+	}
+	return b.Bytes()
+}
+
+// hunkRange formats the 0-based start line and line count as a unified hunk
+// range.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		// empty ranges refer to the line before
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLines splits b after each newline.
+func splitLines(b []byte) [][]byte {
+	var lines [][]byte
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			lines = append(lines, b)
+			break
+		}
+		lines = append(lines, b[:i+1])
+		b = b[i+1:]
+	}
+	return lines
+}
+
+// lineEdits returns the shortest edit script that turns a into b using the
+// Myers difference algorithm.
+func lineEdits(a, b [][]byte) []edit {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+2)
+	var trace [][]int
+
+search:
+	for d := 0; d <= max; d++ {
+		vc := make([]int, len(v))
+		copy(vc, v)
+		trace = append(trace, vc)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1] // down (insertion)
+			} else {
+				x = v[offset+k-1] + 1 // right (deletion)
+			}
+			y := x - k
+			for x < n && y < m && bytes.Equal(a[x], b[y]) {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// backtrack
+	var edits []edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, edit{opEqual, a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				edits = append(edits, edit{opInsert, b[y]})
+			} else {
+				x--
+				edits = append(edits, edit{opDelete, a[x]})
+			}
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		edits = append(edits, edit{opEqual, a[x]})
+	}
+
+	// reverse
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
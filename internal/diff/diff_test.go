@@ -0,0 +1,135 @@
+package diff_test
+
+import (
+	"testing"
+
+	"github.com/touchmarine/to/internal/diff"
+)
+
+func TestUnified(t *testing.T) {
+	cases := []struct {
+		name string
+		old  string
+		new  string
+		out  string
+	}{
+		{
+			"equal",
+			"a\nb\n",
+			"a\nb\n",
+			"",
+		},
+		{
+			"change",
+			"a\nb\nc\n",
+			"a\nx\nc\n",
+			`--- old
++++ new
+@@ -1,3 +1,3 @@
+ a
+-b
++x
+ c
+`,
+		},
+		{
+			"insert into empty",
+			"",
+			"a\n",
+			`--- old
++++ new
+@@ -0,0 +1 @@
++a
+`,
+		},
+		{
+			"delete all",
+			"a\nb\n",
+			"",
+			`--- old
++++ new
+@@ -1,2 +0,0 @@
+-a
+-b
+`,
+		},
+		{
+			"no newline at end",
+			"a\nb",
+			"a\nb\n",
+			`--- old
++++ new
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++b
+`,
+		},
+		{
+			"context",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			"1\n2\n3\n4\nx\n6\n7\n8\n9\n",
+			`--- old
++++ new
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++x
+ 6
+ 7
+ 8
+`,
+		},
+		{
+			"separate hunks",
+			"a\n1\n2\n3\n4\n5\n6\n7\nb\n",
+			"x\n1\n2\n3\n4\n5\n6\n7\ny\n",
+			`--- old
++++ new
+@@ -1,4 +1,4 @@
+-a
++x
+ 1
+ 2
+ 3
+@@ -6,4 +6,4 @@
+ 5
+ 6
+ 7
+-b
++y
+`,
+		},
+		{
+			"joined hunks",
+			"a\n1\n2\n3\n4\n5\n6\nb\n",
+			"x\n1\n2\n3\n4\n5\n6\ny\n",
+			`--- old
++++ new
+@@ -1,8 +1,8 @@
+-a
++x
+ 1
+ 2
+ 3
+ 4
+ 5
+ 6
+-b
++y
+`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := string(diff.Unified("old", "new", []byte(c.old), []byte(c.new)))
+			if got != c.out {
+				t.Errorf("got:\n%s\nwant:\n%s", got, c.out)
+			}
+		})
+	}
+}
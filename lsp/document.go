@@ -0,0 +1,218 @@
+package lsp
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+)
+
+// document is an open text document.
+type document struct {
+	uri     DocumentURI
+	version int
+	src     []byte
+	lines   []int // line offsets (index=line)
+
+	root *node.Node   // parsed, untransformed node tree
+	err  error        // parse error
+	edit *parser.Edit // changes since root was parsed merged into one edit
+}
+
+func newDocument(uri DocumentURI, version int, text string) *document {
+	d := &document{
+		uri:     uri,
+		version: version,
+	}
+	d.setText([]byte(text))
+	return d
+}
+
+func (d *document) setText(src []byte) {
+	d.setSource(src)
+	d.root = nil
+	d.err = nil
+	d.edit = nil
+}
+
+func (d *document) setSource(src []byte) {
+	d.src = src
+	d.lines = []int{0}
+	for i, b := range src {
+		if b == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+}
+
+// applyChange applies a content change. A change without a range replaces the
+// whole document.
+func (d *document) applyChange(c textDocumentContentChangeEvent, encoding string) {
+	if c.Range == nil {
+		d.setText([]byte(c.Text))
+		return
+	}
+	start := d.offset(c.Range.Start, encoding)
+	end := d.offset(c.Range.End, encoding)
+	if end < start {
+		start, end = end, start
+	}
+	var b bytes.Buffer
+	b.Grow(len(d.src) - (end - start) + len(c.Text))
+	b.Write(d.src[:start])
+	b.WriteString(c.Text)
+	b.Write(d.src[end:])
+	if d.root == nil {
+		d.setText(b.Bytes())
+		return
+	}
+	d.addEdit(start, end, b.Bytes())
+	d.setSource(b.Bytes())
+}
+
+// addEdit merges the change of the bytes from start to end of the current
+// source, resulting in src, into the edit since the last parse.
+func (d *document) addEdit(start, end int, src []byte) {
+	first, last := start, end // changed bytes of the current source
+	delta := 0                // length difference of the parsed and current source
+	if e := d.edit; e != nil {
+		delta = len(e.Text) - (e.End - e.Start)
+		if e.Start < first {
+			first = e.Start
+		}
+		if x := e.Start + len(e.Text); x > last {
+			last = x
+		}
+	}
+	n := len(src) - len(d.src) // length difference of the current and new source
+	d.edit = &parser.Edit{
+		Start: first,
+		End:   last - delta,
+		Text:  string(src[first : last+n]),
+	}
+}
+
+// offset converts a LSP position to a byte offset. Positions past the end of a
+// line or the document are clamped.
+func (d *document) offset(pos Position, encoding string) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lines) {
+		return len(d.src)
+	}
+	start := d.lines[pos.Line]
+	end := len(d.src)
+	if pos.Line+1 < len(d.lines) {
+		end = d.lines[pos.Line+1] - 1 // exclude '\n'
+	}
+	if encoding == PositionEncodingUTF8 {
+		if o := start + pos.Character; o < end {
+			return o
+		}
+		return end
+	}
+
+	n := 0 // UTF-16 code units
+	o := start
+	for o < end && n < pos.Character {
+		r, w := utf8.DecodeRune(d.src[o:])
+		n += utf16Len(r)
+		o += w
+	}
+	return o
+}
+
+// position converts a byte offset to a LSP position.
+func (d *document) position(offs int, encoding string) Position {
+	if offs < 0 {
+		offs = 0
+	}
+	if offs > len(d.src) {
+		offs = len(d.src)
+	}
+	ln := d.line(offs)
+	start := d.lines[ln]
+	if encoding == PositionEncodingUTF8 {
+		return Position{Line: ln, Character: offs - start}
+	}
+
+	n := 0
+	for o := start; o < offs; {
+		r, w := utf8.DecodeRune(d.src[o:])
+		n += utf16Len(r)
+		o += w
+	}
+	return Position{Line: ln, Character: n}
+}
+
+// line returns the line that contains the given offset.
+func (d *document) line(offs int) int {
+	lo, hi := 0, len(d.lines)
+	for hi-lo > 1 {
+		m := (lo + hi) / 2
+		if d.lines[m] <= offs {
+			lo = m
+		} else {
+			hi = m
+		}
+	}
+	return lo
+}
+
+func (d *document) lspRange(r node.Range, encoding string) Range {
+	return Range{
+		Start: d.position(r.Start.Offset, encoding),
+		End:   d.position(r.End.Offset, encoding),
+	}
+}
+
+// parse parses the document if it has not been parsed since the last change,
+// re-parsing only the changed blocks if it was parsed before.
+func (d *document) parse(p parser.Parser) (*node.Node, error) {
+	p.URI = node.DocumentURI(d.uri)
+	if d.root == nil {
+		d.root, d.err = p.Parse(nil, d.src)
+	} else if d.edit != nil {
+		d.root, d.err = p.Reparse(d.root, d.src, *d.edit)
+	}
+	d.edit = nil
+	return d.root, d.err
+}
+
+// clone returns a deep copy of the node tree n. The data maps are copied too
+// as transformers set data.
+func clone(n *node.Node) *node.Node {
+	c := &node.Node{
+		Element:  n.Element,
+		Type:     n.Type,
+		Value:    n.Value,
+		Start:    n.Start,
+		End:      n.End,
+		Location: n.Location,
+	}
+	if n.Data != nil {
+		c.Data = make(node.Data, len(n.Data))
+		for k, v := range n.Data {
+			c.Data[k] = v
+		}
+	}
+	if n.Trivia != nil {
+		c.Trivia = append([]string(nil), n.Trivia...)
+	}
+	for x := n.FirstChild; x != nil; x = x.NextSibling {
+		c.AppendChild(clone(x))
+	}
+	return c
+}
+
+// utf16Len returns the number of UTF-16 code units needed to encode r.
+// Invalid encodings count as a single replacement character.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		// surrogate pair
+		return 2
+	}
+	return 1
+}
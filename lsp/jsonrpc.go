@@ -0,0 +1,73 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// readMessage reads a single message framed by the LSP base protocol headers.
+// It returns io.EOF if the reader is exhausted before a message starts.
+//
+// https://microsoft.github.io/language-server-protocol/specifications/specification-3-17/#baseProtocol
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for i := 0; ; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if i == 0 && err == io.EOF && line == "" {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("read header: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			// end of header
+			break
+		}
+		name, value, ok := cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header: %q", line)
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid Content-Length: %q", value)
+			}
+			length = n
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("read content: %w", err)
+	}
+	return b, nil
+}
+
+// writeMessage writes v as a JSON message framed by the LSP base protocol
+// headers.
+func writeMessage(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(b)); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// cut is strings.Cut which is not available in Go 1.16.
+func cut(s, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
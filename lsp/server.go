@@ -0,0 +1,556 @@
+// Package lsp implements a Language Server Protocol server for Touch formatted
+// text.
+//
+// The server communicates over a single stream using JSON-RPC (usually stdio)
+// and provides:
+// - diagnostics (parser errors)
+// - document symbols (ranked hanging elements, e.g. headings)
+// - formatting (canonical form as printed by the printer package)
+// - folding ranges (walled, hanging, and fenced elements)
+// - hover (element name and type)
+// - semantic tokens (delimiters, escapes, verbatim content, and comments)
+//
+// https://microsoft.github.io/language-server-protocol/specifications/specification-3-17/
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/printer"
+	"github.com/touchmarine/to/transformer"
+)
+
+// ErrExitWithoutShutdown is returned by Serve if the client sent the exit
+// notification without requesting a shutdown first.
+var ErrExitWithoutShutdown = errors.New("lsp: exit without shutdown")
+
+// Server serves the Language Server Protocol. Its behaviour depends on the
+// values in this struct.
+type Server struct {
+	Name        string                  // server name reported to the client
+	Version     string                  // server version reported to the client
+	Config      *config.Config          // element set
+	Matchers    matcher.Map             // available matchers (by name)
+	Transformer transformer.Transformer // applied before formatting and checking (optional)
+	TabWidth    int                     // tab=<tabwidth> x spaces
+	LineLength  int                     // line length to wrap text at when formatting
+
+	w           io.Writer
+	elements    parser.Elements
+	encoding    string // negotiated position encoding
+	initialized bool
+	shutdown    bool
+	documents   map[DocumentURI]*document
+}
+
+// Serve reads messages from r and writes responses and notifications to w. It
+// returns when the client sends the exit notification or when r is exhausted.
+//
+// Messages are handled sequentially in the order they are received.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.w = w
+	s.documents = map[DocumentURI]*document{}
+	s.encoding = PositionEncodingUTF16
+	if s.Config != nil {
+		s.elements = s.Config.Elements.ParserElements()
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		b, err := readMessage(br)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(b, &req); err != nil {
+			if err := s.reply(nil, nil, &responseError{codeParseError, err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return ErrExitWithoutShutdown
+			}
+			return nil
+		}
+
+		result, rerr := s.handle(req)
+		if req.ID == nil {
+			// notifications are not answered
+			continue
+		}
+		if err := s.reply(req.ID, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, rerr *responseError) error {
+	resp := response{
+		JSONRPC: "2.0",
+		ID:      id,
+	}
+	if rerr != nil {
+		resp.Error = rerr
+	} else {
+		b, err := json.Marshal(result)
+		if err != nil {
+			resp.Error = &responseError{codeInternalError, err.Error()}
+		} else {
+			resp.Result = b
+		}
+	}
+	return writeMessage(s.w, resp)
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	return writeMessage(s.w, notification{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  params,
+	})
+}
+
+// handle handles the request and returns its result. Panics, e.g. caused by an
+// invalid config, are reported as internal errors.
+func (s *Server) handle(req request) (result interface{}, rerr *responseError) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = nil
+			rerr = &responseError{codeInternalError, fmt.Sprint(r)}
+		}
+	}()
+
+	if !s.initialized && req.Method != "initialize" {
+		return nil, &responseError{codeServerNotInitialized, "server not initialized"}
+	}
+
+	switch req.Method {
+	case "initialize":
+		var params initializeParams
+		if err := unmarshalParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.initialize(params), nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenTextDocumentParams
+		if err := unmarshalParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		td := params.TextDocument
+		d := newDocument(td.URI, td.Version, td.Text)
+		s.documents[td.URI] = d
+		return nil, s.publishDiagnostics(d)
+	case "textDocument/didChange":
+		var params didChangeTextDocumentParams
+		if err := unmarshalParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		d, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range params.ContentChanges {
+			d.applyChange(c, s.encoding)
+		}
+		d.version = params.TextDocument.Version
+		return nil, s.publishDiagnostics(d)
+	case "textDocument/didClose":
+		var params didCloseTextDocumentParams
+		if err := unmarshalParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.documents, params.TextDocument.URI)
+		// clear diagnostics
+		if err := s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []diagnostic{},
+		}); err != nil {
+			return nil, &responseError{codeInternalError, err.Error()}
+		}
+		return nil, nil
+	case "textDocument/didSave":
+		return nil, nil
+	case "textDocument/documentSymbol":
+		d, err := s.documentFromParams(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return s.documentSymbols(d), nil
+	case "textDocument/formatting":
+		d, err := s.documentFromParams(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return s.formatting(d)
+	case "textDocument/foldingRange":
+		d, err := s.documentFromParams(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return s.foldingRanges(d), nil
+	case "textDocument/semanticTokens/full":
+		d, err := s.documentFromParams(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return s.semanticTokens(d), nil
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := unmarshalParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		d, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return s.hover(d, params.Position), nil
+	}
+
+	if strings.HasPrefix(req.Method, "$/") {
+		// optional notifications and requests may be ignored
+		return nil, nil
+	}
+	return nil, &responseError{codeMethodNotFound, fmt.Sprintf("method not found: %s", req.Method)}
+}
+
+func unmarshalParams(raw json.RawMessage, v interface{}) *responseError {
+	if len(raw) == 0 {
+		return &responseError{codeInvalidParams, "missing params"}
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &responseError{codeInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) document(uri DocumentURI) (*document, *responseError) {
+	d, ok := s.documents[uri]
+	if !ok {
+		return nil, &responseError{codeInvalidParams, fmt.Sprintf("document not open: %s", uri)}
+	}
+	return d, nil
+}
+
+func (s *Server) documentFromParams(raw json.RawMessage) (*document, *responseError) {
+	var params documentParams
+	if err := unmarshalParams(raw, &params); err != nil {
+		return nil, err
+	}
+	return s.document(params.TextDocument.URI)
+}
+
+func (s *Server) initialize(params initializeParams) initializeResult {
+	s.initialized = true
+	for _, e := range params.Capabilities.General.PositionEncodings {
+		if e == PositionEncodingUTF8 {
+			// prefer byte offsets, they are native to the parser
+			s.encoding = PositionEncodingUTF8
+			break
+		}
+	}
+	return initializeResult{
+		Capabilities: serverCapabilities{
+			PositionEncoding:           s.encoding,
+			TextDocumentSync:           syncIncremental,
+			DocumentSymbolProvider:     true,
+			DocumentFormattingProvider: true,
+			FoldingRangeProvider:       true,
+			HoverProvider:              true,
+			SemanticTokensProvider: semanticTokensOptions{
+				Legend: semanticTokensLegend{
+					TokenTypes:     semanticTokenTypes,
+					TokenModifiers: []string{},
+				},
+				Full: true,
+			},
+		},
+		ServerInfo: serverInfo{
+			Name:    s.Name,
+			Version: s.Version,
+		},
+	}
+}
+
+func (s *Server) parser() parser.Parser {
+	return parser.Parser{
+		Elements: s.elements,
+		Matchers: s.Matchers,
+		TabWidth: s.TabWidth,
+	}
+}
+
+func (s *Server) publishDiagnostics(d *document) *responseError {
+	root, err := d.parse(s.parser())
+	if c, ok := s.Transformer.(transformer.Checker); ok && err == nil {
+		// transformers mutate the tree, so check a copy of the one kept
+		// for reparsing
+		if list := c.Check(s.Transformer.Transform(clone(root))); len(list) > 0 {
+			err = list
+		}
+	}
+	diagnostics := []diagnostic{}
+	if list, ok := err.(parser.ErrorList); ok {
+		for _, e := range list {
+			diagnostics = append(diagnostics, diagnostic{
+				Range:    d.lspRange(e.Location.Range, s.encoding),
+				Severity: int(e.Severity),
+				Code:     string(e.Code),
+				Source:   "to",
+				Message:  e.Message,
+			})
+		}
+	} else if err != nil {
+		diagnostics = append(diagnostics, diagnostic{
+			Severity: int(parser.SeverityError),
+			Source:   "to",
+			Message:  err.Error(),
+		})
+	}
+	if err := s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         d.uri,
+		Version:     d.version,
+		Diagnostics: diagnostics,
+	}); err != nil {
+		return &responseError{codeInternalError, err.Error()}
+	}
+	return nil
+}
+
+// documentSymbols returns the ranked hanging elements (e.g. headings) nested by
+// their ranks.
+func (s *Server) documentSymbols(d *document) []documentSymbol {
+	root, _ := d.parse(s.parser())
+
+	type symbol struct {
+		documentSymbol
+		rank     int
+		children []*symbol
+	}
+	top := &symbol{}
+	stack := []*symbol{top}
+	walk(root, func(n *node.Node) bool {
+		if n.Type != node.TypeRankedHanging {
+			return true
+		}
+		rank, _ := n.Data[parser.KeyRank].(int)
+		name := strings.TrimSpace(strings.SplitN(n.TextContent(), "\n", 2)[0])
+		if name == "" {
+			name = n.Element
+		}
+		r := d.lspRange(n.Location.Range, s.encoding)
+		sym := &symbol{
+			documentSymbol: documentSymbol{
+				Name:           name,
+				Kind:           symbolKindString,
+				Range:          r,
+				SelectionRange: r,
+			},
+			rank: rank,
+		}
+		for len(stack) > 1 && stack[len(stack)-1].rank >= rank {
+			stack = stack[:len(stack)-1]
+		}
+		parent := stack[len(stack)-1]
+		parent.children = append(parent.children, sym)
+		stack = append(stack, sym)
+		return true
+	})
+
+	var convert func(syms []*symbol) []documentSymbol
+	convert = func(syms []*symbol) []documentSymbol {
+		x := make([]documentSymbol, len(syms))
+		for i, sym := range syms {
+			x[i] = sym.documentSymbol
+			if len(sym.children) > 0 {
+				x[i].Children = convert(sym.children)
+			}
+		}
+		return x
+	}
+	return convert(top.children)
+}
+
+// formatting returns the edits that bring the document into its canonical
+// form. Documents with errors are not formatted.
+func (s *Server) formatting(d *document) (interface{}, *responseError) {
+	root, err := d.parse(s.parser())
+	if err != nil {
+		return nil, nil
+	}
+	if s.Transformer != nil {
+		// transformers mutate the tree
+		root = s.Transformer.Transform(clone(root))
+	}
+	var b bytes.Buffer
+	p := printer.Printer{
+		Elements:   s.elements,
+		LineLength: s.LineLength,
+	}
+	if err := p.FprintDocument(&b, root); err != nil {
+		return nil, &responseError{codeInternalError, err.Error()}
+	}
+	if bytes.Equal(b.Bytes(), d.src) {
+		return []textEdit{}, nil
+	}
+	return []textEdit{
+		{
+			Range: Range{
+				Start: Position{},
+				End:   d.position(len(d.src), s.encoding),
+			},
+			NewText: b.String(),
+		},
+	}, nil
+}
+
+// foldingRanges returns the multi-line walled, hanging, fenced, and table
+// elements.
+func (s *Server) foldingRanges(d *document) []foldingRange {
+	root, _ := d.parse(s.parser())
+	ranges := []foldingRange{}
+	walk(root, func(n *node.Node) bool {
+		switch n.Type {
+		case node.TypeWalled, node.TypeVerbatimWalled, node.TypeHanging,
+			node.TypeRankedHanging, node.TypeFenced, node.TypeTable:
+			start := d.position(n.Start, s.encoding)
+			end := d.position(n.End, s.encoding)
+			if end.Character == 0 && end.Line > start.Line {
+				// ends at the start of a line, don't fold it
+				end.Line--
+			}
+			if end.Line > start.Line {
+				ranges = append(ranges, foldingRange{
+					StartLine: start.Line,
+					EndLine:   end.Line,
+				})
+			}
+		}
+		return true
+	})
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].StartLine < ranges[j].StartLine
+	})
+	return ranges
+}
+
+// hover returns the name and type of the innermost element at the given
+// position. Text elements are skipped in favour of their parents.
+func (s *Server) hover(d *document, pos Position) interface{} {
+	root, _ := d.parse(s.parser())
+	offs := d.offset(pos, s.encoding)
+
+	var found *node.Node
+	walk(root, func(n *node.Node) bool {
+		if n.Type == node.TypeContainer {
+			return true
+		}
+		if offs < n.Start || offs >= n.End {
+			return false
+		}
+		if n.Element != "" && n.Type != node.TypeText {
+			found = n
+		}
+		return true
+	})
+	if found == nil {
+		return nil
+	}
+
+	typ := found.Type.String()
+	var delimiter string
+	if s.Config != nil {
+		if e, ok := s.Config.Elements[found.Element]; ok {
+			typ = e.Type
+			delimiter = e.Delimiter
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%s**\n\ntype: %s", found.Element, codeSpan(typ))
+	if delimiter != "" {
+		fmt.Fprintf(&b, "\n\ndelimiter: %s", codeSpan(delimiter))
+	}
+	r := d.lspRange(found.Location.Range, s.encoding)
+	return hover{
+		Contents: markupContent{
+			Kind:  "markdown",
+			Value: b.String(),
+		},
+		Range: &r,
+	}
+}
+
+// semanticTokenTypes is the legend of the semantic token types.
+var semanticTokenTypes = []string{"operator", "regexp", "string", "comment", "parameter"}
+
+// semanticTokenType maps token kinds to indexes in the legend. Text tokens are
+// left to the client's grammar.
+var semanticTokenType = map[parser.TokenKind]int{
+	parser.TokenDelimiter:   0,
+	parser.TokenEscape:      1,
+	parser.TokenVerbatim:    2,
+	parser.TokenComment:     3,
+	parser.TokenOpeningText: 4,
+}
+
+// semanticTokens returns the tokens of the document, except text, encoded
+// relative to the previous token as required by the protocol.
+func (s *Server) semanticTokens(d *document) semanticTokens {
+	data := []int{}
+	var prev Position
+	for _, tok := range s.parser().Tokens(d.src) {
+		typ, ok := semanticTokenType[tok.Kind]
+		if !ok {
+			continue
+		}
+		start := d.position(tok.Start, s.encoding)
+		end := d.position(tok.End, s.encoding)
+		char := start.Character
+		if start.Line == prev.Line {
+			char -= prev.Character
+		}
+		data = append(data, start.Line-prev.Line, char, end.Character-start.Character, typ, 0)
+		prev = start
+	}
+	return semanticTokens{Data: data}
+}
+
+// codeSpan returns s as a Markdown code span.
+func codeSpan(s string) string {
+	if strings.Contains(s, "`") {
+		return "`` " + s + " ``"
+	}
+	return "`" + s + "`"
+}
+
+func walk(n *node.Node, fn func(n *node.Node) bool) {
+	if n == nil {
+		return
+	}
+	if fn(n) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, fn)
+		}
+	}
+}
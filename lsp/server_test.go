@@ -0,0 +1,421 @@
+package lsp_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/lsp"
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+)
+
+var elements = config.Elements{
+	"Heading": {
+		Type:      "rankedHanging",
+		Delimiter: "=",
+	},
+	"Blockquote": {
+		Type:      "walled",
+		Delimiter: ">",
+	},
+	"CodeBlock": {
+		Type:      "fenced",
+		Delimiter: "`",
+	},
+	"Emphasis": {
+		Type:      "uniform",
+		Delimiter: "_",
+	},
+	"TextBlock": {
+		Type: "leaf",
+	},
+	"Text": {
+		Type: "text",
+	},
+}
+
+// session runs the server with the given messages (an exit notification is
+// appended) and returns the messages the server sent.
+func session(t *testing.T, messages ...string) []map[string]interface{} {
+	t.Helper()
+	return serve(t, testServer(), messages...)
+}
+
+func testServer() lsp.Server {
+	return lsp.Server{
+		Config:   &config.Config{Elements: elements},
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+	}
+}
+
+// serve is like session but runs the given server.
+func serve(t *testing.T, s lsp.Server, messages ...string) []map[string]interface{} {
+	t.Helper()
+	msgs := serveAll(t, s, messages...)
+	// remove initialize and shutdown responses
+	if len(msgs) < 2 {
+		t.Fatalf("got %d messages, want at least 2", len(msgs))
+	}
+	return msgs[1 : len(msgs)-1]
+}
+
+// serveAll is like serve but also returns the initialize and shutdown
+// responses.
+func serveAll(t *testing.T, s lsp.Server, messages ...string) []map[string]interface{} {
+	t.Helper()
+
+	var in bytes.Buffer
+	init := `{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"capabilities":{}}}`
+	for _, m := range append(append([]string{init}, messages...),
+		`{"jsonrpc":"2.0","id":-1,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	) {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+
+	var out bytes.Buffer
+	if err := s.Serve(&in, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs []map[string]interface{}
+	r := bufio.NewReader(&out)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.ReadString('\n'); err != nil { // blank line
+			t.Fatal(err)
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			t.Fatal(err)
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+func didOpen(text string) string {
+	b, err := json.Marshal(text)
+	if err != nil {
+		panic(err)
+	}
+	return `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a.to","version":1,"text":` + string(b) + `}}}`
+}
+
+func request(id int, method, params string) string {
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":%q,"params":%s}`, id, method, params)
+}
+
+func jsonString(t *testing.T, v interface{}) string {
+	t.Helper()
+	var b strings.Builder
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func TestDiagnostics(t *testing.T) {
+	msgs := session(t, didOpen("a\x00b"))
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(msgs))
+	}
+	got := jsonString(t, msgs[0]["params"])
+	want := `{"diagnostics":[{"code":"illegalNULL","message":"illegal character NULL","range":{"end":{"character":2,"line":0},"start":{"character":1,"line":0}},"severity":1,"source":"to"}],"uri":"file:///a.to","version":1}`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// checker reports a warning for each leaf.
+type checker struct{}
+
+func (checker) Transform(n *node.Node) *node.Node {
+	return n
+}
+
+func (checker) Check(n *node.Node) parser.ErrorList {
+	var list parser.ErrorList
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == node.TypeLeaf {
+			list.Add(&parser.Error{
+				Location: c.Location,
+				Code:     "leaf",
+				Severity: parser.SeverityWarning,
+				Message:  "leaf",
+			})
+		}
+	}
+	return list
+}
+
+func TestDiagnosticsCheck(t *testing.T) {
+	msgs := serve(t, lsp.Server{
+		Config:      &config.Config{Elements: elements},
+		Matchers:    matcher.Defaults(),
+		Transformer: checker{},
+		TabWidth:    8,
+	}, didOpen("a"))
+	got := jsonString(t, msgs[0]["params"])
+	want := `{"diagnostics":[{"code":"leaf","message":"leaf","range":{"end":{"character":1,"line":0},"start":{"character":0,"line":0}},"severity":2,"source":"to"}],"uri":"file:///a.to","version":1}`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestDiagnosticsUTF16(t *testing.T) {
+	// 𝄞 is encoded as a surrogate pair in UTF-16
+	msgs := session(t, didOpen("𝄞\x00"))
+	got := jsonString(t, msgs[0]["params"].(map[string]interface{})["diagnostics"].([]interface{})[0].(map[string]interface{})["range"])
+	want := `{"end":{"character":3,"line":0},"start":{"character":2,"line":0}}`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestDocumentSymbol(t *testing.T) {
+	msgs := session(t,
+		didOpen("==a\n===b\n==c"),
+		request(1, "textDocument/documentSymbol", `{"textDocument":{"uri":"file:///a.to"}}`),
+	)
+	var names []string
+	var walk func(v interface{}, depth int)
+	walk = func(v interface{}, depth int) {
+		for _, x := range v.([]interface{}) {
+			sym := x.(map[string]interface{})
+			names = append(names, strings.Repeat(">", depth)+sym["name"].(string))
+			if c, ok := sym["children"]; ok {
+				walk(c, depth+1)
+			}
+		}
+	}
+	walk(msgs[1]["result"], 0)
+	if got, want := strings.Join(names, " "), "a >b c"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{">a\n>b", `[{"newText":"> a\n> b\n","range":{"end":{"character":2,"line":1},"start":{"character":0,"line":0}}}]`},
+		{"> a\n> b", `[{"newText":"> a\n> b\n","range":{"end":{"character":3,"line":1},"start":{"character":0,"line":0}}}]`},
+		{"> a\n> b\n", `[]`},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%q", c.in), func(t *testing.T) {
+			msgs := session(t,
+				didOpen(c.in),
+				request(1, "textDocument/formatting", `{"textDocument":{"uri":"file:///a.to"},"options":{"tabSize":8,"insertSpaces":false}}`),
+			)
+			if got := jsonString(t, msgs[1]["result"]); got != c.out {
+				t.Errorf("got %s, want %s", got, c.out)
+			}
+		})
+	}
+}
+
+func TestFoldingRange(t *testing.T) {
+	msgs := session(t,
+		didOpen(">a\n>b\n\n`\nc\n`\n\n>d"),
+		request(1, "textDocument/foldingRange", `{"textDocument":{"uri":"file:///a.to"}}`),
+	)
+	got := jsonString(t, msgs[1]["result"])
+	want := `[{"endLine":1,"startLine":0},{"endLine":5,"startLine":3}]`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestSemanticTokens(t *testing.T) {
+	msgs := session(t,
+		didOpen("> a\n`go\nb\n`\n__ä__"),
+		request(1, "textDocument/semanticTokens/full", `{"textDocument":{"uri":"file:///a.to"}}`),
+	)
+	got := jsonString(t, msgs[1]["result"])
+	want := `{"data":[0,0,1,0,0,1,0,1,0,0,0,1,2,4,0,1,0,1,2,0,1,0,1,0,0,1,0,2,0,0,0,3,2,0,0]}`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestHover(t *testing.T) {
+	msgs := session(t,
+		didOpen("> a __b__"),
+		request(1, "textDocument/hover", `{"textDocument":{"uri":"file:///a.to"},"position":{"line":0,"character":6}}`),
+		request(2, "textDocument/hover", `{"textDocument":{"uri":"file:///a.to"},"position":{"line":0,"character":2}}`),
+	)
+	cases := []string{
+		"**Emphasis**\n\ntype: `uniform`\n\ndelimiter: `_`",
+		"**TextBlock**\n\ntype: `leaf`",
+	}
+	for i, want := range cases {
+		h, ok := msgs[i+1]["result"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("%d: got %v, want hover", i, msgs[i+1])
+		}
+		got := h["contents"].(map[string]interface{})["value"]
+		if got != want {
+			t.Errorf("%d: got %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestMethodNotFound(t *testing.T) {
+	msgs := session(t, request(1, "textDocument/unknown", `{}`))
+	e, ok := msgs[0]["error"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("got %v, want error", msgs[0])
+	}
+	if code := e["code"].(float64); code != -32601 {
+		t.Errorf("got code %v, want -32601", code)
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	m := `{"jsonrpc":"2.0","method":"exit"}`
+	in := fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(m), m)
+	s := lsp.Server{Config: &config.Config{Elements: elements}}
+	if err := s.Serve(strings.NewReader(in), io.Discard); err != lsp.ErrExitWithoutShutdown {
+		t.Errorf("got %v, want %v", err, lsp.ErrExitWithoutShutdown)
+	}
+}
+
+func TestDidChange(t *testing.T) {
+	// clients send ranged changes only if the server asks for incremental
+	// sync
+	init := serveAll(t, testServer())
+	capabilities := init[0]["result"].(map[string]interface{})["capabilities"].(map[string]interface{})
+	if got := capabilities["textDocumentSync"]; got != float64(2) {
+		t.Errorf("got textDocumentSync %v, want 2 (incremental)", got)
+	}
+
+	msgs := session(t,
+		didOpen("==a\n==b\n==c"),
+		`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a.to","version":2},"contentChanges":[`+
+			`{"range":{"start":{"line":1,"character":2},"end":{"line":1,"character":3}},"text":"x\n==y"},`+
+			`{"range":{"start":{"line":0,"character":3},"end":{"line":0,"character":3}},"text":"z\u0000"}]}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a.to","version":3},"contentChanges":[`+
+			`{"range":{"start":{"line":0,"character":4},"end":{"line":0,"character":5}},"text":""}]}}`,
+		request(1, "textDocument/documentSymbol", `{"textDocument":{"uri":"file:///a.to"}}`),
+	)
+	if len(msgs) != 4 {
+		t.Fatalf("got %d messages, want 4", len(msgs))
+	}
+	if got := len(msgs[1]["params"].(map[string]interface{})["diagnostics"].([]interface{})); got != 1 {
+		t.Errorf("got %d diagnostics after the first change, want 1", got)
+	}
+	var names []string
+	for _, x := range msgs[3]["result"].([]interface{}) {
+		names = append(names, x.(map[string]interface{})["name"].(string))
+	}
+	if got, want := strings.Join(names, " "), "az x y c"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// marker sets data on each leaf and reports a warning for each leaf marked
+// before.
+type marker struct{}
+
+func (marker) Transform(n *node.Node) *node.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == node.TypeLeaf {
+			if c.Data == nil {
+				c.Data = node.Data{}
+			}
+			marks, _ := c.Data["marks"].(int)
+			c.Data["marks"] = marks + 1
+		}
+	}
+	return n
+}
+
+func (marker) Check(n *node.Node) parser.ErrorList {
+	var list parser.ErrorList
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if marks, _ := c.Data["marks"].(int); marks > 1 {
+			list.Add(&parser.Error{
+				Location: c.Location,
+				Severity: parser.SeverityWarning,
+				Message:  fmt.Sprintf("marked %d times", marks),
+			})
+		}
+	}
+	return list
+}
+
+func TestDidChangeCheck(t *testing.T) {
+	// the checks run on the incrementally parsed tree, so the blocks
+	// before the edit are parsed only once
+	parsed := map[byte]int{} // by the first byte of the matched text
+	els := config.Elements{
+		"URL": {
+			Type:      "prefixed",
+			Delimiter: "http://",
+			Matcher:   "count",
+		},
+	}
+	for k, v := range elements {
+		els[k] = v
+	}
+	msgs := serve(t, lsp.Server{
+		Config: &config.Config{Elements: els},
+		Matchers: matcher.Map{
+			"count": matcher.MatcherFunc(func(p []byte) int {
+				if len(p) > 0 {
+					parsed[p[0]]++
+				}
+				return 1
+			}),
+		},
+		Transformer: marker{},
+		TabWidth:    8,
+	},
+		didOpen("http://a\n\nhttp://b\n\nhttp://c"),
+		`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a.to","version":2},"contentChanges":[`+
+			`{"range":{"start":{"line":4,"character":7},"end":{"line":4,"character":8}},"text":"d"}]}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a.to","version":3},"contentChanges":[`+
+			`{"range":{"start":{"line":4,"character":7},"end":{"line":4,"character":8}},"text":"e"}]}}`,
+	)
+	if len(msgs) != 3 {
+		t.Fatalf("got %d messages, want 3", len(msgs))
+	}
+	for _, m := range msgs {
+		if got := jsonString(t, m["params"].(map[string]interface{})["diagnostics"]); got != "[]" {
+			t.Errorf("got diagnostics %s, want none", got)
+		}
+	}
+	for _, b := range []byte("acde") {
+		// the restart block b may be matched more than once
+		if got := parsed[b]; got != 1 {
+			t.Errorf("got %q parsed %d times, want once", b, got)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package node
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Source returns the source of the node reproduced from the trivia of the node
+// and its descendants. For a tree parsed in CST mode, the source of the root is
+// the whole parsed source, byte for byte.
+//
+// Tools can edit the source surgically by changing the trivia of only the
+// nodes they modify (e.g. the text of a link) and reproducing the rest as it
+// was. It returns an error if a node has no trivia or its trivia does not fit
+// its children, e.g. if it was added by a transformer.
+func Source(n *Node) (string, error) {
+	var b strings.Builder
+	if err := writeSource(&b, n); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func writeSource(b *strings.Builder, n *Node) error {
+	if len(n.Trivia) != countChildren(n)+1 {
+		return fmt.Errorf("node: %s has %d trivia for %d children", n, len(n.Trivia), countChildren(n))
+	}
+	i := 0
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(n.Trivia[i])
+		if err := writeSource(b, c); err != nil {
+			return err
+		}
+		i++
+	}
+	b.WriteString(n.Trivia[i])
+	return nil
+}
+
+func countChildren(n *Node) int {
+	var i int
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,192 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// JSONVersion is the version of the JSON encoding of node trees written by
+// EncodeJSON. It is incremented on incompatible changes; DecodeJSON accepts
+// only this version.
+const JSONVersion = 1
+
+type jsonTree struct {
+	Version int       `json:"version"`
+	Root    *jsonNode `json:"root"`
+}
+
+type jsonNode struct {
+	Element  string       `json:"element,omitempty"`
+	Type     string       `json:"type"`
+	Data     Data         `json:"data,omitempty"`
+	Value    string       `json:"value,omitempty"`
+	Start    int          `json:"start"`
+	End      int          `json:"end"`
+	Location jsonLocation `json:"location"`
+	Trivia   []string     `json:"trivia,omitempty"`
+	Children []*jsonNode  `json:"children,omitempty"`
+}
+
+type jsonLocation struct {
+	URI   DocumentURI `json:"uri,omitempty"`
+	Range struct {
+		Start jsonPosition `json:"start"`
+		End   jsonPosition `json:"end"`
+	} `json:"range"`
+}
+
+type jsonPosition struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// EncodeJSON writes the JSON encoding of the node tree to the writer. The tree
+// is encoded as an object holding the encoding version and the root node:
+// 	{"version": 1, "root": <node>}
+//
+// where a node is encoded as:
+// 	{
+// 		"element": "Heading",    // omitted if blank
+// 		"type": "RankedHanging", // Type.String()
+// 		"data": {"rank": 2},     // omitted if empty
+// 		"value": "text",         // omitted if blank
+// 		"start": 0,
+// 		"end": 10,
+// 		"location": {
+// 			"uri": "file:///a.to", // omitted if blank
+// 			"range": {
+// 				"start": {"offset": 0, "line": 0, "column": 0},
+// 				"end": {"offset": 10, "line": 0, "column": 10}
+// 			}
+// 		},
+// 		"trivia": ["**", "**"],   // omitted if none (see Node.Trivia)
+// 		"children": [<node>, ...] // omitted if none
+// 	}
+func EncodeJSON(w io.Writer, n *Node) error {
+	return encodeJSON(w, n, "")
+}
+
+// IndentJSON is like EncodeJSON but indents the output with the given indent.
+func IndentJSON(w io.Writer, n *Node, indent string) error {
+	return encodeJSON(w, n, indent)
+}
+
+func encodeJSON(w io.Writer, n *Node, indent string) error {
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+	e.SetIndent("", indent)
+	return e.Encode(jsonTree{
+		Version: JSONVersion,
+		Root:    toJSON(n),
+	})
+}
+
+func toJSON(n *Node) *jsonNode {
+	j := &jsonNode{
+		Element: n.Element,
+		Type:    n.Type.String(),
+		Data:    n.Data,
+		Value:   n.Value,
+		Start:   n.Start,
+		End:     n.End,
+		Trivia:  n.Trivia,
+	}
+	j.Location.URI = n.Location.URI
+	j.Location.Range.Start = jsonPosition(n.Location.Range.Start)
+	j.Location.Range.End = jsonPosition(n.Location.Range.End)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		j.Children = append(j.Children, toJSON(c))
+	}
+	return j
+}
+
+// DecodeJSON reads a node tree encoded by EncodeJSON from the reader.
+//
+// Whole numbers in Data are decoded as ints, other numbers as float64s.
+func DecodeJSON(r io.Reader) (*Node, error) {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	var t jsonTree
+	if err := d.Decode(&t); err != nil {
+		return nil, fmt.Errorf("node: decode JSON: %w", err)
+	}
+	if t.Version != JSONVersion {
+		return nil, fmt.Errorf("node: unsupported JSON version %d (want %d)", t.Version, JSONVersion)
+	}
+	if t.Root == nil {
+		return nil, fmt.Errorf("node: decode JSON: missing root")
+	}
+	return fromJSON(t.Root)
+}
+
+func fromJSON(j *jsonNode) (*Node, error) {
+	t, ok := typesByName[j.Type]
+	if !ok {
+		return nil, fmt.Errorf("node: decode JSON: unknown type %q", j.Type)
+	}
+	if j.Value != "" && len(j.Children) > 0 {
+		return nil, fmt.Errorf("node: decode JSON: %s(%s) has value and children", j.Type, j.Element)
+	}
+	n := &Node{
+		Element: j.Element,
+		Type:    t,
+		Value:   j.Value,
+		Start:   j.Start,
+		End:     j.End,
+		Trivia:  j.Trivia,
+		Location: Location{
+			URI: j.Location.URI,
+			Range: Range{
+				Start: Position(j.Location.Range.Start),
+				End:   Position(j.Location.Range.End),
+			},
+		},
+	}
+	if len(j.Data) > 0 {
+		n.Data = Data{}
+		for k, v := range j.Data {
+			n.Data[k] = fromJSONValue(v)
+		}
+	}
+	for _, jc := range j.Children {
+		c, err := fromJSON(jc)
+		if err != nil {
+			return nil, err
+		}
+		n.AppendChild(c)
+	}
+	return n, nil
+}
+
+// fromJSONValue converts json.Numbers to ints or float64s.
+func fromJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, x := range v {
+			v[k] = fromJSONValue(x)
+		}
+	case []interface{}:
+		for i, x := range v {
+			v[i] = fromJSONValue(x)
+		}
+	}
+	return v
+}
+
+// typesByName maps the names of all types, including the special ones, to
+// types.
+var typesByName = func() map[string]Type {
+	m := map[string]Type{}
+	for t := TypeError; t <= TypeTable; t++ {
+		m[t.String()] = t
+	}
+	return m
+}()
@@ -0,0 +1,137 @@
+package node_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/node"
+)
+
+func TestEncodeJSON(t *testing.T) {
+	root := &node.Node{
+		Type:  node.TypeContainer,
+		Start: 0,
+		End:   4,
+		Location: node.Location{
+			URI: "file:///a.to",
+			Range: node.Range{
+				End: node.Position{Offset: 4, Column: 4},
+			},
+		},
+	}
+	root.AppendChild(&node.Node{
+		Element: "Heading",
+		Type:    node.TypeRankedHanging,
+		Data:    node.Data{"rank": 2},
+		Start:   0,
+		End:     4,
+		Location: node.Location{
+			Range: node.Range{
+				End: node.Position{Offset: 4, Column: 4},
+			},
+		},
+	})
+	root.FirstChild.AppendChild(&node.Node{
+		Element: "Text",
+		Type:    node.TypeText,
+		Value:   "<a>",
+		Start:   2,
+		End:     4,
+		Location: node.Location{
+			Range: node.Range{
+				Start: node.Position{Offset: 2, Column: 2},
+				End:   node.Position{Offset: 4, Column: 4},
+			},
+		},
+	})
+
+	var b bytes.Buffer
+	if err := node.EncodeJSON(&b, root); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"version":1,"root":{"type":"Container","start":0,"end":4,` +
+		`"location":{"uri":"file:///a.to","range":{"start":{"offset":0,"line":0,"column":0},"end":{"offset":4,"line":0,"column":4}}},` +
+		`"children":[{"element":"Heading","type":"RankedHanging","data":{"rank":2},"start":0,"end":4,` +
+		`"location":{"range":{"start":{"offset":0,"line":0,"column":0},"end":{"offset":4,"line":0,"column":4}}},` +
+		`"children":[{"element":"Text","type":"Text","value":"<a>","start":2,"end":4,` +
+		`"location":{"range":{"start":{"offset":2,"line":0,"column":2},"end":{"offset":4,"line":0,"column":4}}}}]}]}}` + "\n"
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	decoded, err := node.DecodeJSON(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := printTree(t, decoded), printTree(t, root); got != want {
+		t.Errorf("decoded\n%s\nwant\n%s", got, want)
+	}
+	if _, ok := decoded.FirstChild.Data["rank"].(int); !ok {
+		t.Errorf("got rank %T, want int", decoded.FirstChild.Data["rank"])
+	}
+	if decoded.FirstChild.Parent != decoded || decoded.LastChild != decoded.FirstChild {
+		t.Error("decoded tree not linked")
+	}
+}
+
+func TestJSONTrivia(t *testing.T) {
+	root := &node.Node{
+		Type:   node.TypeContainer,
+		Trivia: []string{"**", "**\n"},
+	}
+	root.AppendChild(&node.Node{
+		Element: "Text",
+		Type:    node.TypeText,
+		Value:   "a",
+		Trivia:  []string{"a"},
+	})
+
+	var b bytes.Buffer
+	if err := node.EncodeJSON(&b, root); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := node.DecodeJSON(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := node.Source(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "**a**\n"; src != want {
+		t.Errorf("got source %q, want %q", src, want)
+	}
+}
+
+func TestDecodeJSONError(t *testing.T) {
+	cases := []struct {
+		in  string
+		err string
+	}{
+		{`{"version":2,"root":{"type":"Container"}}`, "unsupported JSON version 2 (want 1)"},
+		{`{"root":{"type":"Container"}}`, "unsupported JSON version 0 (want 1)"},
+		{`{"version":1}`, "missing root"},
+		{`{"version":1,"root":{"type":"Block"}}`, `unknown type "Block"`},
+		{`{"version":1,"root":{"type":"Text","value":"a","children":[{"type":"Text"}]}}`, "has value and children"},
+		{`{"version":1,"root":`, "unexpected EOF"},
+	}
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			_, err := node.DecodeJSON(strings.NewReader(c.in))
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("got error %v, want %q", err, c.err)
+			}
+		})
+	}
+}
+
+func printTree(t *testing.T, n *node.Node) string {
+	t.Helper()
+	var b strings.Builder
+	m := node.PrintData | node.PrintOffsets | node.PrintLocation
+	if err := (node.Printer{m}).Fprint(&b, n); err != nil {
+		t.Fatal(err)
+	}
+	return b.String() + string(n.Location.URI)
+}
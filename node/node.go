@@ -16,6 +16,13 @@ type Node struct {
 
 	Value string // text node's content
 
+	// Trivia holds the source bytes of the node not covered by its
+	// children—delimiters, leads, spacing, blank lines, and escapes. It is
+	// recorded only by parsers in CST mode (see Source): Trivia[i] precedes
+	// the i-th child and the last element follows the last child, so a
+	// node without children has a single element, its whole source.
+	Trivia []string
+
 	// relationships
 	Parent          *Node
 	FirstChild      *Node
@@ -27,6 +27,9 @@ const (
 	TypeEscaped  // Escaped
 	TypePrefixed // Prefixed
 	TypeText     // Text
+
+	// blocks added later (after the other types to keep their values)
+	TypeTable // Table
 )
 
 // UnmarshalText implements the encoding.TextUnmarshaler interface. It is
@@ -51,6 +54,7 @@ var validTypes = map[string]Type{
 	strings.ToLower(TypeRankedHanging.String()):  TypeRankedHanging,
 	strings.ToLower(TypeFenced.String()):         TypeFenced,
 	strings.ToLower(TypeVerbatimLine.String()):   TypeVerbatimLine,
+	strings.ToLower(TypeTable.String()):          TypeTable,
 	strings.ToLower(TypeLeaf.String()):           TypeLeaf,
 
 	strings.ToLower(TypeUniform.String()):  TypeUniform,
@@ -64,7 +68,7 @@ var validTypes = map[string]Type{
 // Note that a non-block type is not necessarily an inline type:
 // 	!IsBlock() != IsInline()
 func IsBlock(t Type) bool {
-	return t >= TypeWalled && t <= TypeLeaf
+	return t >= TypeWalled && t <= TypeLeaf || t == TypeTable
 }
 
 // IsInline reports whether the given type is a member of the inline type set.
@@ -72,12 +76,12 @@ func IsBlock(t Type) bool {
 // Note that a non-inline type is not necessarily a block type:
 // 	!IsInline() != IsBlock()
 func IsInline(t Type) bool {
-	return t >= TypeUniform
+	return t >= TypeUniform && t <= TypeText
 }
 
 // HasDelimiter reports whether the given type has a delimiter.
 func HasDelimiter(t Type) bool {
 	return t == TypeWalled || t == TypeVerbatimWalled || t == TypeHanging ||
 		t == TypeRankedHanging || t == TypeFenced || t == TypeVerbatimLine ||
-		t == TypeUniform || t == TypeEscaped || t == TypePrefixed
+		t == TypeTable || t == TypeUniform || t == TypeEscaped || t == TypePrefixed
 }
@@ -21,11 +21,12 @@ func _() {
 	_ = x[TypeEscaped-10]
 	_ = x[TypePrefixed-11]
 	_ = x[TypeText-12]
+	_ = x[TypeTable-13]
 }
 
-const _Type_name = "ErrorContainerWalledVerbatimWalledHangingRankedHangingFencedVerbatimLineLeafUniformEscapedPrefixedText"
+const _Type_name = "ErrorContainerWalledVerbatimWalledHangingRankedHangingFencedVerbatimLineLeafUniformEscapedPrefixedTextTable"
 
-var _Type_index = [...]uint8{0, 5, 14, 20, 34, 41, 54, 60, 72, 76, 83, 90, 98, 102}
+var _Type_index = [...]uint8{0, 5, 14, 20, 34, 41, 54, 60, 72, 76, 83, 90, 98, 102, 107}
 
 func (i Type) String() string {
 	if i < 0 || i >= Type(len(_Type_index)-1) {
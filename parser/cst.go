@@ -0,0 +1,23 @@
+package parser
+
+import (
+	"github.com/touchmarine/to/node"
+)
+
+// setTrivia sets the trivia of the node—the source between from and to not
+// covered by its children—and, if deep, the trivia of its descendants.
+//
+// The children of each node are ordered and lie within the node's offsets, so
+// the trivia and the children together cover the source between from and to.
+func setTrivia(n *node.Node, src []byte, from, to int, deep bool) {
+	n.Trivia = n.Trivia[:0]
+	offs := from
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		n.Trivia = append(n.Trivia, string(src[offs:c.Start]))
+		if deep {
+			setTrivia(c, src, c.Start, c.End, true)
+		}
+		offs = c.End
+	}
+	n.Trivia = append(n.Trivia, string(src[offs:to]))
+}
@@ -0,0 +1,129 @@
+package parser_test
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+)
+
+func TestCST(t *testing.T) {
+	p := parser.Parser{
+		Elements: config.Default.Elements.ParserElements(),
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+		CST:      true,
+	}
+
+	readme, err := os.ReadFile("../README.to")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcs := []string{
+		"",
+		"\uFEFFa",
+		"== Heading\n\n\n- a\n\t- b\n\n> quote\n>\n> - c\n",
+		"`go\nfmt\n`  trailing\n\n| a | b\n|:-|-:|\n| c |\n",
+		"a \\* ((http://a)) **b\\*c** __d\n\n",
+		string(readme),
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		srcs = append(srcs, randomText(r, r.Intn(80)))
+	}
+	for _, src := range srcs {
+		root, _ := p.Parse(nil, []byte(src))
+		got, err := node.Source(root)
+		if err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		if got != src {
+			t.Fatalf("got source %q, want %q", got, src)
+		}
+	}
+}
+
+func TestCSTEdit(t *testing.T) {
+	p := parser.Parser{
+		Elements: config.Default.Elements.ParserElements(),
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+		CST:      true,
+	}
+	src := "-  See ((http://a))\tand **b\\*c**\n\n\n\n- ((http://a))"
+	root, err := p.Parse(nil, []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rename the links; the rest is reproduced as it was, not
+	// canonicalized
+	var rename func(n *node.Node)
+	rename = func(n *node.Node) {
+		if n.Element == "Link" {
+			n.FirstChild.Value = "http://b"
+			n.FirstChild.Trivia = []string{"http://b"}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			rename(c)
+		}
+	}
+	rename(root)
+	got, err := node.Source(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "-  See ((http://b))\tand **b\\*c**\n\n\n\n- ((http://b))"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	root.FirstChild.AppendChild(&node.Node{Type: node.TypeContainer})
+	if _, err := node.Source(root); err == nil {
+		t.Error("got nil error for a node without trivia")
+	}
+
+	p.CST = false
+	root, _ = p.Parse(nil, []byte(src))
+	if root.Trivia != nil {
+		t.Errorf("got trivia %q without CST mode", root.Trivia)
+	}
+}
+
+func TestReparseCST(t *testing.T) {
+	p := parser.Parser{
+		Elements: config.Default.Elements.ParserElements(),
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+		CST:      true,
+	}
+
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 100; i++ {
+		src := randomText(r, r.Intn(80))
+		root, _ := p.Parse(nil, []byte(src))
+		for j := 0; j < 20; j++ {
+			start := r.Intn(len(src) + 1)
+			end := start + r.Intn(len(src)-start+1)%8
+			e := parser.Edit{
+				Start: start,
+				End:   end,
+				Text:  randomText(r, r.Intn(4)),
+			}
+			src = src[:start] + e.Text + src[end:]
+
+			root, _ = p.Reparse(root, []byte(src), e)
+			got, err := node.Source(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != src {
+				t.Fatalf("edit %+v: got source %q, want %q", e, got, src)
+			}
+		}
+	}
+}
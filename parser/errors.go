@@ -1,26 +1,113 @@
 package parser
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/touchmarine/to/node"
 )
 
 // PrintError prints one error per line if the given error is an ErrorList.
-// Otherwise, it just prints the error.
+// Otherwise, it just prints the error. It is PrintErrorSource without the
+// source.
 //
 // https://pkg.go.dev/go/scanner#PrintError
 func PrintError(w io.Writer, err error) {
+	PrintErrorSource(w, nil, err)
+}
+
+// PrintErrorSource is like PrintError but, if src is not nil, each error with
+// a known location is followed by the source line it occurred on and a caret
+// line underlining the error range:
+//
+// 	file.to:1:2: illegal character NULL
+// 		a\x00b
+// 		 ^^^^
+//
+// Non-printable characters in the source line, except tabs, are escaped as in
+// Go string literals.
+func PrintErrorSource(w io.Writer, src []byte, err error) {
 	list, ok := err.(ErrorList)
 	if ok {
 		for _, e := range list {
 			fmt.Fprintf(w, "%s\n", e)
+			if src != nil {
+				printExcerpt(w, src, e.Location.Range)
+			}
+		}
+	} else if e, ok := err.(*Error); ok {
+		fmt.Fprintf(w, "%s\n", e)
+		if src != nil {
+			printExcerpt(w, src, e.Location.Range)
 		}
 	} else if err != nil {
 		fmt.Fprintf(w, "%s\n", err)
 	}
 }
 
+// printExcerpt prints the source line on which the range starts and
+// underlines the range with carets. It prints nothing if the range is unknown
+// or out of bounds.
+func printExcerpt(w io.Writer, src []byte, r node.Range) {
+	if r == (node.Range{}) {
+		return
+	}
+	start := r.Start.Offset - r.Start.Column // line start
+	if start < 0 || r.Start.Offset > len(src) {
+		return
+	}
+	end := len(src) // line end
+	if i := bytes.IndexByte(src[start:], '\n'); i >= 0 {
+		end = start + i
+	}
+	underlineEnd := r.End.Offset
+	if underlineEnd > end {
+		// range spans multiple lines, underline until the end of the
+		// first line
+		underlineEnd = end
+	}
+
+	var line, caret strings.Builder
+	carets := 0
+	for i := start; i < end; {
+		ch, size := utf8.DecodeRune(src[i:])
+		s := string(ch)
+		if ch == utf8.RuneError && size == 1 {
+			s = fmt.Sprintf(`\x%02x`, src[i])
+		} else if ch != '\t' && !unicode.IsPrint(ch) {
+			q := strconv.QuoteRune(ch)
+			s = q[1 : len(q)-1]
+		}
+		line.WriteString(s)
+
+		switch {
+		case i < r.Start.Offset:
+			if ch == '\t' {
+				// keep tabs so the carets align regardless of
+				// tab width
+				caret.WriteByte('\t')
+			} else {
+				caret.WriteString(strings.Repeat(" ", utf8.RuneCountInString(s)))
+			}
+		case i < underlineEnd:
+			carets += utf8.RuneCountInString(s)
+		}
+		i += size
+	}
+	if carets < 1 {
+		carets = 1
+	}
+	caret.WriteString(strings.Repeat("^", carets))
+
+	fmt.Fprintf(w, "\t%s\n\t%s\n", line.String(), caret.String())
+}
+
 // ErrorList is a list of errors. The zero value is ready to use.
 type ErrorList []*Error
 
@@ -48,9 +135,10 @@ func (el *ErrorList) Add(err *Error) {
 	*el = append(*el, err)
 }
 
-// Sort sorts the error list by error message.
+// Sort sorts the error list by location: by document URI, then by offset. Errors
+// at the same location are sorted by code and then by message.
 func (el ErrorList) Sort() {
-	sort.Sort(el)
+	sort.Stable(el)
 }
 
 // Len implements the sort Interface.
@@ -60,21 +148,99 @@ func (el ErrorList) Len() int {
 
 // Swap implements the sort Interface.
 func (el ErrorList) Swap(i, j int) {
-	el[i] = el[j]
-	el[j] = el[i]
+	el[i], el[j] = el[j], el[i]
 }
 
 // Less implements the sort Interface.
 func (el ErrorList) Less(i, j int) bool {
-	return el[i].Message < el[j].Message
+	a, b := el[i], el[j]
+	if a.Location.URI != b.Location.URI {
+		return a.Location.URI < b.Location.URI
+	}
+	if x, y := a.Location.Range.Start.Offset, b.Location.Range.Start.Offset; x != y {
+		return x < y
+	}
+	if a.Code != b.Code {
+		return a.Code < b.Code
+	}
+	return a.Message < b.Message
+}
+
+// Code is a stable identifier of an error kind. Unlike messages, codes do not
+// change and can be relied upon by tools.
+type Code string
+
+// Error codes
+const (
+	CodeInvalidUTF8Encoding Code = "invalidUTF8Encoding"
+	CodeIllegalNULL         Code = "illegalNULL"
+	CodeIllegalBOM          Code = "illegalBOM"
+)
+
+// Severity is the severity of an error. Its values match the LSP diagnostic
+// severities.
+//
+// https://microsoft.github.io/language-server-protocol/specifications/specification-3-17/#diagnosticSeverity
+type Severity int
+
+// Severities
+const (
+	SeverityError       Severity = iota + 1 // Error
+	SeverityWarning                         // Warning
+	SeverityInformation                     // Information
+	SeverityHint                            // Hint
+)
+
+// String returns the lower-case name of the severity.
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInformation:
+		return "information"
+	case SeverityHint:
+		return "hint"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
 }
 
 // Error represents a parser error.
+//
+// A zero Location means that the location is unknown, as is the case with the
+// Err* values which serve as templates for the reported errors.
 type Error struct {
-	Message string
+	Location node.Location // where the error occurred
+	Code     Code          // error kind
+	Severity Severity      // error severity
+	Message  string        // human-readable description
 }
 
-// Error returns the error message.
+// Error returns the error message prefixed with the location in the form
+// uri:line:column if the location is known. Line and column are one-based.
+// Messages of less severe errors, e.g. warnings, are also prefixed with the
+// severity:
+// 	file.to:1:2: warning: undefined footnote "a"
 func (e Error) Error() string {
-	return e.Message
+	msg := e.Message
+	if e.Severity > SeverityError {
+		msg = e.Severity.String() + ": " + msg
+	}
+	if e.Location.Range == (node.Range{}) {
+		return msg
+	}
+	s := e.Location.Range.Start
+	pos := fmt.Sprintf("%d:%d", s.Line+1, s.Column+1)
+	if e.Location.URI != "" {
+		pos = string(e.Location.URI) + ":" + pos
+	}
+	return pos + ": " + msg
+}
+
+// Is reports whether the target is an *Error with the same code. It allows
+// matching located errors against the Err* values using errors.Is.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code != "" && t.Code == e.Code
 }
@@ -0,0 +1,132 @@
+package parser_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+)
+
+func TestErrorLocation(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			"NULL",
+			"a\x00b",
+			"file.to:1:2: illegal character NULL\n\ta\\x00b\n\t ^^^^\n",
+		},
+		{
+			"second line",
+			"a\n\tb\x00",
+			"file.to:2:3: illegal character NULL\n\t\tb\\x00\n\t\t ^^^^\n",
+		},
+		{
+			"invalid UTF-8",
+			"ž\x80",
+			"file.to:1:3: invalid UTF-8 encoding\n\tž\\x80\n\t ^^^^\n",
+		},
+		{
+			"sorted by offset",
+			"\x80\uFEFF\x00",
+			"file.to:1:1: invalid UTF-8 encoding\n\t\\x80\\ufeff\\x00\n\t^^^^\n" +
+				"file.to:1:2: illegal byte order mark\n\t\\x80\\ufeff\\x00\n\t    ^^^^^^\n" +
+				"file.to:1:5: illegal character NULL\n\t\\x80\\ufeff\\x00\n\t          ^^^^\n",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := parser.Parser{
+				Elements: parser.Elements{
+					"T":  {Name: "T", Type: node.TypeLeaf},
+					"MT": {Name: "MT", Type: node.TypeText},
+				},
+				TabWidth: 8,
+				URI:      "file.to",
+			}
+			_, err := p.Parse(nil, []byte(c.in))
+			if err == nil {
+				t.Fatal("want error")
+			}
+
+			var b strings.Builder
+			parser.PrintErrorSource(&b, []byte(c.in), err)
+			if got := b.String(); got != c.out {
+				t.Errorf("got\n%q\nwant\n%q", got, c.out)
+			}
+
+			// without the source, only the errors
+			b.Reset()
+			parser.PrintError(&b, err)
+			want := ""
+			for _, l := range strings.SplitAfter(c.out, "\n") {
+				if strings.HasPrefix(l, "file.to:") {
+					want += l
+				}
+			}
+			if got := b.String(); got != want {
+				t.Errorf("without source got\n%q\nwant\n%q", got, want)
+			}
+		})
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	p := parser.Parser{
+		Elements: parser.Elements{
+			"T":  {Name: "T", Type: node.TypeLeaf},
+			"MT": {Name: "MT", Type: node.TypeText},
+		},
+	}
+	_, err := p.Parse(nil, []byte("a\x00"))
+	list, ok := err.(parser.ErrorList)
+	if !ok || len(list) != 1 {
+		t.Fatalf("got %v, want a single error", err)
+	}
+
+	e := list[0]
+	if !errors.Is(e, parser.ErrIllegalNULL) {
+		t.Errorf("want %v to be ErrIllegalNULL", e)
+	}
+	if errors.Is(e, parser.ErrIllegalBOM) {
+		t.Errorf("want %v not to be ErrIllegalBOM", e)
+	}
+	if e.Code != parser.CodeIllegalNULL {
+		t.Errorf("got code %q, want %q", e.Code, parser.CodeIllegalNULL)
+	}
+	if e.Severity != parser.SeverityError {
+		t.Errorf("got severity %s, want %s", e.Severity, parser.SeverityError)
+	}
+}
+
+func TestErrorSeverity(t *testing.T) {
+	loc := node.Location{
+		URI: "file.to",
+		Range: node.Range{
+			Start: node.Position{Offset: 1, Line: 0, Column: 1},
+			End:   node.Position{Offset: 2, Line: 0, Column: 2},
+		},
+	}
+	cases := []struct {
+		name string
+		in   parser.Error
+		out  string
+	}{
+		{"error", parser.Error{Location: loc, Severity: parser.SeverityError, Message: "a"}, "file.to:1:2: a"},
+		{"no severity", parser.Error{Location: loc, Message: "a"}, "file.to:1:2: a"},
+		{"warning", parser.Error{Location: loc, Severity: parser.SeverityWarning, Message: "a"}, "file.to:1:2: warning: a"},
+		{"unknown location", parser.Error{Severity: parser.SeverityHint, Message: "a"}, "hint: a"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.in.Error(); got != c.out {
+				t.Errorf("got %q, want %q", got, c.out)
+			}
+		})
+	}
+}
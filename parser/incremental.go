@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+
+	"github.com/touchmarine/to/node"
+)
+
+// Edit is a text edit—the bytes from Start to End of the old source replaced
+// by Text.
+type Edit struct {
+	Start, End int    // byte offsets in the old source
+	Text       string // replacement text
+}
+
+// Reparse parses src, the old source with the edit applied, re-parsing only the
+// top-level blocks affected by the edit.
+//
+// old must be the tree returned by Parse or Reparse (with the same Parser
+// values) for the old source, unmodified by transformers. Reparse moves the
+// unaffected blocks of old into the returned tree, shifting their offsets and
+// locations, so old must not be used afterwards. The returned tree and errors
+// are the same as those returned by Parse(nil, src).
+//
+// Parsing restarts at the last top-level block that starts at the beginning of
+// a line before the edited line. It stops at the first top-level block after
+// the edit that starts at the beginning of a line and parses the same as the
+// corresponding old block; the rest of the old blocks are reused. If no such
+// blocks exist, the whole document is re-parsed.
+func (pp Parser) Reparse(old *node.Node, src []byte, e Edit) (*node.Node, error) {
+	delta := len(e.Text) - (e.End - e.Start)
+	if old == nil || old.Type != node.TypeContainer || e.Start < 0 || e.Start > e.End ||
+		e.End+delta > len(src) || !bytes.Equal(src[e.Start:e.Start+len(e.Text)], []byte(e.Text)) {
+		// not an edit of the old tree's source
+		return pp.Parse(nil, src)
+	}
+
+	var blocks []*node.Node // old top-level blocks
+	for c := old.FirstChild; c != nil; c = c.NextSibling {
+		blocks = append(blocks, c)
+	}
+
+	var p parser
+	p.registerElements(pp.Elements)
+	p.registerMatchers(pp.Matchers)
+	p.tabWidth = pp.TabWidth
+	p.uri = pp.URI
+	p.src = src
+	base := p
+
+	restart := -1 // index of the block where parsing restarts, -1=beginning
+	for i := len(blocks) - 1; i >= 0; i-- {
+		b := blocks[i]
+		if b.Start == 0 || b.Start >= e.Start || b.Location.Range.Start.Column > 0 {
+			continue
+		}
+		if bytes.IndexByte(src[b.Start:e.Start], '\n') < 0 {
+			// edit on the first line which previous blocks may have
+			// looked at
+			continue
+		}
+		p.seek(b.Start, b.Location.Range.Start.Line)
+		if p.blankIndependent() {
+			restart = i
+			break
+		}
+	}
+	if restart < 0 {
+		p = base
+		p.init(nil, src)
+	}
+
+	reuse := -1 // index of the first reused block, -1=none
+	var lineDelta int
+	editEnd := e.Start + len(e.Text) // in src
+	p.stop = func() bool {
+		if p.offset < editEnd || p.offset != p.lineOffset || len(p.lead) > 0 {
+			return false
+		}
+		offs := p.offset - delta
+		i := sort.Search(len(blocks), func(i int) bool {
+			return blocks[i].Start >= offs
+		})
+		if i == len(blocks) || blocks[i].Start != offs || blocks[i].Location.Range.Start.Column > 0 {
+			return false
+		}
+		if !p.blankIndependent() {
+			return false
+		}
+		reuse = i
+		lineDelta = p.line - blocks[i].Location.Range.Start.Line
+		return true
+	}
+	c := p.parse(nil)
+
+	from := restart
+	if from < 0 {
+		from = 0
+		old.Start = c.Start
+		old.Location.Range.Start = c.Location.Range.Start
+	}
+	for _, b := range blocks[from:] {
+		old.RemoveChild(b)
+	}
+	for c.FirstChild != nil {
+		b := c.FirstChild
+		c.RemoveChild(b)
+		old.AppendChild(b)
+		if pp.CST {
+			setTrivia(b, src, b.Start, b.End, true)
+		}
+	}
+	if reuse >= 0 {
+		for _, b := range blocks[reuse:] {
+			shift(b, delta, lineDelta)
+			old.AppendChild(b)
+		}
+		old.End += delta
+		old.Location.Range.End.Offset += delta
+		old.Location.Range.End.Line += lineDelta
+	} else {
+		old.End = c.End
+		old.Location.Range.End = c.Location.Range.End
+	}
+	if pp.CST {
+		// the trivia of the reused blocks did not change
+		setTrivia(old, src, 0, len(src), false)
+	}
+
+	return old, pp.encodingErrors(src).Err()
+}
+
+// seek moves to the beginning of the line at the given offset as if the
+// newline before it was just consumed at the top level.
+func (p *parser) seek(offset, line int) {
+	p.rdOffset = offset
+	p.ch = '\n'
+	p.line = line - 1
+	p.next()
+	p.lead = nil
+	p.blank = true
+}
+
+// blankIndependent reports whether the block at the current offset parses the
+// same and leaves the parser in the same state regardless of p.blank—the only
+// state at the beginning of a top-level line that does not follow from the
+// source.
+func (p *parser) blankIndependent() bool {
+	a, b := *p, *p
+	a.blank, b.blank = true, false
+	a.errors, b.errors = nil, nil
+	a.stop, b.stop = nil, nil
+	return equalNodes(a.parseBlock(), b.parseBlock()) &&
+		a.ch == b.ch && a.offset == b.offset && a.rdOffset == b.rdOffset &&
+		a.line == b.line && a.lineOffset == b.lineOffset &&
+		a.blank == b.blank && cmpRunes(a.lead, b.lead) &&
+		len(a.blocks) == 0 && len(b.blocks) == 0
+}
+
+// encodingErrors returns the errors the parser reports for the source—the
+// encoding errors found on reading it.
+func (pp Parser) encodingErrors(src []byte) ErrorList {
+	var p parser
+	p.uri = pp.URI
+	p.init(nil, src)
+	for p.ch >= 0 {
+		p.next()
+	}
+	p.errors.Sort()
+	return p.errors
+}
+
+// shift shifts the offsets and lines of the node and its descendants.
+func shift(n *node.Node, offset, line int) {
+	n.Start += offset
+	n.End += offset
+	r := &n.Location.Range
+	r.Start.Offset += offset
+	r.Start.Line += line
+	r.End.Offset += offset
+	r.End.Line += line
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		shift(c, offset, line)
+	}
+}
+
+func equalNodes(a, b *node.Node) bool {
+	if a.Element != b.Element || a.Type != b.Type || a.Value != b.Value ||
+		a.Start != b.Start || a.End != b.End || a.Location != b.Location ||
+		!reflect.DeepEqual(a.Data, b.Data) {
+		return false
+	}
+	x, y := a.FirstChild, b.FirstChild
+	for ; x != nil && y != nil; x, y = x.NextSibling, y.NextSibling {
+		if !equalNodes(x, y) {
+			return false
+		}
+	}
+	return x == nil && y == nil
+}
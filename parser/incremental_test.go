@@ -0,0 +1,112 @@
+package parser_test
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+)
+
+// fragments from which random sources and edits are built
+var fragments = []string{
+	"a", "b c", " ", "  ", "\t", "\n", "\n", "\n\n",
+	"-", "- ", "1. ", ">", "> ", "==", "## ", "=", "?", ":", "+", "_",
+	"`", "``", "'", "''", "/", "//", "!", "!!", ".image ",
+	"*", "**", "__", "[[", "]]", "((", "))", "http://x", "\\", "\\\\",
+	"\x00", "\xff", "\uFEFF",
+}
+
+func randomText(r *rand.Rand, n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteString(fragments[r.Intn(len(fragments))])
+	}
+	return b.String()
+}
+
+func TestReparse(t *testing.T) {
+	p := parser.Parser{
+		Elements: config.Default.Elements.ParserElements(),
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		src := randomText(r, r.Intn(80))
+		root, _ := p.Parse(nil, []byte(src))
+		for j := 0; j < 20; j++ {
+			start := r.Intn(len(src) + 1)
+			end := start + r.Intn(len(src)-start+1)%8
+			e := parser.Edit{
+				Start: start,
+				End:   end,
+				Text:  randomText(r, r.Intn(4)),
+			}
+			old := src
+			src = src[:start] + e.Text + src[end:]
+
+			var err error
+			root, err = p.Reparse(root, []byte(src), e)
+			want, wantErr := p.Parse(nil, []byte(src))
+			if got, want := printTree(t, root), printTree(t, want); got != want {
+				t.Fatalf("%q with edit %+v\ngot:\n%s\nwant:\n%s", old, e, got, want)
+			}
+			if fmt.Sprint(err) != fmt.Sprint(wantErr) {
+				t.Fatalf("%q with edit %+v: got error %v, want %v", old, e, err, wantErr)
+			}
+		}
+	}
+}
+
+func TestReparseReuse(t *testing.T) {
+	p := parser.Parser{
+		Elements: config.Default.Elements.ParserElements(),
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+	}
+
+	var b strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&b, "- item %d\n  text\n\n", i)
+	}
+	src := b.String()
+	root, err := p.Parse(nil, []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, last := root.FirstChild, root.LastChild
+
+	start := strings.Index(src, "item 50")
+	e := parser.Edit{
+		Start: start,
+		End:   start + len("item"),
+		Text:  "*item*\n  ",
+	}
+	src = src[:e.Start] + e.Text + src[e.End:]
+	root, err = p.Reparse(root, []byte(src), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.FirstChild != first || root.LastChild != last {
+		t.Error("unaffected blocks not reused")
+	}
+	if want := len(src); root.LastChild.End+len("\n\n") != want {
+		t.Errorf("got last block end %d, want %d", root.LastChild.End, want-len("\n\n"))
+	}
+}
+
+func printTree(t *testing.T, n *node.Node) string {
+	t.Helper()
+	var b strings.Builder
+	m := node.PrintData | node.PrintOffsets | node.PrintLocation
+	if err := (node.Printer{m}).Fprint(&b, n); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
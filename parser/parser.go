@@ -20,6 +20,8 @@ const trace = false
 const (
 	KeyRank        = "rank"        // rank (level) in ranked hanging elements
 	KeyOpeningText = "openingText" // opening text in fenced elements
+	KeyHeader      = "header"      // whether a table row is a header row
+	KeyAlign       = "align"       // alignment of a table cell: left, center, or right
 )
 
 // Elements is a map of element names to Elements.
@@ -31,24 +33,34 @@ type Element struct {
 	Type      node.Type // node type
 	Delimiter string    // delimiter character or a whole delimiter
 	Matcher   string    // used to determine the contents of prefixed elements
+	Comment   bool      // whether the element is a comment (see Tokens)
 }
 
 // Parser parses Touch formatted text based on the values in this struct.
 type Parser struct {
-	Elements Elements    // element set
-	Matchers matcher.Map // available matchers (by name)
-	TabWidth int         // tab=<tabwidth> x spaces
+	Elements Elements         // element set
+	Matchers matcher.Map      // available matchers (by name)
+	TabWidth int              // tab=<tabwidth> x spaces
+	URI      node.DocumentURI // document URI used in error locations
+	CST      bool             // record trivia (see node.Node.Trivia)
 }
 
 // Parse parses Touch formatted text supplied by the given reader and returns
 // the parsed node tree.
+//
+// In CST mode, the parser records the trivia of each node so that the tree
+// holds every byte of the source, which node.Source reproduces exactly.
 func (pp Parser) Parse(sourceMap *source.Map, src []byte) (*node.Node, error) {
 	var p parser
 	p.registerElements(pp.Elements)
 	p.registerMatchers(pp.Matchers)
 	p.tabWidth = pp.TabWidth
+	p.uri = pp.URI
 	p.init(sourceMap, src)
 	root := p.parse(nil)
+	if pp.CST {
+		setTrivia(root, src, 0, len(src), true)
+	}
 	p.errors.Sort()
 	return root, p.errors.Err()
 }
@@ -66,6 +78,7 @@ type parser struct {
 	specialEscapes []string           // delimiters that do not start with a punctuation
 	matchers       matcher.Map        // registered matchers by name
 	tabWidth       int                // tab=tabWidth x spaces
+	uri            node.DocumentURI   // document URI
 
 	// parsing
 	ch         rune // current character
@@ -79,6 +92,16 @@ type parser struct {
 	blank  bool   // whether the lead is blank
 
 	inlines []rune // open inlines
+	cell    string // delimiter that ends table cells (if in a table row)
+
+	// stop reports whether to stop before the next top-level block; used by
+	// Reparse
+	stop func() bool
+
+	// marks are the lead delimiter and escape tokens, recorded only if
+	// marking; used by Tokens
+	marking bool
+	marks   []Token
 
 	// tracing
 	indent int // trace indentation
@@ -189,6 +212,10 @@ func (p *parser) parse(reqdBlocks []rune) *node.Node {
 				endOffs = p.offset
 			}
 		} else {
+			if p.stop != nil && len(p.blocks) == 0 && p.stop() {
+				break
+			}
+
 			b := p.parseBlock()
 			if b == nil {
 				panic("parser: parseBlock() returned no block")
@@ -245,6 +272,8 @@ func (p *parser) parseBlock() *node.Node {
 				return p.parseRankedHanging(el.Name, el.Delimiter)
 			case node.TypeFenced:
 				return p.parseFenced(el.Name)
+			case node.TypeTable:
+				return p.parseTable(el.Name, el.Delimiter)
 			default:
 				panic(fmt.Sprintf("parser.parseBlock: unexpected node type %s (%s)", el.Type, el.Name))
 			}
@@ -800,6 +829,226 @@ func (p *parser) parseVerbatimLine(name, delim string) *node.Node {
 	return n
 }
 
+// parseTable parses consecutive rows of cells separated by delim:
+// 	| a | b |
+// 	|:--|--:|
+// 	| c | d |
+//
+// The first line after the first row that consists only of delimiters, dashes,
+// and colons separates the header rows from the other rows and sets the
+// alignment of the columns. The closing delimiter of a row is optional.
+func (p *parser) parseTable(name, delim string) *node.Node {
+	if trace {
+		defer p.tracef("parseTable (%s, delim=%q)", name, delim)()
+	}
+
+	start := p.pos()
+	startOffs := p.offset
+	n := &node.Node{
+		Element: name,
+		Type:    node.TypeTable,
+		Start:   startOffs,
+	}
+
+	var align []string
+	separated := false
+	end := p.pos()
+	endOffs := p.offset
+	for {
+		if a, ok := p.tableSeparator(delim); ok && !separated && n.FirstChild != nil {
+			for r := n.FirstChild; r != nil; r = r.NextSibling {
+				r.Data = node.Data{KeyHeader: true}
+			}
+			align = a
+			separated = true
+			for p.ch >= 0 && p.ch != '\n' {
+				p.next()
+			}
+			end = p.pos()
+			endOffs = p.offset
+		} else {
+			row := p.parseTableRow(delim)
+			n.AppendChild(row)
+			end = row.Location.Range.End
+			endOffs = row.End
+		}
+
+		// prepare next line
+		p.next()
+		p.parseLead()
+		p.parseSpacing()
+
+		if el, ok := p.matchBlock(); !ok || el.Name != name || p.isEscape() || !p.continues(p.blocks) {
+			break
+		}
+	}
+
+	// pad the rows to the same number of cells
+	columns := len(align)
+	for r := n.FirstChild; r != nil; r = r.NextSibling {
+		if c := countChildren(r); c > columns {
+			columns = c
+		}
+	}
+	for r := n.FirstChild; r != nil; r = r.NextSibling {
+		for i := countChildren(r); i < columns; i++ {
+			r.AppendChild(&node.Node{
+				Type:     node.TypeContainer,
+				Start:    r.End,
+				End:      r.End,
+				Location: node.Location{Range: node.Range{Start: r.Location.Range.End, End: r.Location.Range.End}},
+			})
+		}
+		i := 0
+		for c := r.FirstChild; c != nil; c = c.NextSibling {
+			if i < len(align) && align[i] != "" {
+				c.Data = node.Data{KeyAlign: align[i]}
+			}
+			i++
+		}
+	}
+
+	n.End = endOffs
+	n.Location = node.Location{
+		Range: node.Range{
+			Start: start,
+			End:   end,
+		},
+	}
+	return n
+}
+
+// tableSeparator reports whether the current line is a table separator line
+// and returns the alignment of its columns.
+func (p *parser) tableSeparator(delim string) ([]string, bool) {
+	line := p.src[p.offset:]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	cells := strings.Split(string(line[len(delim):]), delim)
+	if last := len(cells) - 1; last > 0 && strings.Trim(cells[last], " \t") == "" {
+		// closing delimiter
+		cells = cells[:last]
+	}
+
+	var align []string
+	for _, c := range cells {
+		c = strings.Trim(c, " \t")
+		left := strings.HasPrefix(c, ":")
+		right := strings.HasSuffix(c, ":")
+		if dashes := strings.Trim(c, ":"); dashes == "" || strings.Trim(dashes, "-") != "" ||
+			len(c)-len(dashes) > 2 {
+			return nil, false
+		}
+		switch {
+		case left && right:
+			align = append(align, "center")
+		case left:
+			align = append(align, "left")
+		case right:
+			align = append(align, "right")
+		default:
+			align = append(align, "")
+		}
+	}
+	return align, true
+}
+
+// parseTableRow parses the cells of the row on the current line.
+func (p *parser) parseTableRow(delim string) *node.Node {
+	if trace {
+		defer p.tracef("parseTableRow (delim=%q)", delim)()
+	}
+
+	start := p.pos()
+	startOffs := p.offset
+	row := &node.Node{
+		Type: node.TypeContainer,
+	}
+
+	prevCell := p.cell
+	p.cell = delim
+	defer func() {
+		p.cell = prevCell
+	}()
+
+	end := p.pos()
+	endOffs := p.offset
+	for p.hasPrefix([]byte(delim)) {
+		for i := 0; i < utf8.RuneCountInString(delim); i++ {
+			// consume delimiter
+			p.next()
+		}
+		end = p.pos()
+		endOffs = p.offset
+		for isSpacing(p.ch) {
+			p.next()
+		}
+		if p.ch < 0 || p.ch == '\n' {
+			// closing delimiter
+			break
+		}
+
+		cell, _ := p.parseInlines()
+		trimTrailingSpacing(cell)
+		row.AppendChild(cell)
+		end = cell.Location.Range.End
+		endOffs = cell.End
+	}
+
+	// rest of the line (if the last cell was not terminated by delim)
+	for p.ch >= 0 && p.ch != '\n' {
+		p.next()
+	}
+
+	row.Start = startOffs
+	row.End = endOffs
+	row.Location = node.Location{
+		Range: node.Range{
+			Start: start,
+			End:   end,
+		},
+	}
+	return row
+}
+
+// trimTrailingSpacing removes the spacing at the end of the last text in the
+// container (e.g. before a cell delimiter).
+func trimTrailingSpacing(container *node.Node) {
+	t := container.LastChild
+	if t == nil || t.Type != node.TypeText {
+		return
+	}
+	v := strings.TrimRight(t.Value, " \t")
+	d := len(t.Value) - len(v)
+	if d == 0 {
+		return
+	}
+	if v == "" {
+		container.RemoveChild(t)
+	} else {
+		t.Value = v
+		t.End -= d
+		t.Location.Range.End.Offset -= d
+		t.Location.Range.End.Column -= d
+	}
+	if container.LastChild != nil {
+		container.End = container.LastChild.End
+		container.Location.Range.End = container.LastChild.Location.Range.End
+	} else {
+		container.End = container.Start
+		container.Location.Range.End = container.Location.Range.Start
+	}
+}
+
+func countChildren(n *node.Node) int {
+	var i int
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		i++
+	}
+	return i
+}
+
 func (p *parser) pos() node.Position {
 	return node.Position{
 		Offset: p.offset,
@@ -849,7 +1098,7 @@ func (p *parser) parseInlines() (*node.Node, bool) {
 	end := p.pos()
 	endOffs := p.offset
 	for p.ch >= 0 {
-		if p.closingDelimiter() >= 0 {
+		if p.closingDelimiter() >= 0 || p.isCellEnd() {
 			break
 		}
 
@@ -1024,6 +1273,12 @@ func (p *parser) parseEscaped(name string) (*node.Node, bool) {
 			if p.ch < 0 {
 				break
 			} else if p.ch == '\n' {
+				if p.cell != "" {
+					// table rows are single lines
+					cont = false
+					break
+				}
+
 				p.next()
 				p.parseLead()
 				p.parseSpacing()
@@ -1218,8 +1473,12 @@ func (p *parser) parseText(name string) (*node.Node, bool) {
 	for {
 		isEscape := p.isEscape()
 		_, matchesInline := p.matchInline()
-		if p.ch < 0 || p.ch == '\n' || !isEscape && (p.closingDelimiter() >= 0 || matchesInline) {
+		if p.ch < 0 || p.ch == '\n' || !isEscape && (p.closingDelimiter() >= 0 || matchesInline || p.isCellEnd()) {
 			line := p.src[offs:p.offset]
+			if len(escapes) > 0 {
+				// copy so removing escapes does not modify the source
+				line = append([]byte(nil), line...)
+			}
 			for i := len(escapes) - 1; i >= 0; i-- { // reverse so we don't have to account for removed chars
 				x := escapes[i] - offs                 // escape char position in slice
 				line = append(line[:x], line[x+1:]...) // remove escape char
@@ -1230,6 +1489,12 @@ func (p *parser) parseText(name string) (*node.Node, bool) {
 			if p.ch < 0 {
 				break
 			} else if p.ch == '\n' {
+				if p.cell != "" {
+					// table rows are single lines
+					cont = false
+					break
+				}
+
 				p.next()
 				p.parseLead()
 				p.parseSpacing()
@@ -1240,7 +1505,7 @@ func (p *parser) parseText(name string) (*node.Node, bool) {
 				}
 
 				offs = p.offset
-			} else if p.closingDelimiter() >= 0 || matchesInline {
+			} else if p.closingDelimiter() >= 0 || matchesInline || p.isCellEnd() {
 				end = p.pos()
 				endOffs = p.offset
 				break
@@ -1249,6 +1514,9 @@ func (p *parser) parseText(name string) (*node.Node, bool) {
 			}
 		} else {
 			if isEscape {
+				if p.marking {
+					p.mark(TokenEscape, "", p.rdOffset+utf8.RuneLen(p.peek()))
+				}
 				escapes = append(escapes, p.offset)
 				p.next()
 			}
@@ -1333,6 +1601,11 @@ func (p *parser) closingDelimiter() rune {
 	return -1
 }
 
+// isCellEnd reports whether the current character ends a table cell.
+func (p *parser) isCellEnd() bool {
+	return p.cell != "" && (p.ch == '\n' || p.hasPrefix([]byte(p.cell)))
+}
+
 func (p *parser) init(sourceMap *source.Map, src []byte) {
 	p.src = src
 	p.sourceMap = sourceMap
@@ -1376,6 +1649,9 @@ func (p *parser) parseLead() {
 			p.parseSpacing() // calls p.next()
 			continue
 		} else if p.ch == ch {
+			if p.marking {
+				p.mark(TokenDelimiter, p.blockMap[string(p.ch)].Name, p.rdOffset)
+			}
 			i++
 		} else {
 			break
@@ -1425,9 +1701,21 @@ func isSpacing(r rune) bool {
 
 // Encoding errors
 var (
-	ErrInvalidUTF8Encoding = &Error{"invalid UTF-8 encoding"}
-	ErrIllegalNULL         = &Error{"illegal character NULL"}
-	ErrIllegalBOM          = &Error{"illegal byte order mark"}
+	ErrInvalidUTF8Encoding = &Error{
+		Code:     CodeInvalidUTF8Encoding,
+		Severity: SeverityError,
+		Message:  "invalid UTF-8 encoding",
+	}
+	ErrIllegalNULL = &Error{
+		Code:     CodeIllegalNULL,
+		Severity: SeverityError,
+		Message:  "illegal character NULL",
+	}
+	ErrIllegalBOM = &Error{
+		Code:     CodeIllegalBOM,
+		Severity: SeverityError,
+		Message:  "illegal byte order mark",
+	}
 )
 
 const (
@@ -1455,15 +1743,15 @@ func (p *parser) next() {
 		r, w := rune(p.src[p.rdOffset]), 1
 		switch {
 		case r == 0:
-			p.error(ErrIllegalNULL)
+			p.error(ErrIllegalNULL, w)
 		case r >= utf8.RuneSelf:
 			// not ASCII
 			r, w = utf8.DecodeRune(p.src[p.rdOffset:])
 			if r == utf8.RuneError && w == 1 {
-				p.error(ErrInvalidUTF8Encoding)
+				p.error(ErrInvalidUTF8Encoding, w)
 			} else if r == bom && p.offset > 0 {
 				// BOM at offset 0 is skipped at init
-				p.error(ErrIllegalBOM)
+				p.error(ErrIllegalBOM, w)
 			}
 		}
 		p.rdOffset += w
@@ -1602,8 +1890,22 @@ func (p *parser) openInline(delim rune) func() {
 	}
 }
 
-func (p *parser) error(err *Error) {
-	p.errors.Add(err)
+// error reports the given error at the current character spanning w bytes.
+// The given error is used as a template and is not modified.
+func (p *parser) error(err *Error, w int) {
+	e := *err
+	start := p.pos()
+	end := start
+	end.Offset += w
+	end.Column += w
+	e.Location = node.Location{
+		URI: p.uri,
+		Range: node.Range{
+			Start: start,
+			End:   end,
+		},
+	}
+	p.errors.Add(&e)
 }
 
 func (p *parser) printDelims(name string, blocks []rune) {
@@ -0,0 +1,153 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/touchmarine/to/node"
+)
+
+// streamChunkSize is the size of the reads of ParseStream.
+const streamChunkSize = 64 << 10
+
+// ParseStream parses Touch formatted text read from r and calls fn with each
+// top-level block as soon as it is complete, so that large documents can be
+// processed while they are read. Only the input of the blocks not yet passed to
+// fn is kept in memory.
+//
+// The blocks are passed in order and without a parent; their offsets and
+// locations are the same as those of the children of the root returned by
+// Parse(nil, src) for the whole input. In CST mode, each block has its trivia;
+// the trivia between the blocks is not recorded.
+//
+// A block is complete once a later top-level block starts at the beginning of
+// a line and parses the same regardless of the blocks before it (see Reparse);
+// the rest of the blocks are passed after r returns io.EOF. If fn or r returns
+// an error, ParseStream stops and returns it. Otherwise, it returns the errors
+// Parse would return, once all blocks are passed.
+func (pp Parser) ParseStream(r io.Reader, fn func(*node.Node) error) error {
+	s := stream{
+		pp:    pp,
+		chunk: make([]byte, streamChunkSize),
+	}
+	s.parser.registerElements(pp.Elements)
+	s.parser.registerMatchers(pp.Matchers)
+	s.parser.tabWidth = pp.TabWidth
+	s.parser.uri = pp.URI
+
+	for {
+		n, err := r.Read(s.chunk)
+		s.buf = append(s.buf, s.chunk[:n]...)
+		eof := err == io.EOF
+		if err != nil && !eof {
+			return err
+		}
+
+		end := len(s.buf) // parse only whole lines unless at EOF
+		if !eof {
+			end = bytes.LastIndexByte(s.buf, '\n') + 1
+			if end == 0 || end < s.next {
+				// re-parse only once the unfinished blocks doubled
+				// so that large blocks are not parsed over and over
+				continue
+			}
+		}
+		if err := s.emit(end, eof, fn); err != nil {
+			return err
+		}
+		if eof {
+			break
+		}
+	}
+
+	s.errors.Sort()
+	return s.errors.Err()
+}
+
+// stream holds the state of ParseStream.
+type stream struct {
+	pp     Parser
+	parser parser // parser with registered elements, copied for each parse
+	chunk  []byte // read buffer
+	errors ErrorList
+
+	// buf holds the input from offset base on. If base > 0, buf starts with
+	// the newline before the first block not yet passed, which is at offset
+	// 1 of buf on the given line.
+	buf  []byte
+	base int
+	line int
+	next int // minimum len(buf) to parse again
+}
+
+// emit parses buf up to end and passes the complete blocks to fn; if eof, all
+// blocks are complete. The rest of the input is kept in buf.
+func (s *stream) emit(end int, eof bool, fn func(*node.Node) error) error {
+	src := s.buf[:end]
+	p := s.parser
+	if s.base == 0 {
+		p.init(nil, src)
+	} else {
+		p.src = src
+		p.seek(1, s.line)
+	}
+	root := p.parse(nil)
+
+	var blocks []*node.Node
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		blocks = append(blocks, c)
+	}
+
+	keep := len(blocks) // index of the first block not passed
+	if !eof {
+		keep = 0
+		for i := len(blocks) - 1; i > 0; i-- {
+			b := blocks[i]
+			if b.Location.Range.Start.Column > 0 {
+				continue
+			}
+			q := s.parser
+			q.src = src
+			q.seek(b.Start, b.Location.Range.Start.Line)
+			if q.blankIndependent() {
+				keep = i
+				break
+			}
+		}
+	}
+
+	cut := end // offset in buf where the kept input starts
+	if keep < len(blocks) {
+		cut = blocks[keep].Start
+	}
+	for _, e := range p.errors {
+		if e.Location.Range.Start.Offset < cut {
+			e.Location.Range.Start.Offset += s.base
+			e.Location.Range.End.Offset += s.base
+			s.errors.Add(e)
+		}
+	}
+	for _, b := range blocks[:keep] {
+		root.RemoveChild(b)
+		if s.pp.CST {
+			setTrivia(b, src, b.Start, b.End, true)
+		}
+		shift(b, s.base, 0)
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+
+	if keep == 0 {
+		s.next = 2 * end
+		return nil
+	}
+	if keep < len(blocks) {
+		// keep the newline before the first kept block
+		s.line = blocks[keep].Location.Range.Start.Line
+		s.buf = append([]byte(nil), s.buf[cut-1:]...)
+		s.base += cut - 1
+		s.next = 2 * (end - cut + 1)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package parser_test
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+)
+
+// chunkReader reads the source in random small chunks.
+type chunkReader struct {
+	src string
+	r   *rand.Rand
+}
+
+func (c *chunkReader) Read(b []byte) (int, error) {
+	if len(c.src) == 0 {
+		return 0, io.EOF
+	}
+	n := 1 + c.r.Intn(8)
+	if n > len(b) {
+		n = len(b)
+	}
+	if n > len(c.src) {
+		n = len(c.src)
+	}
+	copy(b, c.src[:n])
+	c.src = c.src[n:]
+	return n, nil
+}
+
+func TestParseStream(t *testing.T) {
+	p := parser.Parser{
+		Elements: config.Default.Elements.ParserElements(),
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		src := randomText(r, r.Intn(120))
+
+		root, wantErr := p.Parse(nil, []byte(src))
+		var want strings.Builder
+		for c := root.FirstChild; c != nil; c = c.NextSibling {
+			want.WriteString(printTree(t, c))
+		}
+
+		var got strings.Builder
+		err := p.ParseStream(&chunkReader{src, r}, func(n *node.Node) error {
+			if n.Parent != nil {
+				t.Fatalf("%q: block has a parent", src)
+			}
+			got.WriteString(printTree(t, n))
+			return nil
+		})
+		if got.String() != want.String() {
+			t.Fatalf("%q\ngot:\n%s\nwant:\n%s", src, got.String(), want.String())
+		}
+		if fmt.Sprint(err) != fmt.Sprint(wantErr) {
+			t.Fatalf("%q: got error %v, want %v", src, err, wantErr)
+		}
+	}
+}
+
+func TestParseStreamEarly(t *testing.T) {
+	p := parser.Parser{
+		Elements: config.Default.Elements.ParserElements(),
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+	}
+
+	pr, pw := io.Pipe()
+	blocks := make(chan *node.Node)
+	done := make(chan error)
+	go func() {
+		done <- p.ParseStream(pr, func(n *node.Node) error {
+			blocks <- n
+			return nil
+		})
+	}()
+
+	// the first block is complete once the second starts
+	go pw.Write([]byte("- a\n  b\n\n= c\n"))
+	if n := <-blocks; n.Element != "ListItem" || n.Start != 0 || n.End != 7 {
+		t.Errorf("got first block %s [%d:%d], want ListItem [0:7]", n.Element, n.Start, n.End)
+	}
+
+	go pw.Close()
+	if n := <-blocks; n.Element != "Title" || n.Location.Range.Start.Line != 3 {
+		t.Errorf("got second block %s on line %d, want Title on line 3", n.Element, n.Location.Range.Start.Line)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseStreamError(t *testing.T) {
+	p := parser.Parser{
+		Elements: config.Default.Elements.ParserElements(),
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+	}
+
+	stop := fmt.Errorf("stop")
+	calls := 0
+	err := p.ParseStream(strings.NewReader("a\n\nb\n\nc\n"), func(n *node.Node) error {
+		calls++
+		return stop
+	})
+	if err != stop {
+		t.Errorf("got error %v, want %v", err, stop)
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+}
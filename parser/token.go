@@ -0,0 +1,227 @@
+package parser
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/touchmarine/to/node"
+)
+
+// TokenKind is the kind of a token.
+//
+//go:generate stringer -type=TokenKind -linecomment
+type TokenKind int
+
+const (
+	TokenDelimiter   TokenKind = iota // Delimiter
+	TokenText                         // Text
+	TokenEscape                       // Escape
+	TokenVerbatim                     // Verbatim
+	TokenComment                      // Comment
+	TokenOpeningText                  // OpeningText
+)
+
+// Token is a lexical token of Touch formatted text.
+type Token struct {
+	Kind       TokenKind
+	Element    string // element the token belongs to (blank if none)
+	Start, End int    // byte offsets in the source
+}
+
+// Tokens returns the tokens of the source, ordered by offset, as needed by
+// syntax highlighters:
+//   - delimiters of elements, including the delimiters of walled elements that
+//     continue them on the following lines (e.g. > in a blockquote)
+//   - text of non-verbatim elements
+//   - escapes (e.g. \*)
+//   - verbatim content of fenced, verbatim walled, verbatim line, and escaped
+//     elements (e.g. code)
+//   - comments—delimiters and content of comment elements and their descendants
+//   - opening text of fenced elements (e.g. a code block's language)
+//
+// A token never spans lines and never starts or ends with spacing; text tokens
+// may contain spacing, other tokens do not. The element of a text token is the
+// innermost element that contains it (e.g. Strong), not the text element.
+// Spacing, newlines, and bytes the parser skips (e.g. after the closing
+// delimiter of a fenced element) are not tokens.
+func (pp Parser) Tokens(src []byte) []Token {
+	var p parser
+	p.registerElements(pp.Elements)
+	p.registerMatchers(pp.Matchers)
+	p.tabWidth = pp.TabWidth
+	p.uri = pp.URI
+	p.marking = true
+	p.init(nil, src)
+	root := p.parse(nil)
+
+	l := lexer{
+		elements: pp.Elements,
+		src:      src,
+		marks:    p.marks,
+	}
+	l.node(root, "", TokenText, false)
+	for _, m := range p.marks {
+		if m.Kind != TokenDelimiter {
+			continue
+		}
+		if pp.Elements[m.Element].Comment {
+			m.Kind = TokenComment
+		}
+		l.tokens = append(l.tokens, m)
+	}
+	sort.SliceStable(l.tokens, func(i, j int) bool {
+		return l.tokens[i].Start < l.tokens[j].Start
+	})
+	return l.tokens
+}
+
+// mark records a token from the current offset to end.
+func (p *parser) mark(kind TokenKind, element string, end int) {
+	p.marks = append(p.marks, Token{
+		Kind:    kind,
+		Element: element,
+		Start:   p.offset,
+		End:     end,
+	})
+}
+
+// lexer converts node trees to tokens.
+type lexer struct {
+	elements Elements
+	src      []byte
+	marks    []Token // lead delimiters and escapes ordered by offset
+	tokens   []Token
+}
+
+// node adds the tokens of the node and its descendants. element is the
+// innermost element that contains the node, textKind is the kind of its text,
+// and delimiters reports whether the bytes not covered by the children of a
+// container are delimiters (in table rows).
+func (l *lexer) node(n *node.Node, element string, textKind TokenKind, delimiters bool) {
+	delimKind := TokenDelimiter
+	if textKind == TokenComment {
+		delimKind = TokenComment
+	}
+
+	switch {
+	case n.Type == node.TypeText:
+		l.runs(n.Start, n.End, textKind, element)
+		return
+	case n.Type == node.TypeContainer:
+	default:
+		element = n.Element
+		delimiters = true
+		if l.elements[n.Element].Comment {
+			textKind = TokenComment
+			delimKind = TokenComment
+		} else if textKind != TokenComment && isVerbatim(n.Type) {
+			textKind = TokenVerbatim
+		}
+	}
+
+	offs := n.Start
+	if n.Type == node.TypeFenced {
+		offs = l.openingLine(n, delimKind)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if delimiters {
+			l.runs(offs, c.Start, delimKind, element)
+		}
+		l.node(c, element, textKind, n.Type == node.TypeTable)
+		offs = c.End
+	}
+	if delimiters {
+		l.runs(offs, n.End, delimKind, element)
+	}
+}
+
+// openingLine adds the tokens of the opening line of the fenced element—the
+// delimiter and the opening text—and returns the offset after the line.
+func (l *lexer) openingLine(n *node.Node, delimKind TokenKind) int {
+	end := n.End
+	if i := bytes.IndexByte(l.src[n.Start:n.End], '\n'); i >= 0 {
+		end = n.Start + i
+	}
+	opening, _ := n.Data[KeyOpeningText].(string)
+	textStart := end - len(opening)
+	if textStart < n.Start {
+		textStart = n.Start
+	}
+	l.runs(n.Start, textStart, delimKind, n.Element)
+	kind := TokenOpeningText
+	if delimKind == TokenComment {
+		kind = TokenComment
+	}
+	l.runs(textStart, end, kind, n.Element)
+	return end
+}
+
+// runs adds the tokens of the given kind between start and end, split at
+// newlines, lead delimiters, and, in text, escapes. Delimiter tokens are split
+// at spacing too.
+func (l *lexer) runs(start, end int, kind TokenKind, element string) {
+	if start >= end {
+		return
+	}
+	i := sort.Search(len(l.marks), func(i int) bool {
+		return l.marks[i].End > start
+	})
+
+	from := start
+	flush := func(to int) {
+		s, e := from, to
+		for s < e && isSpacingByte(l.src[s]) {
+			s++
+		}
+		for e > s && isSpacingByte(l.src[e-1]) {
+			e--
+		}
+		if s < e {
+			l.tokens = append(l.tokens, Token{
+				Kind:    kind,
+				Element: element,
+				Start:   s,
+				End:     e,
+			})
+		}
+	}
+	for offs := start; offs < end; {
+		if i < len(l.marks) && l.marks[i].Start <= offs {
+			m := l.marks[i]
+			i++
+			if m.End <= offs || m.Kind == TokenEscape && kind != TokenText {
+				continue
+			}
+			flush(offs)
+			if m.Kind == TokenEscape {
+				l.tokens = append(l.tokens, Token{
+					Kind:    TokenEscape,
+					Element: element,
+					Start:   m.Start,
+					End:     m.End,
+				})
+			}
+			// lead delimiters are added by Tokens
+			offs = m.End
+			from = offs
+			continue
+		}
+		c := l.src[offs]
+		if c == '\n' || c == '\r' || kind == TokenDelimiter && isSpacingByte(c) {
+			flush(offs)
+			from = offs + 1
+		}
+		offs++
+	}
+	flush(end)
+}
+
+func isSpacingByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// isVerbatim reports whether the content of the node type is verbatim.
+func isVerbatim(t node.Type) bool {
+	return t == node.TypeFenced || t == node.TypeVerbatimWalled ||
+		t == node.TypeVerbatimLine || t == node.TypeEscaped
+}
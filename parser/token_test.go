@@ -0,0 +1,84 @@
+package parser_test
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/parser"
+)
+
+func TestTokens(t *testing.T) {
+	p := parser.Parser{
+		Elements: config.Default.Elements.ParserElements(),
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+	}
+	src := "== a **b**\n\n> c \\* d\n> ((http://x))\n\n`go\nx y\n`\n\n/ e\n/ f\n\n| g | //h// |\n"
+	want := []string{
+		`Delimiter Heading "=="`,
+		`Text TextBlock "a"`,
+		`Delimiter Strong "**"`,
+		`Text Strong "b"`,
+		`Delimiter Strong "**"`,
+		`Delimiter Blockquote ">"`,
+		`Text TextBlock "c"`,
+		`Escape TextBlock "\\*"`,
+		`Text TextBlock "d"`,
+		`Delimiter Blockquote ">"`,
+		`Delimiter Link "(("`,
+		`Verbatim Link "http://x"`,
+		`Delimiter Link "))"`,
+		`Delimiter CodeBlock "` + "`" + `"`,
+		`OpeningText CodeBlock "go"`,
+		`Verbatim CodeBlock "x y"`,
+		`Delimiter CodeBlock "` + "`" + `"`,
+		`Comment BlockComment "/"`,
+		`Comment BlockComment "e"`,
+		`Comment BlockComment "/"`,
+		`Comment BlockComment "f"`,
+		`Delimiter Table "|"`,
+		`Text Table "g"`,
+		`Delimiter Table "|"`,
+		`Comment Comment "//"`,
+		`Comment Comment "h"`,
+		`Comment Comment "//"`,
+		`Delimiter Table "|"`,
+	}
+
+	var got []string
+	for _, tok := range p.Tokens([]byte(src)) {
+		got = append(got, fmt.Sprintf("%s %s %q", tok.Kind, tok.Element, src[tok.Start:tok.End]))
+	}
+	if g, w := strings.Join(got, "\n"), strings.Join(want, "\n"); g != w {
+		t.Errorf("got\n%s\nwant\n%s", g, w)
+	}
+}
+
+func TestTokensRandom(t *testing.T) {
+	p := parser.Parser{
+		Elements: config.Default.Elements.ParserElements(),
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		src := []byte(randomText(r, r.Intn(80)))
+		end := 0
+		for _, tok := range p.Tokens(src) {
+			if tok.Start < end || tok.Start >= tok.End || tok.End > len(src) {
+				t.Fatalf("%q: token %+v overlaps or is out of range (previous end %d)", src, tok, end)
+			}
+			s := src[tok.Start:tok.End]
+			if bytes.IndexByte(s, '\n') >= 0 || strings.Trim(string(s), " \t\r") != string(s) {
+				t.Fatalf("%q: token %+v spans lines or has surrounding spacing: %q", src, tok, s)
+			}
+			end = tok.End
+		}
+	}
+}
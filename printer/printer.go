@@ -4,6 +4,7 @@ package printer
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"strings"
@@ -46,11 +47,26 @@ func (p Printer) Fprint(w io.Writer, n *node.Node) error {
 	return buf.Flush()
 }
 
+// FprintDocument prints the touch formatted text to the writer as a whole
+// document—non-empty documents end with a newline.
+func (p Printer) FprintDocument(w io.Writer, n *node.Node) error {
+	var b bytes.Buffer
+	if err := p.Fprint(&b, n); err != nil {
+		return err
+	}
+	if b.Len() > 0 && !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+		b.WriteByte('\n')
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
 type printer struct {
 	w          *printerWriter
 	elements   parser.Elements
 	lineLength int
 
+	cell           string   // delimiter that ends table cells (if printing a table cell)
 	prefixes       []string // opened block prefixes
 	lastPrefixLine int      // last line on which a prefix was written
 	line           int      // current line
@@ -59,7 +75,8 @@ type printer struct {
 // print prints the node in its canonical form.
 //
 // Blocks are separated by single lines, except in groups, such as lists or
-// stickies, where they are placed immediately one after another.
+// stickies, where they are placed immediately one after another, and for
+// nested lists, which are placed immediately after the list item text.
 func (p *printer) print(n *node.Node) error {
 	if n.Type == node.TypeError {
 		return fmt.Errorf("error node (%s)", n)
@@ -70,6 +87,9 @@ func (p *printer) print(n *node.Node) error {
 			if n.Parent != nil && isGroup(n.Parent) {
 				// is in a group like list or sticky
 				p.newline()
+			} else if isNestedList(n) {
+				// keep the list tight
+				p.newline()
 			} else {
 				p.newline()
 				p.writePrefix(withoutTrailingSpacing)
@@ -247,6 +267,11 @@ func (p *printer) print(n *node.Node) error {
 		}
 		p.w.WriteString(e.Delimiter)
 
+	case node.TypeTable:
+		if err := p.printTable(n, e.Delimiter); err != nil {
+			return err
+		}
+
 	case node.TypeLeaf:
 		if p.needBlockEscape(n) {
 			p.w.WriteByte('\\')
@@ -347,6 +372,144 @@ func (p *printer) print(n *node.Node) error {
 	return nil
 }
 
+// printTable prints the table rows with the columns aligned:
+// 	| a   |   b |
+// 	| :-- | --: |
+// 	| c   |   d |
+//
+// A separator line follows the header rows. Without header rows, there is no
+// separator and thus no alignment.
+func (p *printer) printTable(n *node.Node, delimiter string) error {
+	var rows [][]tableCell
+	header := -1 // index of the last header row
+	for r := n.FirstChild; r != nil; r = r.NextSibling {
+		if h, _ := r.Data[parser.KeyHeader].(bool); h {
+			header = len(rows)
+		}
+
+		var row []tableCell
+		for c := r.FirstChild; c != nil; c = c.NextSibling {
+			text, err := p.cellText(c, delimiter)
+			if err != nil {
+				return err
+			}
+			a, _ := c.Data[parser.KeyAlign].(string)
+			row = append(row, tableCell{text, a})
+		}
+
+		if len(rows) > 0 && (header < 0 || len(rows) <= header) && isTableSeparatorRow(row) {
+			// would be mistaken for the separator
+			for i := range row {
+				row[i].text = `\` + row[i].text
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	var widths []int
+	var align []string
+	for _, row := range rows {
+		for i, c := range row {
+			if i == len(widths) {
+				widths = append(widths, 3) // at least the separator "---"
+				align = append(align, "")
+			}
+			if w := utf8.RuneCountInString(c.text); w > widths[i] {
+				widths[i] = w
+			}
+			if c.align != "" {
+				align[i] = c.align
+			}
+		}
+	}
+
+	writeSeparator := func() {
+		p.w.WriteString(delimiter)
+		for i, w := range widths {
+			var s string
+			switch align[i] {
+			case "left":
+				s = ":" + strings.Repeat("-", w-1)
+			case "center":
+				s = ":" + strings.Repeat("-", w-2) + ":"
+			case "right":
+				s = strings.Repeat("-", w-1) + ":"
+			default:
+				s = strings.Repeat("-", w)
+			}
+			p.w.WriteString(" " + s + " " + delimiter)
+		}
+	}
+
+	for i, row := range rows {
+		if i > 0 {
+			p.newline()
+			p.writePrefix(withTrailingSpacing)
+		}
+
+		p.w.WriteString(delimiter)
+		for j, c := range row {
+			pad := widths[j] - utf8.RuneCountInString(c.text)
+			var left int
+			switch align[j] {
+			case "right":
+				left = pad
+			case "center":
+				left = pad / 2
+			}
+			p.w.WriteString(" " + strings.Repeat(" ", left) + c.text +
+				strings.Repeat(" ", pad-left) + " " + delimiter)
+		}
+
+		if i == header {
+			p.newline()
+			p.writePrefix(withTrailingSpacing)
+			writeSeparator()
+		}
+	}
+	return nil
+}
+
+// tableCell is a printed table cell.
+type tableCell struct {
+	text  string
+	align string
+}
+
+// cellText returns the cell printed on a single line with the cell delimiters
+// escaped.
+func (p printer) cellText(n *node.Node, delimiter string) (string, error) {
+	var b strings.Builder
+	pp := &printer{
+		w: &printerWriter{
+			w: &b,
+		},
+		elements: p.elements,
+		cell:     delimiter,
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if err := pp.print(c); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// isTableSeparatorRow reports whether all cells of the row consist of dashes
+// with optional colons at each end and would thus be parsed as a separator.
+func isTableSeparatorRow(row []tableCell) bool {
+	if len(row) == 0 {
+		return false
+	}
+	for _, c := range row {
+		s := strings.TrimPrefix(strings.TrimSuffix(c.text, ":"), ":")
+		if s == "" || strings.Trim(s, "-") != "" {
+			return false
+		}
+	}
+	return true
+}
+
 // screenPos prints the given node and returns the line and screen column on
 // which the printer finished. It is used to determine whether the given node
 // needs to be wrapped.
@@ -383,6 +546,31 @@ func isInlineContainer(n *node.Node) bool {
 	return false
 }
 
+// isNestedList reports whether n is a list placed directly after the text of
+// a list item.
+func isNestedList(n *node.Node) bool {
+	if !isGroup(n) || n.FirstChild == nil || n.FirstChild.Type != node.TypeHanging {
+		return false
+	}
+	prev := n.PreviousSibling
+	if prev == nil || prev.PreviousSibling != nil {
+		return false
+	}
+	if isGroup(prev) && prev.FirstChild != nil && prev.FirstChild == prev.LastChild {
+		// paragraph
+		prev = prev.FirstChild
+	}
+	if prev.Type != node.TypeLeaf {
+		return false
+	}
+	item := n.Parent
+	if item != nil && item.Type == node.TypeContainer && item.Element == "" {
+		// hanging content container
+		item = item.Parent
+	}
+	return item != nil && item.Type == node.TypeHanging && item.Parent != nil && isGroup(item.Parent)
+}
+
 func hasDirectPreviousSibling(n *node.Node) bool {
 	if hasPreviousSibling(n) {
 		if n.Parent != nil && isGroup(n.Parent) && !isFirstChild(n) {
@@ -578,7 +766,7 @@ func (p *printer) writeText(n *node.Node) {
 				// undefined line length
 				if buf.Len() > 0 {
 					s := buf.String()
-					if !containsOnlyPunct(s) {
+					if sep > 0 || !containsOnlyPunct(s) {
 						if prependSpace {
 							p.w.WriteByte(' ')
 						} else if sep > 0 { // cannot be '\n' as we catch it here and set sep=0
@@ -602,7 +790,7 @@ func (p *printer) writeText(n *node.Node) {
 
 			if buf.Len() > 0 {
 				s := buf.String()
-				if !containsOnlyPunct(s) {
+				if sep > 0 || !containsOnlyPunct(s) {
 					if prependSpace {
 						if p.lineLength > 0 {
 							// defined line length
@@ -653,6 +841,9 @@ func (p *printer) writeText(n *node.Node) {
 		} else if p.hasInlineDelimiterPrefix(v[i:]) || p.hasClosingDelimiterPrefix(n, v[i:]) {
 			// E: escape inline delimiter
 			escape = true
+		} else if p.cell != "" && strings.HasPrefix(v[i:], p.cell) {
+			// G: escape table cell delimiter
+			escape = true
 		} else if i == len(v)-1 && n.NextSibling != nil {
 			// F: last character and the following non-empty
 			// element's delimiter's first character may form an
@@ -703,7 +894,7 @@ func (p *printer) writeText(n *node.Node) {
 	}
 	if buf.Len() > 0 {
 		s := buf.String()
-		if !containsOnlyPunct(s) {
+		if sep > 0 || !containsOnlyPunct(s) {
 			if prependSpace {
 				if buf.Len() == 0 {
 					// must be something buffered as v != "" and a separator has not
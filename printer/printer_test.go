@@ -43,6 +43,11 @@ func TestText(t *testing.T) {
 
 		{"a **", "a ****"},
 
+		// punctuation words
+		{"a & b", "a & b"},
+		{"a # b\n! c", "a # b\n! c"},
+		{"**a**, b", "**a**, b"},
+
 		// interrupted by empty blocks
 		{"a\n>\n*\nb", "a\n\n>\n\n*\n\nb"},
 		{"a\n>b\n*\nc", "a\n\n> b\n\n*\n\nc"},
@@ -348,6 +353,69 @@ func TestFenced(t *testing.T) {
 	}
 }
 
+func TestTable(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"|", "|"},
+		{"|a", "| a   |"},
+		{"|a|", "| a   |"},
+		{"| a | b |", "| a   | b   |"},
+		{"| abcd | b |\n| c | d |", "| abcd | b   |\n| c    | d   |"},
+
+		// padding
+		{"| a | b |\n| c |", "| a   | b   |\n| c   |     |"},
+		{"| a |\n|\n| b |", "| a   |\n|     |\n| b   |"},
+
+		// header and alignment
+		{"| a |\n|-|", "| a   |\n| --- |"},
+		{"| a | b | c |\n|:-|:-:|-:|\n| d | e | f |", "| a   |  b  |   c |\n| :-- | :-: | --: |\n| d   |  e  |   f |"},
+		{"| abcd |\n|:-:|\n| e |", "| abcd |\n| :--: |\n|  e   |"},
+		{"| a |\n| b |\n|---|\n| c |", "| a   |\n| b   |\n| --- |\n| c   |"},
+		{"| a |\n|---|\n|---|", "| a   |\n| --- |\n| --- |"},
+
+		// inlines
+		{"| **a** | ``b|c`` |", "| **a** | ``b|c`` |"},
+
+		// escape
+		{"| a \\| b |", "| a \\| b |"},
+		{"| a |\n| \\- |\n|---|", "| a   |\n| \\-  |\n| --- |"},
+		{"| \\--- |\n| \\:-: |", "| ---  |\n| \\:-: |"},
+		{"\\| a", "\\| a"},
+
+		// nested
+		{">| a |\n>| b |", "> | a   |\n> | b   |"},
+		{"| a |\n\n| b |", "| a   |\n\n| b   |"},
+		{"| a |\nb", "| a   |\n\nb"},
+	}
+
+	elements := config.Elements{
+		"A": {
+			Type:      node.TypeTable.String(),
+			Delimiter: "|",
+		},
+		"B": {
+			Type:      node.TypeWalled.String(),
+			Delimiter: ">",
+		},
+		"MA": {
+			Type:      node.TypeUniform.String(),
+			Delimiter: "*",
+		},
+		"MB": {
+			Type:      node.TypeEscaped.String(),
+			Delimiter: "`",
+		},
+	}
+	for _, c := range cases {
+		name := fmt.Sprintf("%q", c.in)
+		t.Run(name, func(t *testing.T) {
+			test(t, elements, nil, c.in, c.out, 0)
+		})
+	}
+}
+
 func TestGroup(t *testing.T) {
 	t.Run("paragraph", func(t *testing.T) {
 		cases := []struct {
@@ -398,6 +466,10 @@ func TestGroup(t *testing.T) {
 			// nested
 			{"-a\n-", "- a\n-"},
 			{"-a\n-b", "- a\n- b"},
+			{"-a\n -b\n -c", "- a\n  - b\n  - c"},
+			{"-a\n\n -b", "- a\n  - b"},
+			{"-a\n -b\n\n c", "- a\n  - b\n\n  c"},
+			{"-a\n >b\n -c", "- a\n\n  > b\n\n  - c"},
 
 			// interrupted by empty blocks
 			{"-a\n>\n-b", "- a\n\n>\n\n- b"},
@@ -1251,6 +1323,50 @@ func TestLineLength(t *testing.T) {
 	}
 }
 
+func TestFprintDocument(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"", ""},
+		{"a", "a\n"},
+		{"a\n", "a\n"},
+		{">a\n>b\n\n", "> a\n> b\n"},
+	}
+
+	elements := config.Elements{
+		"A": {
+			Type:      node.TypeWalled.String(),
+			Delimiter: ">",
+		},
+		"T": {
+			Type: node.TypeLeaf.String(),
+		},
+		"MT": {
+			Type: node.TypeText.String(),
+		},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%q", c.in), func(t *testing.T) {
+			p := parser.Parser{
+				Elements: elements.ParserElements(),
+				TabWidth: 8,
+			}
+			root, err := p.Parse(nil, []byte(c.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var b strings.Builder
+			if err := (printer.Printer{Elements: elements.ParserElements()}).FprintDocument(&b, root); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != c.out {
+				t.Errorf("got %q, want %q", got, c.out)
+			}
+		})
+	}
+}
+
 func test(t *testing.T, elements config.Elements, transformers []transformer.Transformer, in, out string, lineLength int) {
 	t.Helper()
 
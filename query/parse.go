@@ -0,0 +1,338 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// selParser holds the selector parser state.
+type selParser struct {
+	src string
+	pos int
+}
+
+func (p *selParser) errorf(format string, a ...interface{}) error {
+	return &Error{
+		Offset:  p.pos,
+		Message: fmt.Sprintf(format, a...),
+	}
+}
+
+func (p *selParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *selParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// skipSpace skips spacing and reports whether any was skipped.
+func (p *selParser) skipSpace() bool {
+	start := p.pos
+	for !p.eof() && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t' || p.src[p.pos] == '\n') {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+// parseList parses a comma-separated list of complex selectors until the end
+// of the source or the given closing byte (not consumed). Complex selectors in
+// relative lists may start with a combinator.
+func (p *selParser) parseList(closing byte, relative bool) ([]complexSelector, error) {
+	var list []complexSelector
+	for {
+		p.skipSpace()
+		c, err := p.parseComplex(closing, relative)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+		p.skipSpace()
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+	switch {
+	case closing == 0 && !p.eof():
+		return nil, p.errorf("unexpected %q", p.peek())
+	case closing != 0 && p.eof():
+		return nil, p.errorf("missing %q", closing)
+	case closing != 0 && p.peek() != closing:
+		return nil, p.errorf("unexpected %q", p.peek())
+	}
+	return list, nil
+}
+
+func (p *selParser) parseComplex(closing byte, relative bool) (complexSelector, error) {
+	var c complexSelector
+	if relative && p.peek() == '>' {
+		p.pos++
+		p.skipSpace()
+		c.child = true
+	}
+	for {
+		cp, err := p.parseCompound()
+		if err != nil {
+			return c, err
+		}
+		c.compounds = append(c.compounds, cp)
+
+		space := p.skipSpace()
+		switch ch := p.peek(); {
+		case ch == '>':
+			p.pos++
+			p.skipSpace()
+			c.combinators = append(c.combinators, combinatorChild)
+		case ch == ',' || ch == closing || p.eof():
+			return c, nil
+		case space:
+			c.combinators = append(c.combinators, combinatorDescendant)
+		default:
+			return c, p.errorf("unexpected %q", ch)
+		}
+	}
+}
+
+func (p *selParser) parseCompound() (compound, error) {
+	var c compound
+	start := p.pos
+	if p.peek() == '*' {
+		p.pos++
+	} else {
+		c.element = p.parseName()
+	}
+	for {
+		switch p.peek() {
+		case '[':
+			a, err := p.parseAttribute()
+			if err != nil {
+				return c, err
+			}
+			c.attributes = append(c.attributes, a)
+		case ':':
+			ps, err := p.parsePseudo()
+			if err != nil {
+				return c, err
+			}
+			c.pseudos = append(c.pseudos, ps)
+		default:
+			if p.pos == start {
+				if p.eof() {
+					return c, p.errorf("missing selector")
+				}
+				return c, p.errorf("unexpected %q", p.peek())
+			}
+			return c, nil
+		}
+	}
+}
+
+// parseName parses an element name, attribute key, or pseudo-class name:
+// letters, digits, underscores, and hyphens.
+func (p *selParser) parseName() string {
+	start := p.pos
+	for !p.eof() {
+		r, w := utf8.DecodeRuneInString(p.src[p.pos:])
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' {
+			break
+		}
+		p.pos += w
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *selParser) parseAttribute() (attribute, error) {
+	p.pos++ // [
+	p.skipSpace()
+	var a attribute
+	a.key = p.parseName()
+	if a.key == "" {
+		return a, p.errorf("missing attribute name")
+	}
+	p.skipSpace()
+	for _, op := range []string{"=", "!=", "^=", "$=", "*="} {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			a.op = op
+			p.pos += len(op)
+			break
+		}
+	}
+	if a.op != "" {
+		p.skipSpace()
+		v, err := p.parseValue(']')
+		if err != nil {
+			return a, err
+		}
+		a.value = v
+		p.skipSpace()
+	}
+	if p.peek() != ']' {
+		if p.eof() {
+			return a, p.errorf("missing ']'")
+		}
+		return a, p.errorf("unexpected %q in attribute", p.peek())
+	}
+	p.pos++
+	return a, nil
+}
+
+// parseValue parses a quoted string or a bare value that ends with spacing or
+// the closing byte.
+func (p *selParser) parseValue(closing byte) (string, error) {
+	if q := p.peek(); q == '"' || q == '\'' {
+		return p.parseString()
+	}
+	start := p.pos
+	for !p.eof() && p.src[p.pos] != closing && p.src[p.pos] != ' ' && p.src[p.pos] != '\t' {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("missing value")
+	}
+	return p.src[start:p.pos], nil
+}
+
+// parseString parses a quoted string; a backslash escapes the next character.
+func (p *selParser) parseString() (string, error) {
+	q := p.src[p.pos]
+	p.pos++
+	var b strings.Builder
+	for !p.eof() {
+		ch := p.src[p.pos]
+		switch {
+		case ch == q:
+			p.pos++
+			return b.String(), nil
+		case ch == '\\' && p.pos+1 < len(p.src):
+			b.WriteByte(p.src[p.pos+1])
+			p.pos += 2
+		default:
+			b.WriteByte(ch)
+			p.pos++
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *selParser) parsePseudo() (pseudo, error) {
+	p.pos++ // :
+	start := p.pos
+	ps := pseudo{name: p.parseName()}
+	switch ps.name {
+	case "first-child":
+		ps.a, ps.b = 0, 1
+		ps.name = "nth-child"
+		return ps, nil
+	case "last-child":
+		ps.a, ps.b = 0, 1
+		ps.name = "nth-last-child"
+		return ps, nil
+	case "has", "not", "is", "nth-child", "nth-last-child", "contains", "type":
+	case "":
+		return ps, p.errorf("missing pseudo-class name")
+	default:
+		p.pos = start
+		return ps, p.errorf("unknown pseudo-class %q", ps.name)
+	}
+
+	if p.peek() != '(' {
+		return ps, p.errorf("missing argument of :%s", ps.name)
+	}
+	p.pos++
+	p.skipSpace()
+	switch ps.name {
+	case "has", "not", "is":
+		list, err := p.parseList(')', ps.name == "has")
+		if err != nil {
+			return ps, err
+		}
+		ps.selectors = list
+	case "nth-child", "nth-last-child":
+		argStart := p.pos
+		for !p.eof() && p.peek() != ')' {
+			p.pos++
+		}
+		a, b, err := parseNth(strings.TrimSpace(p.src[argStart:p.pos]))
+		if err != nil {
+			p.pos = argStart
+			return ps, p.errorf("%v", err)
+		}
+		ps.a, ps.b = a, b
+	case "contains", "type":
+		argStart := p.pos
+		v, err := p.parseValue(')')
+		if err != nil {
+			return ps, err
+		}
+		if ps.name == "type" {
+			if err := ps.typ.UnmarshalText([]byte(v)); err != nil {
+				p.pos = argStart
+				return ps, p.errorf("unknown node type %q", v)
+			}
+		}
+		ps.arg = v
+		p.skipSpace()
+	}
+	if p.peek() != ')' {
+		if p.eof() {
+			return ps, p.errorf("missing ')'")
+		}
+		return ps, p.errorf("unexpected %q", p.peek())
+	}
+	p.pos++
+	return ps, nil
+}
+
+// parseNth parses the An+B argument of :nth-child: "odd", "even", "3", "n",
+// "2n+1", "-n+3", ...
+func parseNth(s string) (int, int, error) {
+	s = strings.ReplaceAll(strings.ToLower(s), " ", "")
+	switch s {
+	case "odd":
+		return 2, 1, nil
+	case "even":
+		return 2, 0, nil
+	case "":
+		return 0, 0, fmt.Errorf("missing nth-child argument")
+	}
+
+	i := strings.IndexByte(s, 'n')
+	if i < 0 {
+		b, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid nth-child argument %q", s)
+		}
+		return 0, b, nil
+	}
+
+	var a int
+	switch as := s[:i]; as {
+	case "", "+":
+		a = 1
+	case "-":
+		a = -1
+	default:
+		var err error
+		if a, err = strconv.Atoi(as); err != nil {
+			return 0, 0, fmt.Errorf("invalid nth-child argument %q", s)
+		}
+	}
+	var b int
+	if bs := s[i+1:]; bs != "" {
+		if bs[0] != '+' && bs[0] != '-' {
+			return 0, 0, fmt.Errorf("invalid nth-child argument %q", s)
+		}
+		var err error
+		if b, err = strconv.Atoi(bs); err != nil {
+			return 0, 0, fmt.Errorf("invalid nth-child argument %q", s)
+		}
+	}
+	return a, b, nil
+}
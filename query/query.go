@@ -0,0 +1,342 @@
+// Package query provides CSS-like selectors for finding elements in node trees.
+//
+// A selector is a comma-separated list of complex selectors. A complex
+// selector is a sequence of compound selectors joined by combinators:
+// 	A B      B that is a descendant of A
+// 	A > B    B that is a child of A
+// A compound selector is an element name or * (any element) followed by any
+// number of attribute selectors and pseudo-classes:
+// 	[key]           node.Data has the key
+// 	[key=value]     the value of the key is value
+// 	[key!=value]    the key is not set or its value is not value
+// 	[key^=value]    the value of the key starts with value
+// 	[key$=value]    the value of the key ends with value
+// 	[key*=value]    the value of the key contains value
+// 	:has(S)         any descendant matches S; :has(> S) any child matches S
+// 	:not(S)         the element does not match S
+// 	:is(S)          the element matches S
+// 	:nth-child(An+B), :nth-last-child(An+B), :first-child, :last-child
+// 	                the position among the sibling elements (one-based)
+// 	:contains(text) the text content contains text
+// 	:type(T)        the node type is T, e.g. Fenced
+// Values are compared to the data values formatted with fmt.Sprint. Values
+// with spacing or special characters are quoted with " or '.
+//
+// Only elements are selected; the unnamed nodes, like the containers of the
+// table rows, are skipped when determining the parent, children, and siblings
+// of an element. For example:
+// 	CodeBlock[openingText=go]
+// 	Note Link
+// 	List > ListItem:first-child
+// 	Blockquote:has(> Heading[rank=2]:contains(Install))
+package query
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/touchmarine/to/node"
+)
+
+// Selector is a compiled selector.
+type Selector struct {
+	src  string
+	list []complexSelector
+}
+
+// Compile parses a selector and returns, if successful, a Selector that can be
+// used to match against nodes.
+func Compile(s string) (*Selector, error) {
+	p := &selParser{src: s}
+	list, err := p.parseList(0, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Selector{src: s, list: list}, nil
+}
+
+// MustCompile is like Compile but panics if the selector cannot be parsed.
+func MustCompile(s string) *Selector {
+	sel, err := Compile(s)
+	if err != nil {
+		panic(fmt.Sprintf("query: Compile(%q): %v", s, err))
+	}
+	return sel
+}
+
+// String returns the source text used to compile the selector.
+func (s *Selector) String() string {
+	return s.src
+}
+
+// Match reports whether the node matches the selector. Nodes that are not
+// elements never match.
+func (s *Selector) Match(n *node.Node) bool {
+	return matchList(s.list, n, nil)
+}
+
+// All returns the descendants of the root that match the selector in document
+// order.
+func (s *Selector) All(root *node.Node) []*node.Node {
+	var nodes []*node.Node
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, func(n *node.Node) bool {
+			if s.Match(n) {
+				nodes = append(nodes, n)
+			}
+			return true
+		})
+	}
+	return nodes
+}
+
+// First returns the first descendant of the root that matches the selector or
+// nil if there is none.
+func (s *Selector) First(root *node.Node) *node.Node {
+	var first *node.Node
+	for c := root.FirstChild; c != nil && first == nil; c = c.NextSibling {
+		walk(c, func(n *node.Node) bool {
+			if first == nil && s.Match(n) {
+				first = n
+			}
+			return first == nil
+		})
+	}
+	return first
+}
+
+// Error describes a problem with a selector.
+type Error struct {
+	Offset  int // byte offset in the selector (zero-based)
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Offset+1, e.Message)
+}
+
+type combinator int
+
+const (
+	combinatorDescendant combinator = iota // A B
+	combinatorChild                        // A > B
+)
+
+// complexSelector is a sequence of compounds joined by combinators;
+// combinators[i] is between compounds[i] and compounds[i+1].
+type complexSelector struct {
+	compounds   []compound
+	combinators []combinator
+
+	// child is set in relative selectors (in :has) that start with >
+	child bool
+}
+
+type compound struct {
+	element    string // blank if any element
+	attributes []attribute
+	pseudos    []pseudo
+}
+
+type attribute struct {
+	key   string
+	op    string // blank if only the presence is tested
+	value string
+}
+
+type pseudo struct {
+	name      string
+	selectors []complexSelector // :has, :not, :is
+	a, b      int               // :nth-child, :nth-last-child
+	arg       string            // :contains, :type
+	typ       node.Type         // :type
+}
+
+// matchList reports whether n matches any of the selectors. If scope is not
+// nil, the selectors are relative to it (see complexSelector.match).
+func matchList(list []complexSelector, n, scope *node.Node) bool {
+	for _, c := range list {
+		if c.match(n, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether n matches the complex selector. If scope is not nil,
+// the element matched by the first compound must be a descendant of the scope
+// (or a child if c.child is set).
+func (c complexSelector) match(n, scope *node.Node) bool {
+	return c.matchAt(n, len(c.compounds)-1, scope)
+}
+
+func (c complexSelector) matchAt(n *node.Node, i int, scope *node.Node) bool {
+	if !c.compounds[i].match(n) {
+		return false
+	}
+	if i == 0 {
+		if scope == nil {
+			return true
+		}
+		if c.child {
+			return elementParent(n) == scope
+		}
+		return isDescendant(n, scope)
+	}
+
+	switch c.combinators[i-1] {
+	case combinatorChild:
+		p := elementParent(n)
+		return p != nil && c.matchAt(p, i-1, scope)
+	case combinatorDescendant:
+		for p := elementParent(n); p != nil; p = elementParent(p) {
+			if c.matchAt(p, i-1, scope) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c compound) match(n *node.Node) bool {
+	if n.Element == "" || c.element != "" && c.element != n.Element {
+		return false
+	}
+	for _, a := range c.attributes {
+		if !a.match(n) {
+			return false
+		}
+	}
+	for _, ps := range c.pseudos {
+		if !ps.match(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a attribute) match(n *node.Node) bool {
+	v, ok := n.Data[a.key]
+	if a.op == "" {
+		return ok
+	}
+	if !ok {
+		return a.op == "!="
+	}
+	s := fmt.Sprint(v)
+	switch a.op {
+	case "=":
+		return s == a.value
+	case "!=":
+		return s != a.value
+	case "^=":
+		return strings.HasPrefix(s, a.value)
+	case "$=":
+		return strings.HasSuffix(s, a.value)
+	case "*=":
+		return strings.Contains(s, a.value)
+	}
+	return false
+}
+
+func (ps pseudo) match(n *node.Node) bool {
+	switch ps.name {
+	case "has":
+		found := false
+		for c := n.FirstChild; c != nil && !found; c = c.NextSibling {
+			walk(c, func(d *node.Node) bool {
+				if matchList(ps.selectors, d, n) {
+					found = true
+				}
+				return !found
+			})
+		}
+		return found
+	case "not":
+		return !matchList(ps.selectors, n, nil)
+	case "is":
+		return matchList(ps.selectors, n, nil)
+	case "nth-child", "nth-last-child":
+		siblings := elementSiblings(n)
+		for i, s := range siblings {
+			if s != n {
+				continue
+			}
+			pos := i + 1
+			if ps.name == "nth-last-child" {
+				pos = len(siblings) - i
+			}
+			return nth(ps.a, ps.b, pos)
+		}
+		return false
+	case "contains":
+		return strings.Contains(n.TextContent(), ps.arg)
+	case "type":
+		return n.Type == ps.typ
+	}
+	return false
+}
+
+// nth reports whether pos is a*k+b for some k >= 0.
+func nth(a, b, pos int) bool {
+	if a == 0 {
+		return pos == b
+	}
+	d := pos - b
+	return d%a == 0 && d/a >= 0
+}
+
+// elementParent returns the nearest ancestor that is an element or nil if
+// there is none.
+func elementParent(n *node.Node) *node.Node {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Element != "" {
+			return p
+		}
+	}
+	return nil
+}
+
+// elementSiblings returns the element children of the element parent of n
+// (or of the tree root if n has no element parent), n included.
+func elementSiblings(n *node.Node) []*node.Node {
+	p := elementParent(n)
+	if p == nil {
+		p = n
+		for p.Parent != nil {
+			p = p.Parent
+		}
+	}
+	return elementChildren(p)
+}
+
+// elementChildren returns the element children of n; the children of unnamed
+// nodes are included in their place.
+func elementChildren(n *node.Node) []*node.Node {
+	var nodes []*node.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Element != "" {
+			nodes = append(nodes, c)
+		} else {
+			nodes = append(nodes, elementChildren(c)...)
+		}
+	}
+	return nodes
+}
+
+func isDescendant(n, ancestor *node.Node) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+func walk(n *node.Node, fn func(n *node.Node) bool) {
+	if fn(n) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, fn)
+		}
+	}
+}
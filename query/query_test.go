@@ -0,0 +1,156 @@
+package query_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/query"
+)
+
+const doc = `== Install
+> Run **go get**.
+> - **one**
+> - two
+> - three
+
+== Usage
+` + "`go\nfmt.Println()\n`" + `
+` + "`sh\nto build\n`" + `
+- **four**
+`
+
+func TestAll(t *testing.T) {
+	cases := []struct {
+		selector string
+		out      []string // Element@line of the matches
+	}{
+		{"H", []string{"H@1", "H@7"}},
+		{"*:type(Fenced)", []string{"C@8", "C@11"}},
+		{"C[openingText=go]", []string{"C@8"}},
+		{"C[openingText!=go]", []string{"C@11"}},
+		{"C[openingText^=s]", []string{"C@11"}},
+		{"C[openingText$=o]", []string{"C@8"}},
+		{"C[openingText*=h]", []string{"C@11"}},
+		{"H[rank]", []string{"H@1", "H@7"}},
+		{"H[rank=2]", []string{"H@1", "H@7"}},
+		{"H[rank=3]", nil},
+		{"B M", []string{"M@2", "M@3"}},
+		{"B > M", nil},
+		{"B > L > T > M", []string{"M@3"}},
+		{"B > L M", []string{"M@3"}},
+		{"B L", []string{"L@3", "L@4", "L@5"}},
+		{"L > M", nil},
+		{"L > T > M", []string{"M@3", "M@14"}},
+		{"B L:first-child", nil},
+		{"B T:first-child", []string{"T@2", "T@3", "T@4", "T@5"}},
+		{"B > T:first-child", []string{"T@2"}},
+		{"L:last-child", []string{"L@5", "L@14"}},
+		{"L:nth-child(3)", []string{"L@4"}},
+		{"L:nth-child(odd)", []string{"L@4"}},
+		{"L:nth-child(even)", []string{"L@3", "L@5", "L@14"}},
+		{"L:nth-child(-n+3)", []string{"L@3", "L@4"}},
+		{"L:nth-child(n+6)", []string{"L@14"}},
+		{"L:nth-last-child(1)", []string{"L@5", "L@14"}},
+		{"H:first-child", []string{"H@1"}},
+		{"B:has(M)", []string{"B@2"}},
+		{"B:has(> M)", nil},
+		{"B:has(> L M)", []string{"B@2"}},
+		{"B:has(> L > T > M)", []string{"B@2"}},
+		{"B:has(> H, L M)", []string{"B@2"}},
+		{"B:has(B M)", nil},
+		{"*:has(> M)", []string{"T@2", "T@3", "T@14"}},
+		{"L:not(:has(M))", []string{"L@4", "L@5"}},
+		{"L:is(:first-child, :last-child)", []string{"L@5", "L@14"}},
+		{"H:contains(Usa)", []string{"H@7"}},
+		{"H:contains('In')", []string{"H@1"}},
+		{"H, C", []string{"H@1", "H@7", "C@8", "C@11"}},
+		{"X", nil},
+	}
+
+	p := parser.Parser{
+		Elements: parser.Elements{
+			"H":  {Name: "H", Type: node.TypeRankedHanging, Delimiter: "="},
+			"B":  {Name: "B", Type: node.TypeWalled, Delimiter: ">"},
+			"L":  {Name: "L", Type: node.TypeHanging, Delimiter: "-"},
+			"C":  {Name: "C", Type: node.TypeFenced, Delimiter: "`"},
+			"M":  {Name: "M", Type: node.TypeUniform, Delimiter: "*"},
+			"T":  {Name: "T", Type: node.TypeLeaf},
+			"MT": {Name: "MT", Type: node.TypeText},
+		},
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+	}
+	root, err := p.Parse(nil, []byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		t.Run(c.selector, func(t *testing.T) {
+			sel, err := query.Compile(c.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, n := range sel.All(root) {
+				got = append(got, fmt.Sprintf("%s@%d", n.Element, n.Location.Range.Start.Line+1))
+			}
+			if g, w := strings.Join(got, " "), strings.Join(c.out, " "); g != w {
+				t.Errorf("got %q, want %q", g, w)
+			}
+
+			first := sel.First(root)
+			switch {
+			case len(c.out) == 0 && first != nil:
+				t.Errorf("First: got %s, want nil", first)
+			case len(c.out) > 0 && (first == nil || !sel.Match(first)):
+				t.Errorf("First: got %v", first)
+			}
+		})
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	cases := []struct {
+		selector string
+		err      string
+	}{
+		{"", "column 1: missing selector"},
+		{"A,", "column 3: missing selector"},
+		{"A >", "column 4: missing selector"},
+		{"A)", "column 2: unexpected ')'"},
+		{"A[", "column 3: missing attribute name"},
+		{"A[b", "column 4: missing ']'"},
+		{"A[b=]", "column 5: missing value"},
+		{"A[b=\"c]", "column 8: unterminated string"},
+		{"A:foo", "column 3: unknown pseudo-class \"foo\""},
+		{"A:has", "column 6: missing argument of :has"},
+		{"A:has(B", "column 8: missing ')'"},
+		{"A:not(> B)", "column 7: unexpected '>'"},
+		{"A:nth-child(x)", "column 13: invalid nth-child argument \"x\""},
+		{"A:nth-child()", "column 13: missing nth-child argument"},
+		{"A:type(foo)", "column 8: unknown node type \"foo\""},
+	}
+	for _, c := range cases {
+		t.Run(c.selector, func(t *testing.T) {
+			_, err := query.Compile(c.selector)
+			if err == nil {
+				t.Fatal("got nil error")
+			}
+			if err.Error() != c.err {
+				t.Errorf("got %q, want %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	const s = "A > B:has(C)"
+	if got := query.MustCompile(s).String(); got != s {
+		t.Errorf("got %q, want %q", got, s)
+	}
+}
@@ -0,0 +1,105 @@
+package registry
+
+import (
+	"fmt"
+
+	"github.com/touchmarine/to/aggregator"
+	footnoteaggregator "github.com/touchmarine/to/aggregator/footnote"
+	seqnumaggregator "github.com/touchmarine/to/aggregator/sequentialnumber"
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/matcher/url"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/transformer"
+	"github.com/touchmarine/to/transformer/footnote"
+	"github.com/touchmarine/to/transformer/group"
+	"github.com/touchmarine/to/transformer/label"
+	"github.com/touchmarine/to/transformer/metadata"
+	"github.com/touchmarine/to/transformer/paragraph"
+	"github.com/touchmarine/to/transformer/sticky"
+)
+
+func init() {
+	RegisterTransformer(config.TypeMetadata, newMetadata)
+	RegisterTransformer(config.TypeParagraph, newParagraph)
+	RegisterTransformer(config.TypeList, newList)
+	RegisterTransformer(config.TypeSticky, newSticky)
+	RegisterTransformer(config.TypeFootnote, newFootnote) // after sticky (definitions are stickies)
+	RegisterTransformer(config.TypeLabel, newLabel)       // after sticky (ids are in stickies)
+	RegisterAggregator("sequentialNumber", newSequentialNumber)
+	RegisterAggregator("footnote", newFootnoteAggregator)
+	RegisterMatcher("url", func() (matcher.Matcher, error) {
+		return matcher.MatcherFunc(url.Match), nil
+	})
+}
+
+func newMetadata(elements map[string]config.Element) (transformer.Transformer, error) {
+	m := metadata.Map{}
+	for n, e := range elements {
+		m[n] = e.Element
+	}
+	return metadata.Transformer{m}, nil
+}
+
+func newParagraph(elements map[string]config.Element) (transformer.Transformer, error) {
+	m := paragraph.Map{}
+	for n, e := range elements {
+		var t node.Type
+		if err := (&t).UnmarshalText([]byte(e.Option)); err != nil {
+			return nil, fmt.Errorf("element %s: invalid paragraph option %q", n, e.Option)
+		}
+		m[n] = t
+	}
+	return paragraph.Transformer{m}, nil
+}
+
+func newList(elements map[string]config.Element) (transformer.Transformer, error) {
+	m := group.Map{}
+	for n, e := range elements {
+		m[n] = e.Element
+	}
+	return group.Transformer{m}, nil
+}
+
+func newSticky(elements map[string]config.Element) (transformer.Transformer, error) {
+	m := sticky.Map{}
+	for n, e := range elements {
+		m[n] = sticky.Sticky{
+			Element: e.Element,
+			Target:  e.Target,
+			After:   e.Option == "after",
+		}
+	}
+	return sticky.Transformer{m}, nil
+}
+
+func newFootnote(elements map[string]config.Element) (transformer.Transformer, error) {
+	m := footnote.Map{}
+	for n, e := range elements {
+		m[n] = footnote.Footnote{
+			Definition: e.Element,
+			Reference:  e.Target,
+		}
+	}
+	return footnote.Transformer{m}, nil
+}
+
+func newLabel(elements map[string]config.Element) (transformer.Transformer, error) {
+	m := label.Map{}
+	for n, e := range elements {
+		m[n] = label.Label{
+			Element:   e.Element,
+			Reference: e.Target,
+			Kind:      e.Option,
+		}
+	}
+	return label.Transformer{m}, nil
+}
+
+func newSequentialNumber(a config.Aggregate) (aggregator.Aggregator, error) {
+	return seqnumaggregator.Aggregator{a.Elements}, nil
+}
+
+func newFootnoteAggregator(a config.Aggregate) (aggregator.Aggregator, error) {
+	return footnoteaggregator.Aggregator{a.Elements}, nil
+}
@@ -0,0 +1,226 @@
+// Package registry provides a registry of named transformer, aggregator, and
+// matcher factories. The Type of group elements and aggregates and the Matcher
+// of prefixed elements in configs are resolved through the registry.
+//
+// The built-in factories (metadata, paragraph, list, sticky, footnote, and
+// label transformers, the sequentialNumber and footnote aggregators, and the
+// url matcher) are registered by this package. Other packages register their
+// factories in their init functions, so a custom Touch binary can be assembled
+// by importing them:
+// 	package smallcaps
+//
+// 	func init() {
+// 		registry.RegisterTransformer("smallcaps", New)
+// 	}
+//
+// 	func New(elements map[string]config.Element) (transformer.Transformer, error) {
+// 		...
+// 	}
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/touchmarine/to/aggregator"
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/transformer"
+)
+
+// TransformerFactory returns a transformer for the group elements of its type.
+// The elements map the element names to their configs; it is empty if no
+// element has the type. The transformer may be used concurrently.
+type TransformerFactory func(elements map[string]config.Element) (transformer.Transformer, error)
+
+// AggregatorFactory returns an aggregator for the config aggregate. The
+// aggregator may be used concurrently.
+type AggregatorFactory func(a config.Aggregate) (aggregator.Aggregator, error)
+
+// MatcherFactory returns a matcher. Matchers have no config options.
+type MatcherFactory func() (matcher.Matcher, error)
+
+var (
+	mu           sync.RWMutex
+	transformers = map[string]TransformerFactory{}
+	order        []string // transformer names in registration order
+	aggregators  = map[string]AggregatorFactory{}
+	matchers     = map[string]MatcherFactory{}
+)
+
+// RegisterTransformer makes the transformer factory available by the given
+// group element type. Transformers run in the order they were registered.
+//
+// RegisterTransformer panics if it is called twice with the same name, if the
+// name is a node type, or if the factory is nil.
+func RegisterTransformer(name string, f TransformerFactory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if f == nil {
+		panic("registry: nil transformer factory " + name)
+	}
+	var t node.Type
+	if err := (&t).UnmarshalText([]byte(name)); err == nil {
+		panic("registry: transformer name is a node type: " + name)
+	}
+	if _, dup := transformers[name]; dup {
+		panic("registry: RegisterTransformer called twice for " + name)
+	}
+	transformers[name] = f
+	order = append(order, name)
+}
+
+// RegisterAggregator makes the aggregator factory available by the given
+// aggregate type. It panics if it is called twice with the same name or if the
+// factory is nil.
+func RegisterAggregator(name string, f AggregatorFactory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if f == nil {
+		panic("registry: nil aggregator factory " + name)
+	}
+	if _, dup := aggregators[name]; dup {
+		panic("registry: RegisterAggregator called twice for " + name)
+	}
+	aggregators[name] = f
+}
+
+// RegisterMatcher makes the matcher factory available by the given matcher
+// name. It panics if it is called twice with the same name or if the factory
+// is nil.
+func RegisterMatcher(name string, f MatcherFactory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if f == nil {
+		panic("registry: nil matcher factory " + name)
+	}
+	if _, dup := matchers[name]; dup {
+		panic("registry: RegisterMatcher called twice for " + name)
+	}
+	matchers[name] = f
+}
+
+// Types returns the sorted names of the registered factories, as used by
+// config.Config.Validate.
+func Types() config.Types {
+	mu.RLock()
+	defer mu.RUnlock()
+	return config.Types{
+		Groups:     keys(transformers),
+		Aggregates: keys(aggregators),
+		Matchers:   keys(matchers),
+	}
+}
+
+// Transformers returns the transformers of the group elements, in the order
+// the factories were registered. Disabled elements are skipped.
+func Transformers(elements config.Elements) (transformer.Group, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var names []string
+	for n := range elements {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	byType := map[string]map[string]config.Element{}
+	for _, n := range names {
+		e := elements[n]
+		if e.Disabled {
+			continue
+		}
+		var t node.Type
+		if err := (&t).UnmarshalText([]byte(e.Type)); err == nil {
+			// is a node element (can't be a group)
+			continue
+		}
+		if _, ok := transformers[e.Type]; !ok {
+			return nil, fmt.Errorf("element %s: unknown type %q (known: %s)", n, e.Type, strings.Join(keys(transformers), ", "))
+		}
+		if byType[e.Type] == nil {
+			byType[e.Type] = map[string]config.Element{}
+		}
+		byType[e.Type][n] = e
+	}
+
+	var g transformer.Group
+	for _, name := range order {
+		els := byType[name]
+		if els == nil {
+			els = map[string]config.Element{}
+		}
+		t, err := transformers[name](els)
+		if err != nil {
+			return nil, fmt.Errorf("%s transformer: %w", name, err)
+		}
+		g = append(g, t)
+	}
+	return g, nil
+}
+
+// Aggregators returns the aggregators of the config aggregates.
+func Aggregators(aggregates config.Aggregates) (aggregator.Aggregators, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var names []string
+	for n := range aggregates {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	m := aggregator.Aggregators{}
+	for _, n := range names {
+		a := aggregates[n]
+		f, ok := aggregators[a.Type]
+		if !ok {
+			return nil, fmt.Errorf("aggregate %s: unknown type %q (known: %s)", n, a.Type, strings.Join(keys(aggregators), ", "))
+		}
+		ar, err := f(a)
+		if err != nil {
+			return nil, fmt.Errorf("aggregate %s: %w", n, err)
+		}
+		m[n] = ar
+	}
+	return m, nil
+}
+
+// Matchers returns all registered matchers.
+func Matchers() (matcher.Map, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	m := matcher.Map{}
+	for n, f := range matchers {
+		mr, err := f()
+		if err != nil {
+			return nil, fmt.Errorf("matcher %s: %w", n, err)
+		}
+		m[n] = mr
+	}
+	return m, nil
+}
+
+func keys(m interface{}) []string {
+	var a []string
+	switch m := m.(type) {
+	case map[string]TransformerFactory:
+		for k := range m {
+			a = append(a, k)
+		}
+	case map[string]AggregatorFactory:
+		for k := range m {
+			a = append(a, k)
+		}
+	case map[string]MatcherFactory:
+		for k := range m {
+			a = append(a, k)
+		}
+	}
+	sort.Strings(a)
+	return a
+}
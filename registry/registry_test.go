@@ -0,0 +1,149 @@
+package registry_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/aggregator"
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/registry"
+	"github.com/touchmarine/to/transformer"
+)
+
+type count int
+
+func (count) AnAggregate() {}
+
+func init() {
+	registry.RegisterTransformer("test", func(elements map[string]config.Element) (transformer.Transformer, error) {
+		return transformer.Func(func(n *node.Node) *node.Node {
+			for name, e := range elements {
+				n.AppendChild(&node.Node{
+					Element: name,
+					Type:    node.TypeText,
+					Value:   e.Option,
+				})
+			}
+			return n
+		}), nil
+	})
+	registry.RegisterAggregator("test", func(a config.Aggregate) (aggregator.Aggregator, error) {
+		if len(a.Elements) == 0 {
+			return nil, errors.New("no elements")
+		}
+		return aggregator.AggregatorFunc(func(n *node.Node) aggregator.Aggregate {
+			return count(len(a.Elements))
+		}), nil
+	})
+	registry.RegisterMatcher("test", func() (matcher.Matcher, error) {
+		return matcher.MatcherFunc(func(p []byte) int { return len(p) }), nil
+	})
+}
+
+func TestTypes(t *testing.T) {
+	types := registry.Types()
+	cases := []struct {
+		name  string
+		names []string
+		want  []string
+	}{
+		{"Groups", types.Groups, []string{"footnote", "label", "list", "metadata", "paragraph", "sticky", "test"}},
+		{"Aggregates", types.Aggregates, []string{"footnote", "sequentialNumber", "test"}},
+		{"Matchers", types.Matchers, []string{"test", "url"}},
+	}
+	for _, c := range cases {
+		if got, want := strings.Join(c.names, ","), strings.Join(c.want, ","); got != want {
+			t.Errorf("%s: got %s, want %s", c.name, got, want)
+		}
+	}
+}
+
+func TestTransformers(t *testing.T) {
+	g, err := registry.Transformers(config.Elements{
+		"A": {Type: "test", Option: "a"},
+		"B": {Type: "test", Option: "b", Disabled: true},
+		"C": {Type: "text"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g) != 7 {
+		t.Fatalf("got %d transformers, want 7", len(g))
+	}
+	root := g.Transform(&node.Node{Type: node.TypeContainer})
+	if c := root.FirstChild; c == nil || c.Element != "A" || c.Value != "a" || c.NextSibling != nil {
+		t.Errorf("unexpected tree: %+v", c)
+	}
+
+	_, err = registry.Transformers(config.Elements{"A": {Type: "nope"}})
+	if want := `element A: unknown type "nope" (known: footnote, label, list, metadata, paragraph, sticky, test)`; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	_, err = registry.Transformers(config.Elements{"A": {Type: "paragraph", Option: "nope"}})
+	if want := `paragraph transformer: element A: invalid paragraph option "nope"`; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestAggregators(t *testing.T) {
+	m, err := registry.Aggregators(config.Aggregates{
+		"a": {Type: "test", Elements: []string{"A", "B"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m["a"].Aggregate(&node.Node{}); got != count(2) {
+		t.Errorf("got aggregate %v, want 2", got)
+	}
+
+	_, err = registry.Aggregators(config.Aggregates{"a": {Type: "nope"}})
+	if want := `aggregate a: unknown type "nope" (known: footnote, sequentialNumber, test)`; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	_, err = registry.Aggregators(config.Aggregates{"a": {Type: "test"}})
+	if want := `aggregate a: no elements`; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	m, err := registry.Matchers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m["test"].Match([]byte("abc")); got != 3 {
+		t.Errorf("got match %d, want 3", got)
+	}
+	if got := m["url"].Match([]byte("http://a.test b")); got != len("http://a.test") {
+		t.Errorf("got url match %d", got)
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	noop := func(map[string]config.Element) (transformer.Transformer, error) {
+		return transformer.Group{}, nil
+	}
+	cases := []struct {
+		name string
+		f    func()
+		want string
+	}{
+		{"duplicate", func() { registry.RegisterTransformer("test", noop) }, "registry: RegisterTransformer called twice for test"},
+		{"node type", func() { registry.RegisterTransformer("hanging", noop) }, "registry: transformer name is a node type: hanging"},
+		{"nil", func() { registry.RegisterAggregator("nil", nil) }, "registry: nil aggregator factory nil"},
+		{"duplicate matcher", func() { registry.RegisterMatcher("url", func() (matcher.Matcher, error) { return nil, nil }) }, "registry: RegisterMatcher called twice for url"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != c.want {
+					t.Errorf("got panic %v, want %q", r, c.want)
+				}
+			}()
+			c.f()
+		})
+	}
+}
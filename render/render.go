@@ -0,0 +1,277 @@
+// Package render provides the parse, transform, and render pipeline of Touch
+// as a library.
+//
+// A Renderer is built once from a config and then used to render any number of
+// documents, possibly concurrently:
+// 	r, err := render.New(&config.Default, render.Options{})
+// 	if err != nil {
+// 		return err
+// 	}
+// 	if err := r.Render(ctx, src, "html", w); err != nil {
+// 		return err
+// 	}
+package render
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"sync"
+	texttemplate "text/template"
+
+	"github.com/touchmarine/to/aggregator"
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/registry"
+	totemplate "github.com/touchmarine/to/template"
+	"github.com/touchmarine/to/transformer"
+	"github.com/touchmarine/to/transformer/sequentialnumber"
+	"github.com/touchmarine/to/transformer/slug"
+)
+
+// Options holds the optional settings of a Renderer.
+type Options struct {
+	Matchers matcher.Map // available matchers (by name); if nil, the registered ones
+	TabWidth int         // tab=<tabwidth> x spaces (default 8)
+}
+
+// Renderer renders Touch formatted text through the templates of a config.
+// The transformers and aggregators are built when the Renderer is made and the
+// templates of each format are parsed on first use. A Renderer is safe for
+// concurrent use.
+type Renderer struct {
+	cfg         *config.Config
+	parser      parser.Parser
+	transformer transformer.Transformer
+	aggregators aggregator.Aggregators
+
+	mu        sync.Mutex
+	templates map[string]*templates // by format
+}
+
+// templates are the parsed templates of a format. They are never executed,
+// only cloned.
+type templates struct {
+	once sync.Once
+	html *template.Template
+	text *texttemplate.Template
+	err  error
+}
+
+// New returns a Renderer for the config. The config must not be modified
+// afterwards. New resolves the group element and aggregate types through the
+// registry and returns an error if any is unknown.
+func New(cfg *config.Config, opts Options) (*Renderer, error) {
+	t, err := Transformers(cfg.Elements)
+	if err != nil {
+		return nil, err
+	}
+	aggregators, err := registry.Aggregators(cfg.Aggregates)
+	if err != nil {
+		return nil, err
+	}
+	matchers := opts.Matchers
+	if matchers == nil {
+		if matchers, err = registry.Matchers(); err != nil {
+			return nil, err
+		}
+	}
+	tabWidth := opts.TabWidth
+	if tabWidth <= 0 {
+		tabWidth = 8
+	}
+	return &Renderer{
+		cfg: cfg,
+		parser: parser.Parser{
+			Elements: cfg.Elements.ParserElements(),
+			Matchers: matchers,
+			TabWidth: tabWidth,
+		},
+		transformer: t,
+		aggregators: aggregators,
+		templates:   map[string]*templates{},
+	}, nil
+}
+
+// Transformers returns the transformers of the group elements, resolved
+// through the registry, followed by the sequential number and heading slug
+// transformers—all the transformers applied to parsed node trees.
+func Transformers(elements config.Elements) (transformer.Group, error) {
+	g, err := registry.Transformers(elements)
+	if err != nil {
+		return nil, err
+	}
+	return append(g, transformer.Func(sequentialnumber.Transform), transformer.Func(slug.Transform)), nil
+}
+
+// Render parses and transforms src and writes it in the given format to w.
+// Parse errors are returned as parser.ErrorList.
+func (r *Renderer) Render(ctx context.Context, src []byte, format string, w io.Writer) error {
+	root, err := r.Parse(ctx, src)
+	if err != nil {
+		return err
+	}
+	return r.RenderNode(ctx, root, format, w)
+}
+
+// Parse parses and transforms src and returns the node tree.
+func (r *Renderer) Parse(ctx context.Context, src []byte) (*node.Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	root, err := r.parser.Parse(nil, src)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.transformer.Transform(root), nil
+}
+
+// RenderNode writes the node tree, as returned by Parse, in the given format
+// to w. Templates may modify the tree (e.g. set data).
+func (r *Renderer) RenderNode(ctx context.Context, root *node.Node, format string, w io.Writer) error {
+	return r.renderNode(ctx, root, format, "", w, nil)
+}
+
+// RenderNodeTemplate writes the node tree, as returned by Parse, through the
+// named template of the given format to w. Templates may modify the tree.
+func (r *Renderer) RenderNodeTemplate(ctx context.Context, root *node.Node, format, name string, w io.Writer) error {
+	return r.renderNode(ctx, root, format, name, w, nil)
+}
+
+// renderNode renders the node tree through the named template or, if name is
+// blank, the root template. If m is not nil, the output of the HTML element
+// templates is annotated (see RenderNodeMapped).
+func (r *Renderer) renderNode(ctx context.Context, root *node.Node, format, name string, w io.Writer, m *mapper) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	in, err := r.instance(format, m)
+	if err != nil {
+		return err
+	}
+	return in.execute(ctx, root, aggregator.Apply(root, r.aggregators), name, w)
+}
+
+// instance is a clone of the parsed templates of a format that can be
+// executed any number of times, one at a time.
+type instance struct {
+	html   *template.Template
+	text   *texttemplate.Template
+	global map[string]interface{} // read by the template functions
+}
+
+// instance clones the parsed templates of the format. If m is not nil, the
+// output of the HTML element templates is annotated.
+func (r *Renderer) instance(format string, m *mapper) (*instance, error) {
+	t, err := r.parsed(format)
+	if err != nil {
+		return nil, err
+	}
+	in := &instance{
+		global: map[string]interface{}{},
+	}
+	if t.text != nil {
+		if in.text, err = t.text.Clone(); err != nil {
+			return nil, err
+		}
+		in.text.Funcs(totemplate.TextFuncs(in.text, in.global))
+		return in, nil
+	}
+	if in.html, err = t.html.Clone(); err != nil {
+		return nil, err
+	}
+	funcs := totemplate.Funcs(in.html, in.global)
+	if m != nil {
+		funcs["dynamicTemplate"] = m.wrap(funcs["dynamicTemplate"].(func(string, ...interface{}) (template.HTML, error)))
+	}
+	in.html.Funcs(funcs)
+	return in, nil
+}
+
+// execute renders the node tree through the named template or, if name is
+// blank, the root template. The templates get the given aggregates.
+func (in *instance) execute(ctx context.Context, root *node.Node, aggregates aggregator.Aggregates, name string, w io.Writer) error {
+	in.global["aggregates"] = aggregates
+	w = ctxWriter{ctx, w}
+	var err error
+	switch {
+	case in.text != nil && name != "":
+		err = in.text.ExecuteTemplate(w, name, root)
+	case in.text != nil:
+		err = in.text.Execute(w, root)
+	case name != "":
+		err = in.html.ExecuteTemplate(w, name, root)
+	default:
+		err = in.html.Execute(w, root)
+	}
+	return executeError(ctx, err)
+}
+
+// parsed returns the parsed templates of the format.
+func (r *Renderer) parsed(format string) (*templates, error) {
+	r.mu.Lock()
+	t, ok := r.templates[format]
+	if !ok {
+		t = &templates{}
+		r.templates[format] = t
+	}
+	r.mu.Unlock()
+
+	t.once.Do(func() {
+		if r.cfg.IsTextFormat(format) {
+			tmpl := texttemplate.New(format)
+			tmpl.Funcs(totemplate.TextFuncs(tmpl, nil))
+			t.text, t.err = r.cfg.ParseTextTemplates(tmpl, format)
+			if t.err == nil {
+				_, t.err = t.text.New(streamElement).Parse(streamMarker)
+			}
+			if t.err == nil {
+				_, t.err = t.text.New(contentElement).Parse(contentTemplate)
+			}
+		} else {
+			tmpl := template.New(format)
+			tmpl.Funcs(totemplate.Funcs(tmpl, nil))
+			t.html, t.err = r.cfg.ParseTemplates(tmpl, format)
+			if t.err == nil {
+				_, t.err = t.html.New(streamElement).Parse(streamMarker)
+			}
+			if t.err == nil {
+				_, t.err = t.html.New(contentElement).Parse(contentTemplate)
+			}
+		}
+		if t.err != nil {
+			t.err = fmt.Errorf("parse templates failed (format=%q): %v", format, t.err)
+		}
+	})
+	return t, t.err
+}
+
+func executeError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("execute template failed: %v", err)
+}
+
+// ctxWriter is a writer that fails once the context is done; it stops template
+// execution.
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w ctxWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
@@ -0,0 +1,195 @@
+package render_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/render"
+)
+
+func TestRender(t *testing.T) {
+	r, err := render.New(&config.Default, render.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		in     string
+		format string
+		want   []string
+	}{
+		{"html", "= A\n\nb **c**", "html", []string{"<h1>", "A", "<strong>c</strong>", "</html>"}},
+		{"markdown", "= A\n\nb **c**", "markdown", []string{"# A", "b **c**"}},
+		{"numbered headings", "## A\n### B", "html", []string{"1", "1.1"}},
+		{"stray metadata", "% title A\n\nb\n\n% c d", "html", []string{"<title>A</title>", "<p>% c d</p>"}},
+		{"stray metadata markdown", "% title A\n\nb\n\n% c d", "markdown", []string{"title: \"A\"", "b\n\n% c d"}},
+		{"markdown table", "| a | ``b|c``", "markdown", []string{"| a | `b\\|c` |"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := r.Render(context.Background(), []byte(c.in), c.format, &b); err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range c.want {
+				if !strings.Contains(b.String(), s) {
+					t.Errorf("output does not contain %q:\n%s", s, b.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRenderNodeTemplate(t *testing.T) {
+	r, err := render.New(&config.Default, render.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := r.Parse(context.Background(), []byte("= A\n\nb **c**"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := r.RenderNodeTemplate(context.Background(), root, "html", "children", &b); err != nil {
+		t.Fatal(err)
+	}
+	if s := b.String(); !strings.Contains(s, "<strong>c</strong>") || strings.Contains(s, "<html") {
+		t.Errorf("got %s, want the children without the root template", s)
+	}
+}
+
+func TestRenderConcurrent(t *testing.T) {
+	r, err := render.New(&config.Default, render.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := []byte("## A\n\n- b\n- c\n\n### D")
+
+	var want bytes.Buffer
+	if err := r.Render(context.Background(), src, "html", &want); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			format := "html"
+			if i%2 == 1 {
+				format = "markdown"
+			}
+			var b bytes.Buffer
+			if err := r.Render(context.Background(), src, format, &b); err != nil {
+				t.Error(err)
+				return
+			}
+			if format == "html" && b.String() != want.String() {
+				t.Errorf("got\n%s\nwant\n%s", b.String(), want.String())
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRenderError(t *testing.T) {
+	r, err := render.New(&config.Default, render.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	err = r.Render(context.Background(), []byte("a"), "pdf", &b)
+	if err == nil || !strings.Contains(err.Error(), `parse templates failed (format="pdf")`) {
+		t.Errorf("got error %v, want parse templates failed", err)
+	}
+
+	err = r.Render(context.Background(), []byte("a\x00"), "html", &b)
+	var el parser.ErrorList
+	if !errors.As(err, &el) {
+		t.Errorf("got error %v, want parser.ErrorList", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.Render(ctx, []byte("a"), "html", &b); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestNewError(t *testing.T) {
+	cfg := config.Config{
+		Elements: config.Elements{
+			"A": {Type: "nope"},
+		},
+	}
+	if _, err := render.New(&cfg, render.Options{}); err == nil || !strings.Contains(err.Error(), `unknown type "nope"`) {
+		t.Errorf("got error %v, want unknown type", err)
+	}
+}
+
+func TestRenderStream(t *testing.T) {
+	r, err := render.New(&config.Default, render.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := "- a\n- b\n\n= C\n\n| d |\n+ e\n\nf\x00"
+
+	var b bytes.Buffer
+	err = r.RenderStream(context.Background(), strings.NewReader(src), "html", &b)
+	var errs parser.ErrorList
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Errorf("got error %v, want 1 parse error", err)
+	}
+	out := b.String()
+	for s, want := range map[string]int{
+		"<html>":       1,
+		"<ul>":         1,
+		"<li>":         2,
+		"<h1>":         1,
+		"<table>":      1,
+		"<figcaption>": 1,
+		"<p>":          1,
+	} {
+		if got := strings.Count(out, s); got != want {
+			t.Errorf("got %d %q, want %d:\n%s", got, s, want, out)
+		}
+	}
+}
+
+func TestRenderStreamEqual(t *testing.T) {
+	r, err := render.New(&config.Default, render.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []string{
+		"",
+		"## A\n\n## B\n\n== Same\n\n== Same\n",
+		"! id=\"fig\"\n.image a.png\n+ Caption.\n\nSee <<fig>>.\n\n! id=\"tab\"\n| a |\n+ T.\n\nAnd <<tab>> and <<fig>>.\n",
+		"% title Stream\n% author A\n\n## A\n\n### A.1\n\n== Same\n\nText^^n^^ and^^m^^.\n\n## B\n\n== Same\n\n- a\n- b\n\nMore^^n^^.\n\n^n\nThe note.\n\n^m\nOther note.\n",
+		// footnotes defined out of order and referenced after their batch
+		"a^^x^^ b^^y^^\n\n^y\nY.\n\n## H\n\n## I\n\n^x\n**X.**\n\n## J\n\nc^^x^^\n",
+	}
+	for _, src := range cases {
+		for _, format := range []string{"html", "markdown"} {
+			var want bytes.Buffer
+			if err := r.Render(context.Background(), []byte(src), format, &want); err != nil {
+				t.Fatal(err)
+			}
+			var got bytes.Buffer
+			if err := r.RenderStream(context.Background(), strings.NewReader(src), format, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != want.String() {
+				t.Errorf("%q (%s):\ngot:\n%s\nwant:\n%s", src, format, got.String(), want.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/touchmarine/to/node"
+)
+
+// SourceMapVersion is the version of the source map JSON encoding.
+const SourceMapVersion = 1
+
+// LineAttribute is the HTML attribute holding the source lines of the
+// annotated elements, e.g. data-to-line="3-5" (one-based, inclusive) or
+// data-to-line="3" for a single line.
+const LineAttribute = "data-to-line"
+
+// SourceMap maps ranges of the rendered output back to the source ranges of
+// the elements rendered there.
+//
+// It is encoded in JSON as:
+// 	{
+// 		"version": 1,
+// 		"mappings": [
+// 			{
+// 				"element": "Heading",
+// 				"start": 15,
+// 				"end": 73,
+// 				"range": {
+// 					"start": {"offset": 0, "line": 0, "column": 0},
+// 					"end": {"offset": 10, "line": 0, "column": 10}
+// 				}
+// 			},
+// 			...
+// 		]
+// 	}
+type SourceMap struct {
+	Version  int       `json:"version"`
+	Mappings []Mapping `json:"mappings"`
+}
+
+// Mapping maps the output bytes [Start, End) to the source range of the
+// element rendered there. Mappings of nested elements are nested.
+type Mapping struct {
+	Element string
+	Start   int // output byte offset
+	End     int // output byte offset
+	Range   node.Range
+}
+
+// At returns the innermost mapping that contains the output offset or nil if
+// there is none.
+func (m *SourceMap) At(offset int) *Mapping {
+	var found *Mapping
+	for i := range m.Mappings {
+		x := &m.Mappings[i]
+		if x.Start > offset {
+			break
+		}
+		if offset < x.End {
+			found = x
+		}
+	}
+	return found
+}
+
+type jsonMapping struct {
+	Element string    `json:"element"`
+	Start   int       `json:"start"`
+	End     int       `json:"end"`
+	Range   jsonRange `json:"range"`
+}
+
+type jsonRange struct {
+	Start jsonPosition `json:"start"`
+	End   jsonPosition `json:"end"`
+}
+
+type jsonPosition struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (m Mapping) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonMapping{
+		Element: m.Element,
+		Start:   m.Start,
+		End:     m.End,
+		Range: jsonRange{
+			Start: jsonPosition(m.Range.Start),
+			End:   jsonPosition(m.Range.End),
+		},
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (m *Mapping) UnmarshalJSON(b []byte) error {
+	var j jsonMapping
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*m = Mapping{
+		Element: j.Element,
+		Start:   j.Start,
+		End:     j.End,
+		Range: node.Range{
+			Start: node.Position(j.Range.Start),
+			End:   node.Position(j.Range.End),
+		},
+	}
+	return nil
+}
+
+// RenderNodeMapped is like RenderNode but annotates the output of the block
+// elements with their source lines and returns the source map of the output.
+// The first start tag of each block element's output gets the LineAttribute;
+// an element whose output does not start with a tag or starts with the tag
+// of a nested element, as stickies do, gets only a mapping.
+//
+// Only HTML formats are supported.
+func (r *Renderer) RenderNodeMapped(ctx context.Context, root *node.Node, format string, w io.Writer) (*SourceMap, error) {
+	if r.cfg.IsTextFormat(format) {
+		return nil, fmt.Errorf("source maps are not supported for text formats (format=%q)", format)
+	}
+	var (
+		m mapper
+		b bytes.Buffer
+	)
+	if err := r.renderNode(ctx, root, format, "", &b, &m); err != nil {
+		return nil, err
+	}
+	out, sm := m.resolve(b.Bytes())
+	if _, err := w.Write(out); err != nil {
+		return nil, err
+	}
+	return sm, nil
+}
+
+// Markers are inserted around the output of the annotated elements and removed
+// once the whole output is rendered. NULL is illegal in source text and
+// html/template escapes it, so the markers do not clash with the content; a
+// NULL that does not start a marker is kept as is.
+const (
+	markerDelim = '\x00'
+	markerStart = 's'
+	markerEnd   = 'e'
+)
+
+// mapper records the annotated elements during template execution.
+type mapper struct {
+	nodes []*node.Node // by marker index
+}
+
+// wrap wraps the dynamicTemplate function so that it annotates the output of
+// element templates.
+func (m *mapper) wrap(f func(string, ...interface{}) (template.HTML, error)) func(string, ...interface{}) (template.HTML, error) {
+	return func(name string, v ...interface{}) (template.HTML, error) {
+		out, err := f(name, v...)
+		if err != nil || len(v) != 1 {
+			return out, err
+		}
+		n, ok := v[0].(*node.Node)
+		if !ok || n == nil || n.Element == "" || n.Element != name || n.IsInline() {
+			return out, err
+		}
+		return template.HTML(m.annotate(string(out), n)), nil
+	}
+}
+
+// annotate adds the line attribute to the first start tag of the element's
+// output and surrounds the output, without the surrounding spacing, with
+// markers.
+func (m *mapper) annotate(s string, n *node.Node) string {
+	start := len(s) - len(strings.TrimLeft(s, " \t\r\n"))
+	end := len(strings.TrimRight(s, " \t\r\n"))
+	if start >= end {
+		return s
+	}
+	k := strconv.Itoa(len(m.nodes))
+	m.nodes = append(m.nodes, n)
+
+	body := s[start:end]
+	if i := tagNameEnd(body); i > 0 {
+		body = body[:i] + " " + LineAttribute + `="` + lines(sourceRange(n)) + `"` + body[i:]
+	}
+	var b strings.Builder
+	b.WriteString(s[:start])
+	b.WriteString(marker(markerStart, k))
+	b.WriteString(body)
+	b.WriteString(marker(markerEnd, k))
+	b.WriteString(s[end:])
+	return b.String()
+}
+
+func marker(kind byte, k string) string {
+	return string(markerDelim) + string(kind) + k + string(markerDelim)
+}
+
+// tagNameEnd returns the end of the tag name if s starts with a start tag,
+// otherwise 0.
+func tagNameEnd(s string) int {
+	if len(s) < 2 || s[0] != '<' || !isLetter(s[1]) {
+		return 0
+	}
+	i := 2
+	for i < len(s) && (isLetter(s[i]) || s[i] >= '0' && s[i] <= '9' || s[i] == '-') {
+		i++
+	}
+	return i
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// sourceRange returns the source range of the node. Nodes made by
+// transformers, like groups, have no location; their range spans the ranges of
+// their children.
+func sourceRange(n *node.Node) node.Range {
+	if n.Location.Range != (node.Range{}) {
+		return n.Location.Range
+	}
+	var r node.Range
+	found := false
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		cr := sourceRange(c)
+		if cr == (node.Range{}) {
+			continue
+		}
+		if !found {
+			r.Start = cr.Start
+			found = true
+		}
+		r.End = cr.End
+	}
+	return r
+}
+
+// lines returns the one-based line range of the source range. A range that
+// ends at the start of a line does not include that line.
+func lines(r node.Range) string {
+	first, last := r.Start.Line+1, r.End.Line+1
+	if r.End.Column == 0 && r.End.Line > r.Start.Line {
+		last--
+	}
+	if first >= last {
+		return strconv.Itoa(first)
+	}
+	return strconv.Itoa(first) + "-" + strconv.Itoa(last)
+}
+
+// parseMarker parses the marker at the start of b and returns it with its
+// index. It reports false if b does not start with a marker.
+func (m *mapper) parseMarker(b []byte) ([]byte, int, bool) {
+	j := bytes.IndexByte(b[1:], markerDelim)
+	if j < 2 {
+		return nil, 0, false
+	}
+	mk := b[:j+2]
+	if mk[1] != markerStart && mk[1] != markerEnd {
+		return nil, 0, false
+	}
+	k, err := strconv.Atoi(string(mk[2 : len(mk)-1]))
+	if err != nil || k < 0 || k >= len(m.nodes) {
+		return nil, 0, false
+	}
+	return mk, k, true
+}
+
+// resolve removes the markers from the output and returns the output and its
+// source map. Markers whose pair was dropped by the templates are ignored.
+func (m *mapper) resolve(out []byte) ([]byte, *SourceMap) {
+	sm := &SourceMap{
+		Version:  SourceMapVersion,
+		Mappings: []Mapping{},
+	}
+	starts := map[int]int{}
+	var b bytes.Buffer
+	for {
+		i := bytes.IndexByte(out, markerDelim)
+		if i < 0 {
+			b.Write(out)
+			break
+		}
+		b.Write(out[:i])
+		mk, k, ok := m.parseMarker(out[i:])
+		if !ok {
+			// not a marker, keep the NULL
+			b.WriteByte(out[i])
+			out = out[i+1:]
+			continue
+		}
+		out = out[i+len(mk):]
+
+		switch mk[1] {
+		case markerStart:
+			starts[k] = b.Len()
+		case markerEnd:
+			start, ok := starts[k]
+			if !ok {
+				continue
+			}
+			n := m.nodes[k]
+			sm.Mappings = append(sm.Mappings, Mapping{
+				Element: n.Element,
+				Start:   start,
+				End:     b.Len(),
+				Range:   sourceRange(n),
+			})
+		}
+	}
+	// nested elements with the same output end before the elements around
+	// them; reverse so that the stable sort puts the outer ones first
+	for i, j := 0, len(sm.Mappings)-1; i < j; i, j = i+1, j-1 {
+		sm.Mappings[i], sm.Mappings[j] = sm.Mappings[j], sm.Mappings[i]
+	}
+	sort.SliceStable(sm.Mappings, func(i, j int) bool {
+		a, b := sm.Mappings[i], sm.Mappings[j]
+		if a.Start != b.Start {
+			return a.Start < b.Start
+		}
+		return a.End > b.End // outer first
+	})
+	return b.Bytes(), sm
+}
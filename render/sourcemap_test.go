@@ -0,0 +1,99 @@
+package render_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/render"
+)
+
+func TestRenderNodeMapped(t *testing.T) {
+	r, err := render.New(&config.Default, render.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := "== Intro\n\nSome text\nmore.\n\n- a\n- b\n\n> quote"
+
+	ctx := context.Background()
+	root, err := r.Parse(ctx, []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	sm, err := r.RenderNodeMapped(ctx, root, "html", &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+
+	for _, s := range []string{
+		`<h2 data-to-line="1" id="intro">`,
+		`<p data-to-line="3-4">`,
+		`<ul data-to-line="6-7">`,
+		`<li data-to-line="7">`,
+		`<blockquote data-to-line="9">`,
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("output does not contain %q:\n%s", s, out)
+		}
+	}
+
+	// without the attributes, the output is the same as the plain output
+	var plain bytes.Buffer
+	if err := r.Render(ctx, []byte(src), "html", &plain); err != nil {
+		t.Fatal(err)
+	}
+	stripped := regexp.MustCompile(` `+render.LineAttribute+`="[0-9-]*"`).ReplaceAllString(out, "")
+	if stripped != plain.String() {
+		t.Errorf("stripped output differs from the plain output:\n%s\nwant:\n%s", stripped, plain.String())
+	}
+
+	var elements []string
+	for _, m := range sm.Mappings {
+		elements = append(elements, m.Element)
+		if m.Start < 0 || m.End > len(out) || m.Start >= m.End {
+			t.Errorf("%s: invalid output range [%d, %d)", m.Element, m.Start, m.End)
+		}
+	}
+	want := "Heading TextBlock Paragraph TextBlock List ListItem TextBlock ListItem TextBlock Blockquote TextBlock"
+	if got := strings.Join(elements, " "); got != want {
+		t.Errorf("got mappings %s, want %s", got, want)
+	}
+
+	// jump from the rendered text to the source
+	m := sm.At(strings.Index(out, "more."))
+	if m == nil || m.Element != "TextBlock" || m.Range.Start.Line != 2 || m.Range.End.Line != 3 {
+		t.Errorf("At: got %+v, want the paragraph's TextBlock on lines 2-3", m)
+	}
+	if m := sm.At(strings.Index(out, "<ul")); m == nil || m.Element != "List" {
+		t.Errorf("At: got %+v, want List", m)
+	}
+	if m := sm.At(0); m != nil {
+		t.Errorf("At(0): got %+v, want nil", m)
+	}
+
+	j, err := json.Marshal(sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(j), `"range":{"start":{"offset":0,"line":0,"column":0}`) {
+		t.Errorf("unexpected JSON encoding: %s", j)
+	}
+	var decoded render.SourceMap
+	if err := json.Unmarshal(j, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, sm) {
+		t.Errorf("decoded source map differs:\n%+v\nwant:\n%+v", decoded, *sm)
+	}
+
+	if _, err := r.RenderNodeMapped(ctx, root, "markdown", &b); err == nil {
+		t.Error("got nil error for a text format")
+	}
+}
@@ -0,0 +1,295 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+
+	"github.com/touchmarine/to/aggregator"
+	footnoteaggregator "github.com/touchmarine/to/aggregator/footnote"
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/registry"
+	"github.com/touchmarine/to/transformer"
+	"github.com/touchmarine/to/transformer/sequentialnumber"
+	"github.com/touchmarine/to/transformer/slug"
+)
+
+// RenderStream parses Touch formatted text read from src and writes it in the
+// given format to w while it is read, so that the memory used is bounded by
+// the largest top-level block instead of the whole document (see
+// parser.Parser.ParseStream).
+//
+// The top-level blocks are transformed and rendered in batches—runs of blocks
+// that transformers may join: blocks of the elements referenced by the same
+// group element (e.g. list items or a table and its caption) and stickies
+// without a target. The transformers that implement transformer.Streamer, the
+// sequential numbers, and the heading slugs keep their state from batch to
+// batch, and the aggregates of the aggregators that implement
+// aggregator.Merger are merged, so the output is that of Render, except that
+// a batch cannot depend on the batches after it: footnote references are
+// numbered before their definitions are read (and footnotes defined before
+// their first reference are left out), references to labels are resolved only
+// backwards, and aggregates used in a batch hold only the batches so far.
+//
+// The batches are not kept: the footnote definitions aggregated from a batch
+// are rendered with it and the aggregates hold only their output.
+//
+// The root template is executed around the batches: the output before its
+// children once the first batch is transformed (so the metadata is known) and
+// the output after them, with the aggregates of all batches, at the end. The
+// root template must render the children once.
+//
+// Parse errors are returned as parser.ErrorList once the whole input is
+// rendered.
+func (r *Renderer) RenderStream(ctx context.Context, src io.Reader, format string, w io.Writer) error {
+	// the templates are cloned once for all batches
+	in, err := r.instance(format, nil)
+	if err != nil {
+		return err
+	}
+	t, err := streamTransformers(r.cfg.Elements)
+	if err != nil {
+		return err
+	}
+	j := newJoiner(r.cfg.Elements)
+	var (
+		batch      []*node.Node
+		aggregates aggregator.Aggregates // of the batches so far
+		doc        *node.Node            // root for the root template
+		wrote      bool                  // whether the children output is not empty
+		out        bytes.Buffer          // output of a batch, written at once
+	)
+	// transform transforms the batch; more reports whether blocks follow it
+	transform := func(more bool) *node.Node {
+		root := &node.Node{
+			Type: node.TypeContainer,
+		}
+		for _, b := range batch {
+			root.AppendChild(b)
+		}
+		var placeholder *node.Node
+		if len(batch) == 1 && (doc != nil || more) {
+			// the block has siblings in the document, which some
+			// transformers look for (e.g. paragraphs)
+			placeholder = &node.Node{
+				Type: node.TypeContainer,
+			}
+			root.AppendChild(placeholder)
+		}
+		root = t.Transform(root)
+		if placeholder != nil {
+			root.RemoveChild(placeholder)
+		}
+		batch = batch[:0]
+		aggregates = aggregator.Merge(aggregates, root, r.aggregators)
+		return root
+	}
+	// start writes the output of the root template before the children
+	start := func(root *node.Node) error {
+		doc = &node.Node{
+			Type: node.TypeContainer,
+			Data: root.Data,
+		}
+		prologue, _, err := renderRoot(ctx, in, doc, aggregates, format)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(prologue)
+		return err
+	}
+	flush := func(more bool) error {
+		if len(batch) == 0 {
+			return nil
+		}
+		root := transform(more)
+		if doc == nil {
+			if err := start(root); err != nil {
+				return err
+			}
+		}
+		if wrote {
+			// the children template separates the batch from the
+			// output before it as if it followed an element
+			root.InsertBefore(streamNode(), root.FirstChild)
+		}
+
+		out.Reset()
+		if err := in.execute(ctx, root, aggregates, "children", &out); err != nil {
+			return err
+		}
+		b := out.Bytes()
+		if i := bytes.Index(b, []byte(streamMarker)); wrote && i >= 0 {
+			b = b[i+len(streamMarker):]
+		}
+		wrote = wrote || len(b) > 0
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		return detach(ctx, in, root, aggregates)
+	}
+	err = r.parser.ParseStream(src, func(b *node.Node) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if len(batch) > 0 && !j.joins(batch[len(batch)-1].Element, b.Element) {
+			if err := flush(true); err != nil {
+				return err
+			}
+		}
+		batch = append(batch, b)
+		return nil
+	})
+	if ferr := flush(false); err == nil {
+		err = ferr
+	}
+	if err != nil && !errors.As(err, new(parser.ErrorList)) {
+		return err
+	}
+	if doc == nil {
+		// empty document
+		if serr := start(transform(false)); serr != nil {
+			return serr
+		}
+	}
+	_, epilogue, rerr := renderRoot(ctx, in, doc, aggregates, format)
+	if rerr != nil {
+		return rerr
+	}
+	if _, werr := w.Write(epilogue); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// streamElement is the element of the node that stands for the streamed
+// children in the root template; its template, added to the templates of each
+// format, writes streamMarker.
+const (
+	streamElement = "\uE000stream"
+	streamMarker  = "\uE000stream\uE000"
+)
+
+func streamNode() *node.Node {
+	return &node.Node{
+		Element: streamElement,
+		Type:    node.TypeLeaf,
+	}
+}
+
+// contentElement is the element of the node that holds rendered content in
+// node.Data["content"]; its template, added to the templates of each format,
+// writes the content.
+const (
+	contentElement  = "\uE000content"
+	contentTemplate = "{{.Data.content}}"
+)
+
+// detach replaces the footnote definitions of the batch root in the aggregates
+// by containers of a contentElement node that holds their children rendered,
+// so that the aggregates do not keep the batch.
+func detach(ctx context.Context, in *instance, root *node.Node, aggregates aggregator.Aggregates) error {
+	for _, a := range aggregates {
+		err := footnoteaggregator.Detach(a, root, func(def *node.Node) (*node.Node, error) {
+			var b bytes.Buffer
+			if err := in.execute(ctx, def, aggregates, "children", &b); err != nil {
+				return nil, err
+			}
+			n := &node.Node{
+				Type: node.TypeContainer,
+			}
+			n.AppendChild(&node.Node{
+				Element: contentElement,
+				Type:    node.TypeLeaf,
+				Data: node.Data{
+					// not escaped again by HTML templates
+					"content": template.HTML(b.String()),
+				},
+			})
+			return n, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderRoot renders the root template of the format, cloned as in, on the
+// document root, whose children are streamed, and returns the output before and
+// after the children.
+func renderRoot(ctx context.Context, in *instance, doc *node.Node, aggregates aggregator.Aggregates, format string) ([]byte, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	s := streamNode()
+	doc.AppendChild(s)
+	defer doc.RemoveChild(s)
+
+	var b bytes.Buffer
+	if err := in.execute(ctx, doc, aggregates, "", &b); err != nil {
+		return nil, nil, err
+	}
+	out := b.Bytes()
+	if bytes.Count(out, []byte(streamMarker)) != 1 {
+		return nil, nil, fmt.Errorf("root template (format=%q) does not render the children once", format)
+	}
+	i := bytes.Index(out, []byte(streamMarker))
+	return out[:i], out[i+len(streamMarker):], nil
+}
+
+// streamTransformers returns the transformers of Transformers for the batches
+// of one streamed document.
+func streamTransformers(elements config.Elements) (transformer.Transformer, error) {
+	g, err := registry.Transformers(elements)
+	if err != nil {
+		return nil, err
+	}
+	return transformer.Group{g.Stream(), &sequentialnumber.Counter{}, &slug.Slugger{}}, nil
+}
+
+// joiner tells which adjacent top-level blocks transformers may join.
+type joiner struct {
+	pairs map[[2]string]bool // elements referenced by the same group element
+	any   map[string]bool    // elements that stick to any element
+}
+
+func newJoiner(elements config.Elements) joiner {
+	j := joiner{
+		pairs: map[[2]string]bool{},
+		any:   map[string]bool{},
+	}
+	for _, e := range elements {
+		var t node.Type
+		if e.Disabled || (&t).UnmarshalText([]byte(e.Type)) == nil {
+			// not a group element
+			continue
+		}
+		if e.Type == config.TypeSticky && e.Target == "" {
+			j.any[e.Element] = true
+			continue
+		}
+		var refs []string
+		for _, x := range []string{e.Element, e.Target} {
+			if x != "" {
+				refs = append(refs, x)
+			}
+		}
+		for _, x := range refs {
+			for _, y := range refs {
+				j.pairs[[2]string{x, y}] = true
+			}
+		}
+	}
+	return j
+}
+
+// joins reports whether the block of element b that follows the block of
+// element a may be joined with it.
+func (j joiner) joins(a, b string) bool {
+	return j.any[a] || j.any[b] || j.pairs[[2]string{a, b}]
+}
@@ -0,0 +1,406 @@
+// Package serve provides a live preview server for directories of Touch
+// formatted text.
+//
+// The .to files are rendered to HTML on each request, so a page always shows
+// the current content of its file. The server polls the files, and the files
+// the renderer was loaded from (e.g. configs), for changes and tells the open
+// pages to reload through server-sent events. Parse, template, and config
+// errors are shown on the page instead of its content until they are fixed.
+package serve
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/render"
+)
+
+// EventsPath is the URL path of the server-sent events stream that tells the
+// pages to reload.
+const EventsPath = "/_to/events"
+
+// DefaultInterval is the default interval between checks for changes.
+const DefaultInterval = 500 * time.Millisecond
+
+// Server serves the files of a directory. Its behaviour depends on the values
+// in this struct.
+//
+// Requests for .to files are answered with the files rendered to HTML,
+// requests for directories with their index.to or, if there is none, a list of
+// their files, and requests for other files with the files as they are.
+type Server struct {
+	Dir string // served directory
+
+	// Load returns the renderer and the names of the files it was loaded
+	// from, which are watched together with the files in Dir. It is called
+	// on first use and each time the files it was loaded from change. If it
+	// fails, the error is shown on all pages; the returned names are still
+	// watched so the error can be fixed.
+	Load func() (*render.Renderer, []string, error)
+
+	Interval time.Duration // interval between checks for changes (default DefaultInterval)
+
+	once     sync.Once
+	mu       sync.Mutex
+	renderer *render.Renderer
+	loadErr  error
+	deps     []string      // files the renderer was loaded from
+	version  int           // incremented on each change
+	changed  chan struct{} // closed on each change
+}
+
+func (s *Server) init() {
+	s.once.Do(func() {
+		s.changed = make(chan struct{})
+		s.load()
+	})
+}
+
+// load calls Load and stores its results.
+func (s *Server) load() {
+	r, deps, err := s.Load()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.loadErr = err
+		if len(deps) > 0 {
+			s.deps = deps
+		}
+		return
+	}
+	s.renderer = r
+	s.loadErr = nil
+	s.deps = deps
+}
+
+// notify tells the pages to reload.
+func (s *Server) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Watch checks the files for changes every Interval until the context is
+// done. On a change, it reloads the renderer if any of the files it was
+// loaded from changed and tells the open pages to reload. It returns the
+// context's error.
+func (s *Server) Watch(ctx context.Context) error {
+	s.init()
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	prev := s.snapshot()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		cur := s.snapshot()
+		if equal(prev, cur) {
+			continue
+		}
+		s.mu.Lock()
+		deps := s.deps
+		s.mu.Unlock()
+		for _, d := range deps {
+			if prev[d] != cur[d] {
+				s.load()
+				cur = s.snapshot() // the loaded files may differ
+				break
+			}
+		}
+		prev = cur
+		s.notify()
+	}
+}
+
+// fileState is the state of a file used to detect changes.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshot returns the states of the files in Dir, except hidden ones, and of
+// the files the renderer was loaded from.
+func (s *Server) snapshot() map[string]fileState {
+	m := map[string]fileState{}
+	filepath.Walk(s.Dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // the file may have been removed meanwhile
+		}
+		if name != s.Dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			m[name] = fileState{info.ModTime(), info.Size()}
+		}
+		return nil
+	})
+
+	s.mu.Lock()
+	deps := s.deps
+	s.mu.Unlock()
+	for _, d := range deps {
+		if info, err := os.Stat(d); err == nil {
+			m[d] = fileState{info.ModTime(), info.Size()}
+		}
+	}
+	return m
+}
+
+func equal(a, b map[string]fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.init()
+	if r.URL.Path == EventsPath {
+		s.serveEvents(w, r)
+		return
+	}
+
+	rel := path.Clean("/" + r.URL.Path)
+	if hidden(rel) {
+		http.NotFound(w, r)
+		return
+	}
+	name := filepath.Join(s.Dir, filepath.FromSlash(rel))
+	info, err := os.Stat(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		index := filepath.Join(name, "index.to")
+		if _, err := os.Stat(index); err == nil {
+			s.servePage(w, r, index, path.Join(rel, "index.to"))
+			return
+		}
+		s.serveDir(w, r, name, rel)
+		return
+	}
+	if filepath.Ext(name) == ".to" {
+		s.servePage(w, r, name, rel)
+		return
+	}
+	http.ServeFile(w, r, name)
+}
+
+// hidden reports whether any element of the clean URL path rel starts with a
+// dot, e.g. /.git/config. Hidden files and directories are not served.
+func hidden(rel string) bool {
+	for _, e := range strings.Split(rel, "/") {
+		if strings.HasPrefix(e, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// servePage renders the .to file to HTML; rel is its URL path.
+func (s *Server) servePage(w http.ResponseWriter, r *http.Request, name, rel string) {
+	s.mu.Lock()
+	renderer, err, version := s.renderer, s.loadErr, s.version
+	s.mu.Unlock()
+
+	var (
+		src []byte
+		b   bytes.Buffer
+	)
+	if err == nil {
+		src, err = os.ReadFile(name)
+	}
+	if err == nil {
+		err = renderer.Render(r.Context(), src, "html", &b)
+	}
+	if err != nil {
+		if list, ok := err.(parser.ErrorList); ok {
+			for _, e := range list {
+				e.Location.URI = node.DocumentURI(strings.TrimPrefix(rel, "/"))
+			}
+		}
+		var msg bytes.Buffer
+		parser.PrintErrorSource(&msg, src, err)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		errorPage.Execute(w, pageData{
+			Title:   rel,
+			Error:   msg.String(),
+			Version: version,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(injectScript(b.Bytes(), version))
+}
+
+// serveDir lists the .to files and the directories in the directory; rel is
+// its URL path.
+func (s *Server) serveDir(w http.ResponseWriter, r *http.Request, name, rel string) {
+	s.mu.Lock()
+	version := s.version
+	s.mu.Unlock()
+
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var links []string
+	for _, e := range entries {
+		n := e.Name()
+		switch {
+		case strings.HasPrefix(n, "."):
+		case e.IsDir():
+			links = append(links, n+"/")
+		case filepath.Ext(n) == ".to":
+			links = append(links, n)
+		}
+	}
+	sort.Strings(links)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	dirPage.Execute(w, pageData{
+		Title:   rel,
+		Links:   links,
+		Version: version,
+	})
+}
+
+// serveEvents streams a reload event each time the files change. The version
+// the page was served at is given by the v query parameter; if the files
+// changed since, the reload event is sent immediately.
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	v, _ := strconv.Atoi(r.URL.Query().Get("v"))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		s.mu.Lock()
+		version, changed := s.version, s.changed
+		s.mu.Unlock()
+
+		if version != v {
+			fmt.Fprintf(w, "event: reload\ndata: %d\n\n", version)
+			flusher.Flush()
+			v = version
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+// reloadScript returns the script that reloads the page, served at the given
+// version, on the reload event.
+func reloadScript(version int) string {
+	return fmt.Sprintf(`<script>
+new EventSource(%q).addEventListener("reload", function() { location.reload(); });
+</script>
+`, EventsPath+"?v="+strconv.Itoa(version))
+}
+
+// injectScript inserts the reload script before the closing body tag of the
+// page or, if there is none, at its end.
+func injectScript(page []byte, version int) []byte {
+	s := reloadScript(version)
+	i := bytes.LastIndex(page, []byte("</body>"))
+	if i < 0 {
+		return append(page, s...)
+	}
+	var b bytes.Buffer
+	b.Write(page[:i])
+	b.WriteString(s)
+	b.Write(page[i:])
+	return b.Bytes()
+}
+
+type pageData struct {
+	Title   string
+	Error   string
+	Links   []string
+	Version int
+}
+
+var funcs = template.FuncMap{
+	"script": func(version int) template.HTML {
+		return template.HTML(reloadScript(version))
+	},
+}
+
+var errorPage = template.Must(template.New("error").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Error: {{.Title}}</title>
+</head>
+<body>
+<pre id="to-error" style="position: fixed; inset: 0; margin: 0; padding: 1em; overflow: auto; background: #fff0f0; color: #a00; white-space: pre-wrap;">{{.Error}}</pre>
+{{script .Version}}</body>
+</html>
+`))
+
+var dirPage = template.Must(template.New("dir").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<ul>
+{{- range .Links}}
+<li><a href="{{.}}">{{.}}</a></li>
+{{- end}}
+</ul>
+{{script .Version}}</body>
+</html>
+`))
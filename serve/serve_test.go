@@ -0,0 +1,218 @@
+package serve_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/render"
+	"github.com/touchmarine/to/serve"
+)
+
+func load() (*render.Renderer, []string, error) {
+	r, err := render.New(&config.Default, render.Options{})
+	return r, nil, err
+}
+
+func TestServe(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "a.to"), "== Hello")
+	write(t, filepath.Join(dir, "bad.to"), "a\x00b")
+	write(t, filepath.Join(dir, "img.txt"), "plain")
+	write(t, filepath.Join(dir, "sub", "index.to"), "== Index")
+	write(t, filepath.Join(dir, ".hidden.to"), "hidden")
+	write(t, filepath.Join(dir, ".env"), "SECRET=1")
+	write(t, filepath.Join(dir, ".git", "config"), "[core]")
+	write(t, filepath.Join(dir, "sub", ".hidden", "a.txt"), "hidden")
+
+	ts := httptest.NewServer(&serve.Server{Dir: dir, Load: load})
+	defer ts.Close()
+
+	cases := []struct {
+		path   string
+		status int
+		has    []string
+		hasNot []string
+	}{
+		{"/a.to", 200, []string{`<h2 id="hello">`, serve.EventsPath + "?v=0"}, nil},
+		{"/bad.to", 500, []string{`id="to-error"`, "bad.to:1:2: illegal character NULL", serve.EventsPath}, []string{"Hello"}},
+		{"/img.txt", 200, []string{"plain"}, []string{serve.EventsPath}},
+		{"/", 200, []string{`href="a.to"`, `href="sub/"`, serve.EventsPath}, []string{".hidden.to", "img.txt"}},
+		{"/sub/", 200, []string{`<h2 id="index">`}, nil},
+		{"/missing.to", 404, nil, nil},
+		{"/.env", 404, nil, []string{"SECRET"}},
+		{"/.git/config", 404, nil, []string{"[core]"}},
+		{"/.git/", 404, nil, nil},
+		{"/.hidden.to", 404, nil, nil},
+		{"/sub/.hidden/a.txt", 404, nil, nil},
+		{"/../a.to", 200, []string{`<h2 id="hello">`}, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			status, body := get(t, ts.URL+c.path)
+			if status != c.status {
+				t.Errorf("got status %d, want %d", status, c.status)
+			}
+			for _, s := range c.has {
+				if !strings.Contains(body, s) {
+					t.Errorf("body does not contain %q:\n%s", s, body)
+				}
+			}
+			for _, s := range c.hasNot {
+				if strings.Contains(body, s) {
+					t.Errorf("body contains %q:\n%s", s, body)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadError(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "a.to"), "== Hello")
+
+	s := &serve.Server{
+		Dir: dir,
+		Load: func() (*render.Renderer, []string, error) {
+			return nil, nil, errors.New("cannot decode config")
+		},
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	status, body := get(t, ts.URL+"/a.to")
+	if status != 500 {
+		t.Errorf("got status %d, want 500", status)
+	}
+	if !strings.Contains(body, "cannot decode config") {
+		t.Errorf("body does not contain the error:\n%s", body)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	cfg := filepath.Join(t.TempDir(), "to.json")
+	write(t, filepath.Join(dir, "a.to"), "== Hello")
+	write(t, cfg, "{}")
+
+	var loads int32
+	s := &serve.Server{
+		Dir: dir,
+		Load: func() (*render.Renderer, []string, error) {
+			atomic.AddInt32(&loads, 1)
+			r, err := render.New(&config.Default, render.Options{})
+			return r, []string{cfg}, err
+		},
+		Interval: 10 * time.Millisecond,
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- s.Watch(ctx) }()
+
+	events := openEvents(t, ctx, ts.URL+serve.EventsPath+"?v=0")
+	time.Sleep(50 * time.Millisecond) // let Watch take the first snapshot
+
+	write(t, filepath.Join(dir, "a.to"), "== Hello, World")
+	expectReload(t, events, "1")
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("got %d loads after a page change, want 1", n)
+	}
+
+	write(t, cfg, `{"Elements": {}}`)
+	expectReload(t, events, "2")
+	if n := atomic.LoadInt32(&loads); n != 2 {
+		t.Errorf("got %d loads after a config change, want 2", n)
+	}
+
+	// a page served at an older version reloads immediately
+	stale := openEvents(t, ctx, ts.URL+serve.EventsPath+"?v=1")
+	expectReload(t, stale, "2")
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Watch returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+// openEvents opens the event stream and returns the data of its reload events.
+func openEvents(t *testing.T, ctx context.Context, url string) <-chan string {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("got content type %q", ct)
+	}
+
+	events := make(chan string, 10)
+	go func() {
+		defer resp.Body.Close()
+		sc := bufio.NewScanner(resp.Body)
+		event := ""
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: ") && event == "reload":
+				events <- strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return events
+}
+
+func expectReload(t *testing.T, events <-chan string, version string) {
+	t.Helper()
+	select {
+	case v := <-events:
+		if v != version {
+			t.Errorf("got reload to version %s, want %s", v, version)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload event")
+	}
+}
+
+func write(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,39 @@
+package site
+
+// DefaultLayout is the layout used when no custom layout is given. It defines
+// the following templates that custom layouts can use or override:
+// 	"index" the content of the generated index page
+// 	"toc"   a nested list of the given table of contents entries
+//
+// The layout is executed with Data.
+const DefaultLayout = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Page.Title}}</title>
+</head>
+<body>
+{{- if .Page.Source}}
+<nav><a href="{{.Root}}index.html">Contents</a></nav>
+{{- end}}
+<main>
+{{.Page.Content}}
+</main>
+</body>
+</html>
+{{- define "index"}}
+<h1>Contents</h1>
+{{template "toc" .TOC}}
+{{- end}}
+{{- define "toc"}}
+{{- if .}}
+<ul>
+{{- range .}}
+<li><a href="{{.Href}}">{{if eq .Element "NumberedHeading"}}{{.Number}} {{end}}{{.Title}}</a>
+{{- template "toc" .Children}}</li>
+{{- end}}
+</ul>
+{{- end}}
+{{- end}}
+`
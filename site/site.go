@@ -0,0 +1,383 @@
+// Package site provides a static site generator for directories of Touch
+// formatted text.
+//
+// Each .to file is rendered to HTML through the config templates, by a single
+// render.Renderer for the whole site, and wrapped in a layout template. Links to other .to files are rewritten to the output
+// .html files, other files are copied as they are, and a site-wide table of
+// contents is generated from the sequential numbers of the headings.
+//
+// The output depends only on the source tree, the config, and the layout—it
+// contains no timestamps and references no external resources.
+package site
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	seqnumaggregator "github.com/touchmarine/to/aggregator/sequentialnumber"
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/render"
+	"github.com/touchmarine/to/transformer/metadata"
+)
+
+// Default element names used by the generator.
+const (
+	ElementTitle = "Title" // page title
+	ElementLink  = "Link"  // rewritten link
+)
+
+// DefaultTOC is the list of elements included in the table of contents by
+// default.
+var DefaultTOC = []string{"Heading", "NumberedHeading"}
+
+// Site holds the options for generating a site.
+//
+// The pages are parsed, transformed, and rendered as by render.New with the
+// config.
+type Site struct {
+	Config   *config.Config // element set, templates, and aggregates
+	Matchers matcher.Map    // available matchers (by name); if nil, the registered ones
+	TabWidth int            // tab=<tabwidth> x spaces
+	Layout   string         // layout template (default DefaultLayout)
+	TOC      []string       // table of contents elements (default DefaultTOC)
+}
+
+// Page is a single page of the site.
+type Page struct {
+	Source  string        // source path relative to the source directory (slash-separated)
+	Path    string        // output path relative to the output directory (slash-separated)
+	Title   string        // metadata title, text of the first Title element, or the file name
+	Content template.HTML // rendered body
+
+	headings []seqnumaggregator.Particle
+}
+
+// Entry is an entry of the table of contents—a page or a heading.
+type Entry struct {
+	Element  string   // heading element, empty for pages
+	Title    string   // page title or heading text
+	Number   string   // sequential number of a heading
+	Href     string   // link relative to the current page
+	Children []*Entry // headings
+}
+
+// Data is the data the layout template is executed with.
+type Data struct {
+	Page *Page    // the current page
+	Root string   // path to the output directory relative to the current page
+	TOC  []*Entry // table of contents of the whole site
+}
+
+// Build generates the site from the files in src and writes it to dst. The .to
+// files are rendered, hidden files (starting with a dot) are skipped, and all
+// other files are copied. If src has no index.to, an index page with the table
+// of contents is generated.
+func (s Site) Build(src, dst string) error {
+	layout, err := s.layout()
+	if err != nil {
+		return err
+	}
+	r, err := render.New(s.Config, render.Options{
+		Matchers: s.Matchers,
+		TabWidth: s.TabWidth,
+	})
+	if err != nil {
+		return err
+	}
+
+	var (
+		pages  []*Page
+		assets []string
+	)
+	absDst, err := filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+	err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != src && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if abs, err := filepath.Abs(p); err == nil && abs == absDst {
+				// output directory inside the source directory
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if path.Ext(rel) != ".to" {
+			assets = append(assets, rel)
+			return nil
+		}
+		page, err := s.page(r, src, rel)
+		if err != nil {
+			return err
+		}
+		pages = append(pages, page)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	hasIndex := false
+	for _, p := range pages {
+		if p.Path == "index.html" {
+			hasIndex = true
+		}
+	}
+	if !hasIndex {
+		index := &Page{
+			Path:  "index.html",
+			Title: "Contents",
+		}
+		var b bytes.Buffer
+		if err := layout.ExecuteTemplate(&b, "index", s.data(index, pages)); err != nil {
+			return fmt.Errorf("execute index template failed: %v", err)
+		}
+		index.Content = template.HTML(b.String())
+		pages = append(pages, index)
+	}
+
+	for _, p := range pages {
+		var b bytes.Buffer
+		if err := layout.Execute(&b, s.data(p, pages)); err != nil {
+			return fmt.Errorf("%s: execute layout failed: %v", p.Path, err)
+		}
+		if err := writeFile(filepath.Join(dst, filepath.FromSlash(p.Path)), b.Bytes()); err != nil {
+			return err
+		}
+	}
+	for _, a := range assets {
+		if err := copyFile(filepath.Join(dst, filepath.FromSlash(a)), filepath.Join(src, filepath.FromSlash(a))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// layout parses the default layout and then the custom layout so that the
+// custom layout can override the default templates.
+func (s Site) layout() (*template.Template, error) {
+	t := template.New("layout")
+	if _, err := t.Parse(DefaultLayout); err != nil {
+		panic(fmt.Sprintf("site: parse default layout failed: %v", err))
+	}
+	if s.Layout != "" {
+		if _, err := t.Parse(s.Layout); err != nil {
+			return nil, fmt.Errorf("parse layout failed: %v", err)
+		}
+	}
+	return t, nil
+}
+
+// page parses and renders the source file rel in the src directory.
+func (s Site) page(r *render.Renderer, src, rel string) (*Page, error) {
+	name := filepath.Join(src, filepath.FromSlash(rel))
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	root, err := r.Parse(ctx, b)
+	if err != nil {
+		if list, ok := err.(parser.ErrorList); ok {
+			for _, e := range list {
+				e.Location.URI = node.DocumentURI(name)
+			}
+		}
+		return nil, err
+	}
+	RewriteLinks(root)
+
+	page := &Page{
+		Source: rel,
+		Path:   strings.TrimSuffix(rel, ".to") + ".html",
+		Title:  strings.TrimSuffix(path.Base(rel), ".to"),
+	}
+	if meta, ok := root.Data[metadata.Key].(map[string]interface{}); ok && meta["title"] != nil {
+		page.Title = fmt.Sprint(meta["title"])
+	} else if t := find(root, ElementTitle); t != nil {
+		page.Title = strings.TrimSpace(t.TextContent())
+	}
+	toc := s.TOC
+	if toc == nil {
+		toc = DefaultTOC
+	}
+	page.headings = seqnumaggregator.Particles(seqnumaggregator.Aggregator{Elements: toc}.Aggregate(root))
+
+	// the root template is replaced by the layout
+	var content bytes.Buffer
+	if err := r.RenderNodeTemplate(ctx, root, "html", "children", &content); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	page.Content = template.HTML(content.String())
+	return page, nil
+}
+
+// data returns the layout data for the page p.
+func (s Site) data(p *Page, pages []*Page) Data {
+	root := strings.Repeat("../", strings.Count(p.Path, "/"))
+	var toc []*Entry
+	for _, q := range pages {
+		if q.Source == "" {
+			// generated index
+			continue
+		}
+		href := root + q.Path
+		toc = append(toc, &Entry{
+			Title:    q.Title,
+			Href:     href,
+			Children: headings(href, q.headings),
+		})
+	}
+	return Data{
+		Page: p,
+		Root: root,
+		TOC:  toc,
+	}
+}
+
+// headings returns the heading entries nested by the depth of their
+// sequential numbers.
+func headings(href string, particles []seqnumaggregator.Particle) []*Entry {
+	var (
+		entries []*Entry
+		stack   []*Entry // last entry at each depth
+	)
+	for _, p := range particles {
+		e := &Entry{
+			Element: p.Element,
+			Title:   p.Text,
+			Number:  p.SequentialNumber,
+			Href:    href + "#" + p.ID,
+		}
+		depth := p.Depth()
+		if depth > len(stack)+1 {
+			depth = len(stack) + 1
+		}
+		stack = append(stack[:depth-1], e)
+		if depth == 1 {
+			entries = append(entries, e)
+		} else {
+			parent := stack[depth-2]
+			parent.Children = append(parent.Children, e)
+		}
+	}
+	return entries
+}
+
+// RewriteLinks rewrites the relative targets of the Link elements that end
+// with .to to end with .html. The query and fragment are kept.
+func RewriteLinks(n *node.Node) {
+	walk(n, func(n *node.Node) bool {
+		if n.Element != ElementLink {
+			return true
+		}
+		target := n.TextContent()
+		if rewritten, ok := rewriteTarget(target); ok {
+			for c := n.FirstChild; c != nil; c = n.FirstChild {
+				n.RemoveChild(c)
+			}
+			n.AppendChild(&node.Node{
+				Element: "Text",
+				Type:    node.TypeText,
+				Value:   rewritten,
+			})
+		}
+		return false
+	})
+}
+
+// rewriteTarget returns the target with the .to extension replaced by .html
+// and reports whether it was a relative link to a .to file.
+func rewriteTarget(target string) (string, bool) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Opaque != "" {
+		return "", false
+	}
+	p, rest := target, ""
+	if i := strings.IndexAny(target, "?#"); i >= 0 {
+		p, rest = target[:i], target[i:]
+	}
+	if !strings.HasSuffix(p, ".to") {
+		return "", false
+	}
+	return strings.TrimSuffix(p, ".to") + ".html" + rest, true
+}
+
+// find returns the first node with the given element.
+func find(n *node.Node, element string) *node.Node {
+	var found *node.Node
+	walk(n, func(n *node.Node) bool {
+		if found != nil {
+			return false
+		}
+		if n.Element == element {
+			found = n
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+func walk(n *node.Node, fn func(n *node.Node) bool) {
+	if fn(n) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, fn)
+		}
+	}
+}
+
+// writeFile writes the data to the named file, creating its directory.
+func writeFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0644)
+}
+
+// copyFile copies the file src to dst, creating its directory.
+func copyFile(dst, src string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,201 @@
+package site
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/matcher"
+)
+
+func TestRewriteTarget(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+		ok  bool
+	}{
+		{"a.to", "a.html", true},
+		{"../b/a.to", "../b/a.html", true},
+		{"/a.to", "/a.html", true},
+		{"a.to#Heading", "a.html#Heading", true},
+		{"a.to?q=1#x", "a.html?q=1#x", true},
+		{"a.tox", "", false},
+		{"a.html", "", false},
+		{"#a.to", "", false},
+		{"https://example.test/a.to", "", false},
+		{"//example.test/a.to", "", false},
+		{"mailto:a.to", "", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			out, ok := rewriteTarget(c.in)
+			if out != c.out || ok != c.ok {
+				t.Errorf("got (%q, %v), want (%q, %v)", out, ok, c.out, c.ok)
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"intro.to":       "= Intro\n\nSee ((guide/setup.to#install)).\n\n## Overview\n### Details\n",
+		"guide/setup.to": "= Setup\n\n## Install\n\nBack to ((../intro.to)).\n",
+		"img/logo.png":   "png",
+		".hidden/a.to":   "= Hidden\n",
+	})
+	dst := filepath.Join(t.TempDir(), "out")
+
+	s := testSite()
+	if err := s.Build(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readFiles(t, dst)
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if got, want := strings.Join(names, " "), "guide/setup.html img/logo.png index.html intro.html"; got != want {
+		t.Fatalf("got files %s, want %s", got, want)
+	}
+
+	contains := []struct {
+		file string
+		s    string
+	}{
+		{"intro.html", "<title>Intro</title>"},
+		{"intro.html", `<a href="guide/setup.html#install">`},
+		{"intro.html", `<a href="index.html">Contents</a>`},
+		{"guide/setup.html", `<a href="../intro.html">`},
+		{"guide/setup.html", `<a href="../index.html">Contents</a>`},
+		{"index.html", `<a href="guide/setup.html#install">1 Install</a>`},
+		{"index.html", `<a href="intro.html#details">1.1 Details</a>`},
+		{"guide/setup.html", `<h2 id="install">`},
+		{"img/logo.png", "png"},
+	}
+	for _, c := range contains {
+		if !strings.Contains(files[c.file], c.s) {
+			t.Errorf("%s does not contain %q:\n%s", c.file, c.s, files[c.file])
+		}
+	}
+
+	// reproducible
+	dst2 := filepath.Join(t.TempDir(), "out")
+	if err := s.Build(src, dst2); err != nil {
+		t.Fatal(err)
+	}
+	files2 := readFiles(t, dst2)
+	for name, content := range files {
+		if files2[name] != content {
+			t.Errorf("%s differs between builds", name)
+		}
+	}
+}
+
+func TestBuildIndex(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"index.to": "= Home\n",
+	})
+	dst := filepath.Join(src, "out") // inside the source directory
+
+	s := testSite()
+	s.Layout = `{{.Page.Title}}|{{range .TOC}}{{.Href}}{{end}}`
+	for i := 0; i < 2; i++ {
+		if err := s.Build(src, dst); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := readFiles(t, dst)
+	if len(files) != 1 {
+		t.Errorf("got %d files, want 1", len(files))
+	}
+	if got, want := files["index.html"], "Home|index.html"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBuildMetadataTitle(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"a.to": "% title Meta\n\n= Heading\n",
+	})
+	dst := t.TempDir()
+
+	s := testSite()
+	s.Layout = `{{.Page.Title}}`
+	if err := s.Build(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := readFiles(t, dst)["a.html"], "Meta"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBuildError(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"a.to": "a\x00",
+	})
+
+	err := testSite().Build(src, t.TempDir())
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "a.to:1:2") {
+		t.Errorf("error %q does not contain the location", err)
+	}
+}
+
+func testSite() Site {
+	return Site{
+		Config:   &config.Default,
+		Matchers: matcher.Defaults(),
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(bytes.TrimSpace(b))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
@@ -10,7 +10,7 @@ import (
 )
 
 // AttributesToHTML returns a HTML-formatted string of attributes from the given
-// attributes.
+// attributes. The values are HTML-escaped.
 func AttributesToHTML(attrs map[string]interface{}) template.HTMLAttr {
 	var b strings.Builder
 	var i int
@@ -27,7 +27,7 @@ func AttributesToHTML(attrs map[string]interface{}) template.HTMLAttr {
 			s = fmt.Sprint(v)
 		}
 		if s != "" {
-			b.WriteString(`="` + s + `"`)
+			b.WriteString(`="` + template.HTMLEscapeString(s) + `"`)
 		}
 
 		i++
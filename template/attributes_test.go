@@ -207,3 +207,24 @@ func TestParseAttributes(t *testing.T) {
 		})
 	}
 }
+
+func TestAttributesToHTML(t *testing.T) {
+	cases := []struct {
+		in  map[string]interface{}
+		out string
+	}{
+		{nil, ""},
+		{map[string]interface{}{"a": ""}, "a"},
+		{map[string]interface{}{"a": "b"}, `a="b"`},
+		{map[string]interface{}{"a": 1}, `a="1"`},
+		{map[string]interface{}{"a": `"b" & <c>`}, `a="&#34;b&#34; &amp; &lt;c&gt;"`},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%v", c.in), func(t *testing.T) {
+			if got := string(template.AttributesToHTML(c.in)); got != c.out {
+				t.Errorf("got %s, want %s", got, c.out)
+			}
+		})
+	}
+}
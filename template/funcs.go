@@ -6,24 +6,51 @@ import (
 	"html/template"
 	"log"
 	"strings"
+	texttemplate "text/template"
 
 	"github.com/touchmarine/to/node"
 )
 
 // Funcs returns the set of Touch template functions.
 func Funcs(tmpl *template.Template, global map[string]interface{}) template.FuncMap {
-	return template.FuncMap{
+	m := funcs(global)
+	m["dynamicTemplate"] = MakeTemplateFunction(tmpl)
+	return template.FuncMap(m)
+}
+
+// TextFuncs is like Funcs but for text templates.
+func TextFuncs(tmpl *texttemplate.Template, global map[string]interface{}) texttemplate.FuncMap {
+	m := funcs(global)
+	m["dynamicTemplate"] = MakeTextTemplateFunction(tmpl)
+	return texttemplate.FuncMap(m)
+}
+
+// funcs returns the template functions that do not depend on the template
+// package.
+func funcs(global map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
 		"log":              Log,
 		"logf":             Logf,
 		"error":            Error,
 		"errorf":           Errorf,
-		"dynamicTemplate":  MakeTemplateFunction(tmpl),
 		"elementChildren":  ElementChildren,
+		"nodeChildren":     NodeChildren,
+		"isInline":         IsInline,
 		"trimSpacing":      TrimSpacing,
 		"parseAttributes":  ParseAttributes,
 		"setData":          NodeSetData,
 		"until":            Until,
+		"repeat":           Repeat,
+		"min":              Min,
+		"joinLines":        JoinLines,
+		"prefixLines":      PrefixLines,
+		"hangLines":        HangLines,
 		"attributesToHTML": AttributesToHTML,
+		"escapeMarkdown":   EscapeMarkdown,
+		"markdownCode":     MarkdownCode,
+		"markdownFence":    MarkdownFence,
+		"markdownURL":      MarkdownURL,
+		"markdownCell":     MarkdownCell,
 		"global":           MakeGlobalMapFunction(global),
 		"get":              Dot,
 		"set":              Set,
@@ -77,6 +104,27 @@ func MakeTemplateFunction(tmpl *template.Template) func(name string, v ...interf
 	}
 }
 
+// MakeTextTemplateFunction is like MakeTemplateFunction but for text
+// templates.
+func MakeTextTemplateFunction(tmpl *texttemplate.Template) func(name string, v ...interface{}) (string, error) {
+	return func(name string, v ...interface{}) (string, error) {
+		var arg interface{}
+		switch len(v) {
+		case 0:
+		case 1:
+			arg = v[0]
+		default:
+			return "", errors.New("multiple arguments are not supported")
+		}
+
+		var b strings.Builder
+		if err := tmpl.ExecuteTemplate(&b, name, arg); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+}
+
 // ElementChildren returns a list of element children—children that represent an
 // element and not a plain node (e.g. container).
 func ElementChildren(n *node.Node) []*node.Node {
@@ -87,6 +135,16 @@ func ElementChildren(n *node.Node) []*node.Node {
 	return nodes
 }
 
+// NodeChildren returns a list of all children, including plain nodes (e.g. the
+// row and cell containers of tables).
+func NodeChildren(n *node.Node) []*node.Node {
+	var nodes []*node.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		nodes = append(nodes, c)
+	}
+	return nodes
+}
+
 // firstElement returns the first node that represents an element and not a
 // plain node (e.g. container).
 func firstElement(n *node.Node) *node.Node {
@@ -105,6 +163,23 @@ func firstElement(n *node.Node) *node.Node {
 	return nil
 }
 
+// IsInline reports whether the node is an inline or a container of inlines
+// (e.g. a sticky of inline elements).
+func IsInline(n *node.Node) bool {
+	if n == nil {
+		return false
+	}
+	if n.Type == node.TypeContainer {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if !IsInline(c) {
+				return false
+			}
+		}
+		return n.FirstChild != nil
+	}
+	return n.IsInline()
+}
+
 // TrimSpacing trims spaces and tabs.
 func TrimSpacing(s string) string {
 	return strings.Trim(s, " \t")
@@ -135,3 +210,63 @@ func Until(n int) []int {
 	}
 	return x
 }
+
+// Repeat returns s repeated n times.
+func Repeat(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.Repeat(s, n)
+}
+
+// Min returns the smaller of a and b.
+func Min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// JoinLines joins the lines in s with spaces. Leading and trailing spacing of
+// each line is removed.
+func JoinLines(s string) string {
+	lines := strings.Split(s, "\n")
+	var x []string
+	for _, line := range lines {
+		if line = strings.Trim(line, " \t"); line != "" {
+			x = append(x, line)
+		}
+	}
+	return strings.Join(x, " ")
+}
+
+// PrefixLines adds the prefix to each line in s. Trailing spacing of the
+// prefix is not added to blank lines.
+//
+// Usage:
+// 	{{prefixLines "> " (dynamicTemplate "children" .)}}
+func PrefixLines(prefix, s string) string {
+	return HangLines(prefix, prefix, s)
+}
+
+// HangLines adds the first prefix to the first line and the rest prefix to
+// the remaining lines in s. Trailing spacing of the prefixes is not added to
+// blank lines.
+//
+// Usage:
+// 	{{hangLines "- " "  " (dynamicTemplate "children" .)}}
+func HangLines(first, rest, s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if strings.Trim(line, " \t") == "" {
+			lines[i] = strings.TrimRight(prefix, " \t")
+		} else {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,129 @@
+package template
+
+import (
+	"strings"
+)
+
+// EscapeMarkdown escapes the characters in s that have a special meaning in
+// CommonMark and GitHub Flavored Markdown so that s renders as literal text.
+//
+// Inline delimiters are always escaped while block markers (e.g. '#' or "1.")
+// are escaped only at the start of a line. Leading spacing on all but the first
+// line and trailing spacing on all but the last line are removed as they would
+// start an indented code block or form a hard line break.
+func EscapeMarkdown(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if i > 0 {
+			line = strings.TrimLeft(line, " \t")
+		}
+		if i < len(lines)-1 {
+			line = strings.TrimRight(line, " \t")
+		}
+		lines[i] = escapeMarkdownLine(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func escapeMarkdownLine(line string) string {
+	var b strings.Builder
+	atStart := true // before the first non-spacing character
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		escape := false
+		if atStart && ch != ' ' && ch != '\t' {
+			atStart = false
+			switch {
+			case ch == '#' || ch == '>' || ch == '+' || ch == '-' || ch == '=':
+				// heading, blockquote, list item, or setext underline
+				escape = true
+			case ch >= '0' && ch <= '9':
+				// ordered list item (e.g. 1. or 1))
+				j := i
+				for j < len(line) && line[j] >= '0' && line[j] <= '9' {
+					j++
+				}
+				if j < len(line) && j-i <= 9 && (line[j] == '.' || line[j] == ')') &&
+					(j+1 == len(line) || line[j+1] == ' ' || line[j+1] == '\t') {
+					b.WriteString(line[i:j])
+					b.WriteByte('\\')
+					i = j - 1
+					continue
+				}
+			}
+		}
+		switch ch {
+		case '\\', '`', '*', '_', '[', ']', '<', '>', '&', '|', '~':
+			escape = true
+		}
+		if escape {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+// MarkdownCode returns s as a Markdown code span. The delimiter is longer than
+// any sequence of backticks in s.
+func MarkdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	delimiter := strings.Repeat("`", longestRun(s, '`')+1)
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") ||
+		strings.HasPrefix(s, " ") && strings.HasSuffix(s, " ") && strings.Trim(s, " ") != "" {
+		// a single space on each side is stripped
+		s = " " + s + " "
+	}
+	return delimiter + s + delimiter
+}
+
+// MarkdownCell escapes the pipes in s, the content of a GitHub Flavored
+// Markdown table cell. The pipes in code spans and those already escaped are
+// escaped as well—a backslash before each pipe in a cell is removed before the
+// inlines are parsed.
+func MarkdownCell(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+// MarkdownFence returns a code fence that can enclose the given content and
+// info string. Tildes are used if the info string contains a backtick.
+func MarkdownFence(content, info string) string {
+	ch := byte('`')
+	if strings.Contains(info, "`") {
+		ch = '~'
+	}
+	n := longestRun(content, ch) + 1
+	if n < 3 {
+		n = 3
+	}
+	return strings.Repeat(string(ch), n)
+}
+
+// MarkdownURL returns the URL as a Markdown link destination. URLs that contain
+// spacing or other characters that would end the destination are enclosed in
+// angle brackets.
+func MarkdownURL(url string) string {
+	if url == "" || strings.ContainsAny(url, " \t\n()<>") {
+		r := strings.NewReplacer("<", `\<`, ">", `\>`, "\n", " ")
+		return "<" + r.Replace(url) + ">"
+	}
+	return url
+}
+
+// longestRun returns the length of the longest sequence of ch in s.
+func longestRun(s string, ch byte) int {
+	max, n := 0, 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == ch {
+			n++
+			if n > max {
+				max = n
+			}
+		} else {
+			n = 0
+		}
+	}
+	return max
+}
@@ -0,0 +1,162 @@
+package template_test
+
+import (
+	"testing"
+
+	"github.com/touchmarine/to/template"
+)
+
+func TestEscapeMarkdown(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"", ""},
+		{"a", "a"},
+		{"*a*", `\*a\*`},
+		{"a_b", `a\_b`},
+		{"[a](b)", `\[a\](b)`},
+		{"a < b & c", `a \< b \& c`},
+		{"# a", `\# a`},
+		{"a # b", "a # b"},
+		{"> a", `\> a`},
+		{"- a", `\- a`},
+		{"a - b", "a - b"},
+		{"1. a", `1\. a`},
+		{"10) a", `10\) a`},
+		{"1.5", "1.5"},
+		{"a\n  # b", "a\n\\# b"},
+		{"a  \nb", "a\nb"},
+		{`\`, `\\`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			if got := template.EscapeMarkdown(c.in); got != c.out {
+				t.Errorf("got %q, want %q", got, c.out)
+			}
+		})
+	}
+}
+
+func TestMarkdownCode(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"", ""},
+		{"a", "`a`"},
+		{"a`b", "``a`b``"},
+		{"`a", "`` `a ``"},
+		{" a ", "`  a  `"},
+		{"  ", "`  `"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			if got := template.MarkdownCode(c.in); got != c.out {
+				t.Errorf("got %q, want %q", got, c.out)
+			}
+		})
+	}
+}
+
+func TestMarkdownCell(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"", ""},
+		{"a", "a"},
+		{"`a|b`", "`a\\|b`"},
+		{"a\\|b", "a\\\\|b"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			if got := template.MarkdownCell(c.in); got != c.out {
+				t.Errorf("got %q, want %q", got, c.out)
+			}
+		})
+	}
+}
+
+func TestMarkdownFence(t *testing.T) {
+	cases := []struct {
+		content string
+		info    string
+		out     string
+	}{
+		{"", "", "```"},
+		{"a``b", "", "```"},
+		{"````", "js", "`````"},
+		{"~~~", "a`b", "~~~~"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.content, func(t *testing.T) {
+			if got := template.MarkdownFence(c.content, c.info); got != c.out {
+				t.Errorf("got %q, want %q", got, c.out)
+			}
+		})
+	}
+}
+
+func TestMarkdownURL(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"", "<>"},
+		{"http://a.test", "http://a.test"},
+		{"a b", "<a b>"},
+		{"a(b)", "<a(b)>"},
+		{"a<b>", `<a\<b\>>`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			if got := template.MarkdownURL(c.in); got != c.out {
+				t.Errorf("got %q, want %q", got, c.out)
+			}
+		})
+	}
+}
+
+func TestPrefixLines(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"", ">"},
+		{"a", "> a"},
+		{"a\n\nb", "> a\n>\n> b"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			if got := template.PrefixLines("> ", c.in); got != c.out {
+				t.Errorf("got %q, want %q", got, c.out)
+			}
+		})
+	}
+}
+
+func TestHangLines(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"", "-"},
+		{"a", "- a"},
+		{"a\n\nb", "- a\n\n  b"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			if got := template.HangLines("- ", "  ", c.in); got != c.out {
+				t.Errorf("got %q, want %q", got, c.out)
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+// Package textmate generates TextMate grammars for Touch formatted text from
+// element configs.
+//
+// TextMate grammars are used for syntax highlighting by many editors (e.g. VS
+// Code and Sublime Text) and websites. The grammars match lines with regular
+// expressions, so they approximate the parser: blocks are recognized at the
+// start of lines (after the delimiters of walled elements) and inlines anywhere.
+// For exact highlighting, use the semantic tokens of the language server.
+//
+// Scopes are derived from the element types, followed by the element name in
+// kebab case and the scope name of the grammar, so that themes can target
+// single elements, e.g. markup.heading.heading.touch for the Heading element.
+package textmate
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/touchmarine/to/config"
+	"github.com/touchmarine/to/node"
+)
+
+// DefaultScopeName is the default scope name of the grammar.
+const DefaultScopeName = "text.touch"
+
+// Grammar is a TextMate grammar as encoded in .tmLanguage.json files.
+type Grammar struct {
+	Name       string          `json:"name"`
+	ScopeName  string          `json:"scopeName"`
+	FileTypes  []string        `json:"fileTypes"`
+	Patterns   []Rule          `json:"patterns"`
+	Repository map[string]Rule `json:"repository"`
+}
+
+// Rule is a grammar rule: a match rule, a begin/end rule, an include, or a
+// group of patterns.
+type Rule struct {
+	Name          string             `json:"name,omitempty"`
+	ContentName   string             `json:"contentName,omitempty"`
+	Match         string             `json:"match,omitempty"`
+	Begin         string             `json:"begin,omitempty"`
+	End           string             `json:"end,omitempty"`
+	Captures      map[string]Capture `json:"captures,omitempty"`
+	BeginCaptures map[string]Capture `json:"beginCaptures,omitempty"`
+	EndCaptures   map[string]Capture `json:"endCaptures,omitempty"`
+	Include       string             `json:"include,omitempty"`
+	Patterns      []Rule             `json:"patterns,omitempty"`
+}
+
+// Capture names a capture group of a rule.
+type Capture struct {
+	Name string `json:"name"`
+}
+
+// New returns the grammar for the elements. Disabled elements and elements
+// that are not node elements (e.g. lists) are skipped. If scopeName is blank,
+// DefaultScopeName is used.
+func New(elements config.Elements, scopeName string) *Grammar {
+	if scopeName == "" {
+		scopeName = DefaultScopeName
+	}
+	g := generator{
+		scopeName: scopeName,
+	}
+	for name, e := range elements {
+		if e.Disabled {
+			continue
+		}
+		var t node.Type
+		if err := (&t).UnmarshalText([]byte(e.Type)); err != nil {
+			// isn't a node element
+			continue
+		}
+		if t == node.TypeLeaf || t == node.TypeText || e.Delimiter == "" {
+			continue
+		}
+		g.elements = append(g.elements, element{
+			name:      name,
+			typ:       t,
+			delimiter: e.Delimiter,
+			matcher:   e.Matcher,
+			comment:   e.Option == config.OptionComment,
+		})
+		if t == node.TypeWalled || t == node.TypeVerbatimWalled {
+			g.walled = append(g.walled, e.Delimiter)
+		}
+	}
+	// longest delimiters first so that, e.g., == has precedence over =
+	sort.Slice(g.elements, func(i, j int) bool {
+		a, b := g.elements[i], g.elements[j]
+		if x, y := len(a.key()), len(b.key()); x != y {
+			return x > y
+		}
+		return a.name < b.name
+	})
+	sort.Strings(g.walled)
+	return g.grammar()
+}
+
+// Encode writes the JSON encoding of the grammar to w.
+func (g *Grammar) Encode(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+	e.SetIndent("", "\t")
+	return e.Encode(g)
+}
+
+type element struct {
+	name      string
+	typ       node.Type
+	delimiter string
+	matcher   string
+	comment   bool
+}
+
+// key returns the delimiter as registered by the parser.
+func (e element) key() string {
+	if e.typ == node.TypeRankedHanging ||
+		node.IsInline(e.typ) && e.typ != node.TypePrefixed {
+		return e.delimiter + e.delimiter
+	}
+	return e.delimiter
+}
+
+type generator struct {
+	scopeName string
+	elements  []element // ordered by delimiter length (longest first)
+	walled    []string  // delimiters of walled and verbatim walled elements
+}
+
+func (g generator) grammar() *Grammar {
+	blocks := Rule{}
+	inlines := Rule{
+		Patterns: []Rule{{Include: "#escape"}},
+	}
+	for _, e := range g.elements {
+		if node.IsBlock(e.typ) {
+			if r, ok := g.block(e); ok {
+				blocks.Patterns = append(blocks.Patterns, r...)
+			}
+		} else {
+			inlines.Patterns = append(inlines.Patterns, g.inline(e)...)
+		}
+	}
+	if len(g.walled) > 0 {
+		// delimiters of walled elements not matched by other rules
+		blocks.Patterns = append(blocks.Patterns, Rule{
+			Match:    `^((?:[ \t]*[` + class(g.walled) + `])+)`,
+			Captures: captures(g.scope("punctuation.definition.lead")),
+		})
+	}
+
+	escape := `\\[!-/:-@\[-` + "`" + `{-~]`
+	var special []string
+	for _, e := range g.elements {
+		if r, _ := utf8.DecodeRuneInString(e.key()); node.IsInline(e.typ) && !isPunct(r) {
+			special = append(special, regexp.QuoteMeta(e.key()))
+		}
+	}
+	if len(special) > 0 {
+		// only the first character after the backslash is escaped
+		escape = `\\(?:[!-/:-@\[-` + "`" + `{-~]|(?=` + strings.Join(special, "|") + `).)`
+	}
+
+	return &Grammar{
+		Name:      "Touch",
+		ScopeName: g.scopeName,
+		FileTypes: []string{"to"},
+		Patterns: []Rule{
+			{Include: "#blocks"},
+			{Include: "#inlines"},
+		},
+		Repository: map[string]Rule{
+			"blocks":  blocks,
+			"inlines": inlines,
+			"escape": {
+				Name:  g.scope("constant.character.escape"),
+				Match: escape,
+			},
+		},
+	}
+}
+
+// lead returns the pattern that matches the delimiters of the walled elements
+// at the start of a line, lazily so that the first delimiter that matches a
+// rule wins. It is the first capture group.
+func (g generator) lead() string {
+	if len(g.walled) == 0 {
+		return `^([ \t]*)`
+	}
+	return `^((?:[ \t]*[` + class(g.walled) + `])*?[ \t]*)`
+}
+
+// block returns the rules of the block element. Walled elements have no rules
+// of their own, their delimiters are matched as leads.
+func (g generator) block(e element) ([]Rule, bool) {
+	lead := g.lead()
+	d := regexp.QuoteMeta(e.delimiter)
+	delim := g.scope("punctuation.definition." + kebab(e.name))
+	leadScope := g.scope("punctuation.definition.lead")
+
+	switch e.typ {
+	case node.TypeVerbatimWalled, node.TypeVerbatimLine:
+		name := g.elementScope(e, "markup.raw.block")
+		return []Rule{{
+			Name:     name,
+			Match:    lead + `(` + d + `)(.*)$`,
+			Captures: captures(leadScope, delim),
+		}}, true
+	case node.TypeHanging:
+		return []Rule{{
+			Match:    lead + `(` + d + `)`,
+			Captures: captures(leadScope, delim),
+		}}, true
+	case node.TypeRankedHanging:
+		return []Rule{{
+			Name:          g.elementScope(e, "markup.heading"),
+			Begin:         lead + `((?:` + d + `){2,})`,
+			End:           `$`,
+			BeginCaptures: captures(leadScope, delim),
+			Patterns:      []Rule{{Include: "#inlines"}},
+		}}, true
+	case node.TypeFenced:
+		content := g.elementScope(e, "markup.raw.block")
+		opening := g.scope("entity.name.type." + kebab(e.name))
+		return []Rule{
+			{
+				// escaped, closed by \ and the delimiter
+				Name:          g.elementScope(e, "meta.fenced"),
+				ContentName:   content,
+				Begin:         lead + `(` + d + `\\)(.*)$`,
+				End:           lead + `(\\` + d + `)`,
+				BeginCaptures: captures(leadScope, delim, opening),
+				EndCaptures:   captures(leadScope, delim),
+			},
+			{
+				Name:          g.elementScope(e, "meta.fenced"),
+				ContentName:   content,
+				Begin:         lead + `(` + d + `)(.*)$`,
+				End:           lead + `(` + d + `)`,
+				BeginCaptures: captures(leadScope, delim, opening),
+				EndCaptures:   captures(leadScope, delim),
+			},
+		}, true
+	case node.TypeTable:
+		return []Rule{{
+			Name:          g.elementScope(e, "markup.table"),
+			Begin:         lead + `(?=` + d + `)`,
+			End:           `$`,
+			BeginCaptures: captures(leadScope),
+			Patterns: []Rule{
+				{
+					// separator line cells
+					Name:  delim,
+					Match: `(?<=` + d + `)[ \t]*:?-+:?[ \t]*(?=` + d + `|$)`,
+				},
+				{
+					Name:  delim,
+					Match: d,
+				},
+				{Include: "#inlines"},
+			},
+		}}, true
+	}
+	return nil, false
+}
+
+// inline returns the rules of the inline element.
+func (g generator) inline(e element) []Rule {
+	d := regexp.QuoteMeta(e.key())
+	delim := g.scope("punctuation.definition." + kebab(e.name))
+
+	switch e.typ {
+	case node.TypeUniform:
+		c := regexp.QuoteMeta(counterpart(e.key()))
+		return []Rule{{
+			Name:          g.elementScope(e, "markup.other"),
+			Begin:         `(` + d + `)`,
+			End:           `(` + c + `)|^(?=[ \t]*$)`,
+			BeginCaptures: captures(delim),
+			EndCaptures:   captures(delim),
+			Patterns:      []Rule{{Include: "#inlines"}},
+		}}
+	case node.TypeEscaped:
+		c := regexp.QuoteMeta(counterpart(e.key()))
+		base := "markup.raw.inline"
+		if e.delimiter == "(" {
+			base = "markup.underline.link"
+		}
+		name := g.elementScope(e, base)
+		return []Rule{
+			{
+				// escaped, closed by \ and the closing delimiter
+				Name:          name,
+				Begin:         `(` + d + `\\)`,
+				End:           `(\\` + c + `)`,
+				BeginCaptures: captures(delim),
+				EndCaptures:   captures(delim),
+			},
+			{
+				Name:          name,
+				Begin:         `(` + d + `)`,
+				End:           `(` + c + `)|^(?=[ \t]*$)`,
+				BeginCaptures: captures(delim),
+				EndCaptures:   captures(delim),
+			},
+		}
+	case node.TypePrefixed:
+		if e.matcher == "" {
+			return []Rule{{
+				Name:  g.elementScope(e, "constant.character"),
+				Match: d,
+			}}
+		}
+		return []Rule{{
+			Name:     g.elementScope(e, "markup.underline.link"),
+			Match:    `(` + d + `)([^\s<>]*[^\s<>.,:;!?'")\]])?`,
+			Captures: captures(delim),
+		}}
+	}
+	return nil
+}
+
+// elementScope returns the scope of the element based on the given base scope
+// (e.g. markup.heading). Comment elements are scoped as comments.
+func (g generator) elementScope(e element, base string) string {
+	if e.comment {
+		if node.IsBlock(e.typ) {
+			base = "comment.block"
+		} else {
+			base = "comment.line"
+		}
+	}
+	return g.scope(base + "." + kebab(e.name))
+}
+
+// scope appends the scope name of the grammar's language (e.g. touch) to s.
+func (g generator) scope(s string) string {
+	return s + "." + g.scopeName[strings.LastIndexByte(g.scopeName, '.')+1:]
+}
+
+// captures returns the captures with the given names from the first group on.
+func captures(names ...string) map[string]Capture {
+	m := map[string]Capture{}
+	for i, n := range names {
+		m[strconv.Itoa(i+1)] = Capture{Name: n}
+	}
+	return m
+}
+
+// class returns the delimiters escaped for use in a character class.
+func class(delimiters []string) string {
+	var b strings.Builder
+	for _, d := range delimiters {
+		for _, r := range d {
+			if strings.ContainsRune(`\]^-[`, r) {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// kebab converts the element name to kebab case, e.g. CodeBlock to code-block
+// and HTTPSLink to https-link.
+func kebab(name string) string {
+	rs := []rune(name)
+	var b strings.Builder
+	for i, r := range rs {
+		if unicode.IsUpper(r) {
+			if i > 0 && (!unicode.IsUpper(rs[i-1]) || i+1 < len(rs) && unicode.IsLower(rs[i+1])) {
+				b.WriteByte('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func counterpart(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if c, ok := leftRightChars[r]; ok {
+			r = c
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var leftRightChars = map[rune]rune{
+	'{': '}',
+	'[': ']',
+	'(': ')',
+	'<': '>',
+}
+
+// isPunct determines whether r is an ASCII punctuation character.
+func isPunct(r rune) bool {
+	return r >= 0x21 && r <= 0x2F ||
+		r >= 0x3A && r <= 0x40 ||
+		r >= 0x5B && r <= 0x60 ||
+		r >= 0x7B && r <= 0x7E
+}
@@ -0,0 +1,108 @@
+package textmate
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/config"
+)
+
+func TestNew(t *testing.T) {
+	g := New(config.Default.Elements, "")
+	if g.ScopeName != DefaultScopeName {
+		t.Errorf("got scope name %q, want %q", g.ScopeName, DefaultScopeName)
+	}
+
+	// rules by name (the last one, escaped variants come first)
+	rules := map[string]Rule{}
+	for _, key := range []string{"blocks", "inlines"} {
+		for _, r := range g.Repository[key].Patterns {
+			if r.Name != "" {
+				rules[r.Name] = r
+			}
+		}
+	}
+
+	cases := []struct {
+		scope  string
+		line   string
+		groups []string // captured groups (begin or match)
+	}{
+		{"markup.heading.heading.touch", "> == a", []string{"> ", "=="}},
+		{"markup.heading.numbered-heading.touch", "### a", []string{"", "###"}},
+		{"comment.block.block-comment.touch", "/ / a", []string{"", "/", " / a"}},
+		{"comment.block.block-comment.touch", "> / a", []string{"> ", "/", " a"}},
+		{"meta.fenced.code-block.touch", "`go", []string{"", "`", "go"}},
+		{"markup.raw.inline.code.touch", "``", []string{"``"}},
+		{"comment.line.comment.touch", "//", []string{"//"}},
+		{"markup.other.strong.touch", "**", []string{"**"}},
+		{"markup.underline.link.https.touch", "https://example.test.", []string{"https://", "example.test"}},
+		{"constant.character.line-break.touch", `\`, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.scope+" "+c.line, func(t *testing.T) {
+			r, ok := rules[c.scope]
+			if !ok {
+				t.Fatalf("missing rule %q", c.scope)
+			}
+			pattern := r.Match
+			if pattern == "" {
+				pattern = r.Begin
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := re.FindStringSubmatch(c.line)
+			if m == nil {
+				t.Fatalf("%q does not match %q", pattern, c.line)
+			}
+			if got := m[1:]; strings.Join(got, "|") != strings.Join(c.groups, "|") {
+				t.Errorf("got groups %q, want %q", got, c.groups)
+			}
+		})
+	}
+}
+
+func TestNewDisabled(t *testing.T) {
+	elements := config.Elements{
+		"A": {Type: "uniform", Delimiter: "*"},
+		"B": {Type: "uniform", Delimiter: "_", Disabled: true},
+		"C": {Type: "list"},
+	}
+	var b bytes.Buffer
+	if err := New(elements, "text.x").Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	var g Grammar
+	if err := json.Unmarshal(b.Bytes(), &g); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, r := range g.Repository["inlines"].Patterns {
+		if r.Name != "" {
+			names = append(names, r.Name)
+		}
+	}
+	if got, want := strings.Join(names, ","), "markup.other.a.x"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestKebab(t *testing.T) {
+	cases := map[string]string{
+		"Heading":   "heading",
+		"CodeBlock": "code-block",
+		"HTTPS":     "https",
+		"HTTPSLink": "https-link",
+		"H1":        "h1",
+	}
+	for in, want := range cases {
+		if got := kebab(in); got != want {
+			t.Errorf("kebab(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Package filter provides a way to transform node trees by external programs
+// (filters) written in any language.
+//
+// A filter reads a node tree, encoded by node.EncodeJSON, from stdin and writes
+// the transformed tree, in the same encoding, to stdout. The output format (e.g.
+// html) is available in the TO_FORMAT environment variable. A filter that exits
+// with a non-zero status fails the transformation.
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/touchmarine/to/node"
+)
+
+// FormatEnv is the environment variable that holds the output format.
+const FormatEnv = "TO_FORMAT"
+
+// Filter is an external program that transforms node trees.
+type Filter struct {
+	Command string    // program name (looked up in PATH) or path
+	Args    []string  // program arguments
+	Format  string    // output format passed in FormatEnv
+	Stderr  io.Writer // program's stderr; if nil, it is included in errors
+}
+
+// Run pipes the node tree through the filter and returns the transformed tree.
+func (f Filter) Run(n *node.Node) (*node.Node, error) {
+	var in bytes.Buffer
+	if err := node.EncodeJSON(&in, n); err != nil {
+		return nil, fmt.Errorf("filter %s: %w", f.Command, err)
+	}
+
+	var out, stderr bytes.Buffer
+	cmd := exec.Command(f.Command, f.Args...)
+	cmd.Env = append(os.Environ(), FormatEnv+"="+f.Format)
+	cmd.Stdin = &in
+	cmd.Stdout = &out
+	if f.Stderr != nil {
+		cmd.Stderr = f.Stderr
+	} else {
+		cmd.Stderr = &stderr
+	}
+	if err := cmd.Run(); err != nil {
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			return nil, fmt.Errorf("filter %s: %w: %s", f.Command, err, s)
+		}
+		return nil, fmt.Errorf("filter %s: %w", f.Command, err)
+	}
+
+	root, err := node.DecodeJSON(&out)
+	if err != nil {
+		return nil, fmt.Errorf("filter %s: %w", f.Command, err)
+	}
+	return root, nil
+}
+
+// Chain is a list of filters run one after another.
+type Chain []Filter
+
+// Run pipes the node tree through the filters in order.
+func (c Chain) Run(n *node.Node) (*node.Node, error) {
+	for _, f := range c {
+		var err error
+		if n, err = f.Run(n); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
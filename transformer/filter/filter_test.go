@@ -0,0 +1,112 @@
+package filter_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/transformer/filter"
+)
+
+// helperEnv selects the filter the test binary acts as.
+const helperEnv = "TO_FILTER_TEST_HELPER"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(helperEnv) {
+	case "":
+		os.Exit(m.Run())
+	case "upper":
+		// uppercases text and records the format
+		root, err := node.DecodeJSON(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		var walk func(n *node.Node)
+		walk = func(n *node.Node) {
+			n.Value = strings.ToUpper(n.Value)
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c)
+			}
+		}
+		walk(root)
+		root.Data = node.Data{"format": os.Getenv(filter.FormatEnv)}
+		if err := node.EncodeJSON(os.Stdout, root); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "fail":
+		fmt.Fprintln(os.Stderr, "boom")
+		os.Exit(1)
+	case "garbage":
+		fmt.Print("{")
+	}
+	os.Exit(0)
+}
+
+// helper returns a filter that runs the test binary as the given filter.
+func helper(t *testing.T, name string) filter.Filter {
+	t.Helper()
+	old, ok := os.LookupEnv(helperEnv)
+	os.Setenv(helperEnv, name)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(helperEnv, old)
+		} else {
+			os.Unsetenv(helperEnv)
+		}
+	})
+	return filter.Filter{
+		Command: os.Args[0],
+		Format:  "html",
+	}
+}
+
+func tree() *node.Node {
+	root := &node.Node{Type: node.TypeContainer}
+	root.AppendChild(&node.Node{
+		Element: "Text",
+		Type:    node.TypeText,
+		Value:   "touch",
+	})
+	return root
+}
+
+func TestRun(t *testing.T) {
+	f := helper(t, "upper")
+	root, err := filter.Chain{f, f}.Run(tree())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := root.FirstChild.Value, "TOUCH"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := root.Data["format"], "html"; got != want {
+		t.Errorf("got format %q, want %q", got, want)
+	}
+}
+
+func TestRunError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  string
+	}{
+		{"fail", "exit status 1: boom"},
+		{"garbage", "node: decode JSON: unexpected EOF"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := helper(t, c.name).Run(tree())
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("got error %v, want %q", err, c.err)
+			}
+		})
+	}
+
+	_, err := filter.Filter{Command: "to-filter-that-does-not-exist"}.Run(tree())
+	if err == nil {
+		t.Error("got no error for missing filter")
+	}
+}
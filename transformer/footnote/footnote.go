@@ -0,0 +1,281 @@
+// Package footnote provides a transformer for numbering footnote references and
+// linking them to their definitions. The numbered definitions are collected by
+// the footnote aggregator to render the footnotes section.
+package footnote
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/transformer"
+)
+
+// Keys to the footnote data in node.Data of references and definitions.
+const (
+	KeyLabel      = "footnoteLabel"      // string, the normalized label
+	KeyNumber     = "footnoteNumber"     // int, set only on linked references and definitions
+	KeyID         = "footnoteID"         // string, e.g. "fnref-label" or "fn-label"
+	KeyTarget     = "footnoteTarget"     // string, the ID of the referenced definition
+	KeyReferences = "footnoteReferences" // []string, the IDs of the references of a definition
+)
+
+// Problem codes reported by Check.
+const (
+	CodeUndefined parser.Code = "undefinedFootnote"
+	CodeUnused    parser.Code = "unusedFootnote"
+	CodeDuplicate parser.Code = "duplicateFootnote"
+)
+
+// Map is a map of footnote names to Footnotes. Each footnote name is numbered
+// separately.
+type Map map[string]Footnote
+
+// Footnote holds the information the transformer uses to recognize footnote
+// references and definitions.
+type Footnote struct {
+	// Definition is the element that defines a footnote, usually a sticky
+	// whose first child holds the label, e.g.:
+	// 	^label
+	// 	Footnote content.
+	Definition string
+	// Reference is the inline element that references a footnote by its
+	// label, e.g. ^^label^^.
+	Reference string
+}
+
+// Transformer numbers the footnote references in document order and links
+// them to their definitions (it mutates the tree).
+//
+// A footnote gets the next number when it is first referenced; later
+// references to it get the same number. References to undefined footnotes and
+// definitions that are never referenced get no number, neither do repeated
+// definitions of the same label. Labels are compared after collapsing spacing,
+// so "a  b" and "a b" are the same label.
+//
+// References get:
+// 	KeyLabel, and if defined KeyNumber, KeyID ("fnref-label", "fnref-label-2",
+// 	...), and KeyTarget
+// Definitions get:
+// 	KeyLabel, KeyID ("fn-label"), and if referenced KeyNumber and KeyReferences
+type Transformer struct {
+	Footnotes Map
+}
+
+// Transform implements the Transformer interface.
+func (t Transformer) Transform(n *node.Node) *node.Node {
+	for _, name := range t.names() {
+		newState(false).transform(n, t.Footnotes[name])
+	}
+	return n
+}
+
+// Stream returns a transformer like t that continues the numbering of the
+// trees it transformed before. As later trees are not known yet, references
+// to footnotes not defined so far are numbered as if they were defined later.
+// The trees transformed before are not kept: definitions placed before their
+// first reference stay unnumbered, and definitions get only the references
+// that precede them and those in their own tree.
+//
+// Stream implements the transformer.Streamer interface.
+func (t Transformer) Stream() transformer.Transformer {
+	s := &stream{
+		t:      t,
+		states: map[string]*state{},
+	}
+	for name := range t.Footnotes {
+		s.states[name] = newState(true)
+	}
+	return s
+}
+
+type stream struct {
+	t      Transformer
+	states map[string]*state // by footnote name
+}
+
+// Transform implements the Transformer interface.
+func (s *stream) Transform(n *node.Node) *node.Node {
+	for _, name := range s.t.names() {
+		s.states[name].transform(n, s.t.Footnotes[name])
+	}
+	return n
+}
+
+// state is the numbering of a footnote.
+type state struct {
+	stream     bool                // number references before their definitions
+	defined    map[string]bool     // by label
+	numbers    map[string]int      // by label
+	references map[string][]string // by label, the reference IDs
+}
+
+func newState(stream bool) *state {
+	return &state{
+		stream:     stream,
+		defined:    map[string]bool{},
+		numbers:    map[string]int{},
+		references: map[string][]string{},
+	}
+}
+
+func (s *state) transform(n *node.Node, f Footnote) {
+	definitions := map[string]*node.Node{} // by label, the first definition in n
+	walk(n, func(c *node.Node) bool {
+		if c.Element != "" && c.Element == f.Definition {
+			label := DefinitionLabel(c)
+			setData(c, KeyLabel, label)
+			if !s.defined[label] {
+				s.defined[label] = true
+				definitions[label] = c
+				c.Data[KeyID] = "fn-" + id(label)
+			}
+		}
+		return true
+	})
+
+	walk(n, func(c *node.Node) bool {
+		if c.Element != "" && c.Element == f.Reference {
+			label := normalize(c.TextContent())
+			setData(c, KeyLabel, label)
+			if !s.defined[label] && !s.stream {
+				return true
+			}
+			if _, ok := s.numbers[label]; !ok {
+				s.numbers[label] = len(s.numbers) + 1
+			}
+			refID := "fnref-" + id(label)
+			if k := len(s.references[label]); k > 0 {
+				refID += fmt.Sprintf("-%d", k+1)
+			}
+			s.references[label] = append(s.references[label], refID)
+
+			c.Data[KeyNumber] = s.numbers[label]
+			c.Data[KeyID] = refID
+			c.Data[KeyTarget] = "fn-" + id(label)
+		}
+		return true
+	})
+
+	for label, def := range definitions {
+		if num, ok := s.numbers[label]; ok {
+			def.Data[KeyNumber] = num
+			def.Data[KeyReferences] = s.references[label]
+		}
+	}
+}
+
+// Check reports references to undefined footnotes, footnotes that are never
+// referenced, and repeated definitions as warnings. It expects a tree
+// transformed by t.
+//
+// Check implements the transformer.Checker interface.
+func (t Transformer) Check(n *node.Node) parser.ErrorList {
+	var list parser.ErrorList
+	for _, name := range t.names() {
+		f := t.Footnotes[name]
+		walk(n, func(c *node.Node) bool {
+			if c.Element == "" {
+				return true
+			}
+			label, ok := c.Data[KeyLabel].(string)
+			if !ok {
+				return true
+			}
+			_, numbered := c.Data[KeyNumber]
+			switch c.Element {
+			case f.Reference:
+				if !numbered {
+					list.Add(problem(c, CodeUndefined, "undefined footnote %q", label))
+				}
+			case f.Definition:
+				loc := c
+				if c.FirstChild != nil && c.Location == (node.Location{}) {
+					// point at the label
+					loc = c.FirstChild
+				}
+				if _, ok := c.Data[KeyID]; !ok {
+					list.Add(problem(loc, CodeDuplicate, "footnote %q already defined", label))
+				} else if !numbered {
+					list.Add(problem(loc, CodeUnused, "unused footnote %q", label))
+				}
+			}
+			return true
+		})
+	}
+	list.Sort()
+	return list
+}
+
+func (t Transformer) names() []string {
+	var names []string
+	for n := range t.Footnotes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefinitionLabel returns the normalized label of the footnote definition—the
+// text content of its first child.
+func DefinitionLabel(n *node.Node) string {
+	if n.FirstChild == nil {
+		return ""
+	}
+	return normalize(n.FirstChild.TextContent())
+}
+
+// References returns the IDs of the references stored in the definition's
+// node.Data[KeyReferences]. It also accepts the []interface{} values of trees
+// decoded from JSON.
+func References(n *node.Node) []string {
+	switch v := n.Data[KeyReferences].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		var a []string
+		for _, x := range v {
+			if s, ok := x.(string); ok {
+				a = append(a, s)
+			}
+		}
+		return a
+	}
+	return nil
+}
+
+func problem(n *node.Node, code parser.Code, format string, a ...interface{}) *parser.Error {
+	return &parser.Error{
+		Location: n.Location,
+		Code:     code,
+		Severity: parser.SeverityWarning,
+		Message:  fmt.Sprintf(format, a...),
+	}
+}
+
+// normalize trims and collapses the spacing in the label.
+func normalize(label string) string {
+	return strings.Join(strings.Fields(label), " ")
+}
+
+// id returns the label usable in an HTML id—spaces are replaced by hyphens.
+func id(label string) string {
+	return strings.ReplaceAll(label, " ", "-")
+}
+
+func setData(n *node.Node, key string, value interface{}) {
+	if n.Data == nil {
+		n.Data = node.Data{}
+	}
+	n.Data[key] = value
+}
+
+func walk(n *node.Node, fn func(n *node.Node) bool) {
+	if fn(n) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, fn)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package footnote_test
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/transformer"
+	"github.com/touchmarine/to/transformer/footnote"
+	"github.com/touchmarine/to/transformer/sticky"
+)
+
+const testdata = "testdata"
+
+// use go test -update to create/update the golden files
+var update = flag.Bool("update", false, "update golden files")
+
+func TestGolden(t *testing.T) {
+	ef, err := os.Open(filepath.Join(testdata, "elements.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+
+	var elements parser.Elements
+	if err := json.NewDecoder(ef).Decode(&elements); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs, err := filepath.Glob(filepath.Join(testdata, "*.to"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, in := range inputs {
+		basePath := in[:len(in)-len(".to")]
+
+		t.Run(basePath[len(testdata)+1:], func(t *testing.T) {
+			runTest(t, elements, basePath)
+		})
+	}
+}
+
+func runTest(t *testing.T, elements parser.Elements, testPath string) {
+	src, err := os.ReadFile(testPath + ".to")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := parser.Parser{
+		Elements: elements,
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+	}
+	root, err := p.Parse(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := transformer.Group{
+		sticky.Transformer{sticky.Map{
+			"D": {Element: "L"},
+		}},
+		footnote.Transformer{footnote.Map{
+			"F": {
+				Definition: "D",
+				Reference:  "R",
+			},
+		}},
+	}
+	root = g.Transform(root)
+
+	var b strings.Builder
+	if err := (node.Printer{node.PrintData}).Fprint(&b, root); err != nil {
+		t.Fatal(err)
+	}
+	b.WriteString("\n")
+	for _, e := range g.Check(root) {
+		fmt.Fprintf(&b, "%s %s\n", e.Code, e)
+	}
+	res := b.String()
+
+	goldenPath := testPath + ".golden"
+	if *update {
+		if err := os.WriteFile(goldenPath, []byte(res), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bg, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := string(bg)
+
+	if res != golden {
+		t.Errorf("\nfrom input:\n%s\ngot:\n%s\nwant:\n%s", string(src), res, golden)
+	}
+}
+
+func TestStream(t *testing.T) {
+	p := parser.Parser{
+		Elements: parser.Elements{
+			"R": {Name: "R", Type: node.TypeEscaped, Delimiter: "^"},
+			"L": {Name: "L", Type: node.TypeVerbatimLine, Delimiter: "^"},
+			"T": {Name: "T", Type: node.TypeLeaf},
+		},
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+	}
+	tr := footnote.Transformer{footnote.Map{
+		"F": {
+			Definition: "D",
+			Reference:  "R",
+		},
+	}}
+	g := transformer.Group{
+		sticky.Transformer{sticky.Map{
+			"D": {Element: "L"},
+		}},
+		tr.Stream(),
+	}
+
+	var (
+		refs []string // number and target of each reference
+		defs []*node.Node
+	)
+	for _, in := range []string{"a^^x^^ b^^y^^", "^x\nx", "c^^x^^", "^y\ny"} {
+		root, err := p.Parse(nil, []byte(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		root = g.Transform(root)
+		walk(root, func(n *node.Node) {
+			switch n.Element {
+			case "R":
+				refs = append(refs, fmt.Sprintf("%v %v", n.Data[footnote.KeyNumber], n.Data[footnote.KeyTarget]))
+			case "D":
+				defs = append(defs, n)
+			}
+		})
+	}
+
+	if got, want := strings.Join(refs, ", "), "1 fn-x, 2 fn-y, 1 fn-x"; got != want {
+		t.Errorf("got references %q, want %q", got, want)
+	}
+	// the definition of x does not get the reference after it, the tree it is
+	// in is not kept
+	for i, want := range []string{"1 [fnref-x]", "2 [fnref-y]"} {
+		d := defs[i]
+		if got := fmt.Sprintf("%v %v", d.Data[footnote.KeyNumber], footnote.References(d)); got != want {
+			t.Errorf("got definition %d %q, want %q", i, got, want)
+		}
+	}
+}
+
+func walk(n *node.Node, fn func(n *node.Node)) {
+	fn(n)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, fn)
+	}
+}
@@ -0,0 +1,255 @@
+// Package label provides a transformer for numbering labelled elements, like
+// figures, tables, and listings, and resolving the references to them.
+package label
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/template"
+	"github.com/touchmarine/to/transformer"
+	"github.com/touchmarine/to/transformer/sticky"
+)
+
+// Keys to the label data in node.Data of labelled elements and references.
+const (
+	KeyID     = "labelID"     // string, the id of the labelled element
+	KeyKind   = "labelKind"   // string, e.g. "Figure"
+	KeyNumber = "labelNumber" // int, the number within the kind
+	KeyText   = "label"       // string, the kind and the number, e.g. "Figure 3"
+	KeyTarget = "labelTarget" // string, on references: the referenced id
+)
+
+// Problem codes reported by Check.
+const (
+	CodeUnresolved parser.Code = "unresolvedReference"
+	CodeDuplicate  parser.Code = "duplicateLabel"
+)
+
+// Map is a map of label names to Labels.
+type Map map[string]Label
+
+// Label holds the information the transformer uses to recognize a kind of
+// labelled elements and the references to them.
+type Label struct {
+	Element   string // the labelled element
+	Reference string // the inline element that references the labelled elements by id
+	Kind      string // the kind of the labelled elements, e.g. "Figure"
+}
+
+// Transformer numbers the labelled elements per kind in document order and
+// resolves the references to them (it mutates the tree).
+//
+// An element is labelled if it is the target of a sticky placed before it
+// whose text content has an id attribute, e.g. Attributes:
+// 	! id="teapot"
+// 	.image teapot.jpg
+// 	+ A teapot.
+// If the target is itself a sticky (like the captioned image above), the kind
+// is determined by the sticky's target—the image, not the caption. Elements
+// without an id are not numbered.
+//
+// Labelled elements get KeyID, KeyKind, KeyNumber, and KeyText. References
+// get KeyTarget and, if the id is labelled, also KeyKind, KeyNumber, and
+// KeyText of the element they reference. Repeated ids are labelled only the
+// first time.
+type Transformer struct {
+	Labels Map
+}
+
+// Transform implements the Transformer interface.
+func (t Transformer) Transform(n *node.Node) *node.Node {
+	if len(t.Labels) == 0 {
+		return n
+	}
+	return newState(t).transform(n)
+}
+
+// Stream returns a transformer like t that continues the numbering of the
+// trees it transformed before. References are resolved only to the elements
+// labelled in the same or an earlier tree.
+//
+// Stream implements the transformer.Streamer interface.
+func (t Transformer) Stream() transformer.Transformer {
+	if len(t.Labels) == 0 {
+		return t
+	}
+	return newState(t)
+}
+
+// state is the numbering of the labelled elements; it is a transformer.
+type state struct {
+	t        Transformer
+	counters map[string]int       // by kind
+	labelled map[string]node.Data // by id, the label data of the element
+}
+
+func newState(t Transformer) *state {
+	return &state{
+		t:        t,
+		counters: map[string]int{},
+		labelled: map[string]node.Data{},
+	}
+}
+
+// Transform implements the Transformer interface.
+func (s *state) Transform(n *node.Node) *node.Node {
+	return s.transform(n)
+}
+
+func (s *state) transform(n *node.Node) *node.Node {
+	walk(n, func(c *node.Node) bool {
+		id, target := labelID(c)
+		if id == "" {
+			return true
+		}
+		l, ok := s.t.kind(target)
+		if !ok {
+			return true
+		}
+		if _, dup := s.labelled[id]; dup {
+			setData(target, KeyID, id) // reported by Check
+			return true
+		}
+		s.counters[l.Kind]++
+		num := s.counters[l.Kind]
+		setData(target, KeyID, id)
+		target.Data[KeyKind] = l.Kind
+		target.Data[KeyNumber] = num
+		target.Data[KeyText] = l.Kind + " " + strconv.Itoa(num)
+		s.labelled[id] = node.Data{
+			KeyKind:   l.Kind,
+			KeyNumber: num,
+			KeyText:   target.Data[KeyText],
+		}
+		return true
+	})
+
+	references := s.t.references()
+	walk(n, func(c *node.Node) bool {
+		if c.Element == "" || !references[c.Element] {
+			return true
+		}
+		id := strings.TrimSpace(c.TextContent())
+		setData(c, KeyTarget, id)
+		if x, ok := s.labelled[id]; ok {
+			for _, k := range []string{KeyKind, KeyNumber, KeyText} {
+				c.Data[k] = x[k]
+			}
+		}
+		return true
+	})
+	return n
+}
+
+// Check reports references to ids that are not labelled and repeated ids as
+// errors. It expects a tree transformed by t.
+//
+// Check implements the transformer.Checker interface.
+func (t Transformer) Check(n *node.Node) parser.ErrorList {
+	var list parser.ErrorList
+	references := t.references()
+	walk(n, func(c *node.Node) bool {
+		if c.Element == "" || c.Data == nil {
+			return true
+		}
+		if id, ok := c.Data[KeyTarget].(string); ok && references[c.Element] {
+			if _, resolved := c.Data[KeyText]; !resolved {
+				list.Add(problem(c, CodeUnresolved, "unresolved reference %q", id))
+			}
+		} else if id, ok := c.Data[KeyID].(string); ok {
+			if _, numbered := c.Data[KeyNumber]; !numbered {
+				loc := c
+				if c.Parent != nil && c.Parent.FirstChild != c {
+					// point at the sticky holding the id
+					loc = c.Parent.FirstChild
+				}
+				list.Add(problem(loc, CodeDuplicate, "label %q already defined", id))
+			}
+		}
+		return true
+	})
+	list.Sort()
+	return list
+}
+
+// kind returns the label of the element. If the element is a sticky, the kind
+// of its target is returned.
+func (t Transformer) kind(n *node.Node) (Label, bool) {
+	for _, name := range t.names() {
+		if l := t.Labels[name]; l.Element != "" && l.Element == n.Element {
+			if l.Kind == "" {
+				l.Kind = name
+			}
+			return l, true
+		}
+	}
+	switch n.Data[sticky.Key] {
+	case "before":
+		if n.LastChild != nil {
+			return t.kind(n.LastChild)
+		}
+	case "after":
+		if n.FirstChild != nil {
+			return t.kind(n.FirstChild)
+		}
+	}
+	return Label{}, false
+}
+
+func (t Transformer) references() map[string]bool {
+	m := map[string]bool{}
+	for _, l := range t.Labels {
+		if l.Reference != "" {
+			m[l.Reference] = true
+		}
+	}
+	return m
+}
+
+func (t Transformer) names() []string {
+	var names []string
+	for n := range t.Labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// labelID returns the id and the target of the sticky n if n is a sticky
+// placed before its target whose sticky element has an id attribute.
+func labelID(n *node.Node) (string, *node.Node) {
+	if n.Data[sticky.Key] != "before" || n.FirstChild == nil || n.FirstChild == n.LastChild {
+		return "", nil
+	}
+	id, _ := template.ParseAttributes(n.FirstChild.TextContent())["id"].(string)
+	return id, n.LastChild
+}
+
+func problem(n *node.Node, code parser.Code, format string, a ...interface{}) *parser.Error {
+	return &parser.Error{
+		Location: n.Location,
+		Code:     code,
+		Severity: parser.SeverityError,
+		Message:  fmt.Sprintf(format, a...),
+	}
+}
+
+func setData(n *node.Node, key string, value interface{}) {
+	if n.Data == nil {
+		n.Data = node.Data{}
+	}
+	n.Data[key] = value
+}
+
+func walk(n *node.Node, fn func(n *node.Node) bool) {
+	if fn(n) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, fn)
+		}
+	}
+}
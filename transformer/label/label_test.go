@@ -0,0 +1,112 @@
+package label_test
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/transformer"
+	"github.com/touchmarine/to/transformer/label"
+	"github.com/touchmarine/to/transformer/sticky"
+)
+
+const testdata = "testdata"
+
+// use go test -update to create/update the golden files
+var update = flag.Bool("update", false, "update golden files")
+
+func TestGolden(t *testing.T) {
+	ef, err := os.Open(filepath.Join(testdata, "elements.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+
+	var elements parser.Elements
+	if err := json.NewDecoder(ef).Decode(&elements); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs, err := filepath.Glob(filepath.Join(testdata, "*.to"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, in := range inputs {
+		basePath := in[:len(in)-len(".to")]
+
+		t.Run(basePath[len(testdata)+1:], func(t *testing.T) {
+			runTest(t, elements, basePath)
+		})
+	}
+}
+
+func runTest(t *testing.T, elements parser.Elements, testPath string) {
+	src, err := os.ReadFile(testPath + ".to")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := parser.Parser{
+		Elements: elements,
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+	}
+	root, err := p.Parse(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := transformer.Group{
+		sticky.Transformer{sticky.Map{
+			"SA": {Element: "A"},
+			"SC": {Element: "C", After: true},
+		}},
+		label.Transformer{label.Map{
+			"Figure": {
+				Element:   "L",
+				Reference: "R",
+				Kind:      "Figure",
+			},
+			"Listing": {
+				Element:   "F",
+				Reference: "R",
+			},
+		}},
+	}
+	root = g.Transform(root)
+
+	var b strings.Builder
+	if err := (node.Printer{node.PrintData}).Fprint(&b, root); err != nil {
+		t.Fatal(err)
+	}
+	b.WriteString("\n")
+	for _, e := range g.Check(root) {
+		fmt.Fprintf(&b, "%s %s\n", e.Code, e)
+	}
+	res := b.String()
+
+	goldenPath := testPath + ".golden"
+	if *update {
+		if err := os.WriteFile(goldenPath, []byte(res), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bg, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := string(bg)
+
+	if res != golden {
+		t.Errorf("\nfrom input:\n%s\ngot:\n%s\nwant:\n%s", string(src), res, golden)
+	}
+}
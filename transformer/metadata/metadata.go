@@ -0,0 +1,176 @@
+// Package metadata provides a transformer for recognizing document metadata
+// (front matter) and adding it to node trees.
+package metadata
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/transformer"
+)
+
+// Key is a key to the metadata in node.Data of the root node and of the
+// metadata group. The metadata is a map[string]interface{} of keys to string
+// values.
+const Key = "meta"
+
+// CodeStray is the code of the problems reported by Check.
+const CodeStray parser.Code = "strayMetadata"
+
+// Map is a map of metadata group names (keys) to elements (values). Elements
+// tell the transformer which elements hold the metadata; they are usually
+// verbatim lines, e.g.:
+// 	% title Touch
+type Map map[string]string
+
+func (m Map) hasGroup(g string) bool {
+	_, ok := m[g]
+	return ok
+}
+
+func (m Map) firstByElement(e string) (string, bool) {
+	for g, el := range m {
+		if el == e {
+			return g, true
+		}
+	}
+	return "", false
+}
+
+// Transformer recognizes the metadata at the start of the document—the
+// metadata elements on the first lines, which are not separated by blank
+// lines—groups them, and sets the metadata in the data of the root and of the
+// group (it mutates the tree).
+//
+// Each element holds one key/value pair: the first word of its text content is
+// the key and the rest the value. Elements without a key are ignored. A
+// repeated key replaces the earlier value. The root always gets the metadata,
+// even if empty, so templates can use it without checking for it:
+// 	<title>{{.Data.meta.title}}</title>
+type Transformer struct {
+	Metadata Map
+}
+
+// Transform implements the Transformer interface.
+func (t Transformer) Transform(n *node.Node) *node.Node {
+	if len(t.Metadata) == 0 {
+		return n
+	}
+
+	meta := map[string]interface{}{}
+	name := ""
+	var children []*node.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		g, found := t.Metadata.firstByElement(c.Element)
+		if !found || name != "" && g != name {
+			break
+		}
+		if c.PreviousSibling != nil && c.Location.Range.Start.Line != c.PreviousSibling.Location.Range.End.Line+1 {
+			// separated by a blank line
+			break
+		}
+		name = g
+		children = append(children, c)
+
+		if key, value := split(c.TextContent()); key != "" {
+			meta[key] = value
+		}
+	}
+
+	if len(children) > 0 {
+		g := &node.Node{
+			Element: name,
+			Type:    node.TypeContainer,
+			Data:    node.Data{Key: meta},
+		}
+		n.InsertBefore(g, children[0])
+		for _, c := range children {
+			n.RemoveChild(c)
+			g.AppendChild(c)
+		}
+	}
+
+	if n.Data == nil {
+		n.Data = node.Data{}
+	}
+	n.Data[Key] = meta
+	return n
+}
+
+// Check reports the metadata elements outside the metadata at the start of the
+// document as warnings—they are not part of the metadata. It expects a tree
+// transformed by t.
+//
+// Check implements the transformer.Checker interface.
+func (t Transformer) Check(n *node.Node) parser.ErrorList {
+	var list parser.ErrorList
+	walk(n, func(c *node.Node) bool {
+		if c.Element == "" {
+			return true
+		}
+		if _, found := t.Metadata.firstByElement(c.Element); found &&
+			(c.Parent == nil || !t.Metadata.hasGroup(c.Parent.Element)) {
+			list.Add(&parser.Error{
+				Location: c.Location,
+				Code:     CodeStray,
+				Severity: parser.SeverityWarning,
+				Message:  fmt.Sprintf("metadata %q not at the start of the document", strings.Trim(c.TextContent(), " \t")),
+			})
+			return false
+		}
+		return true
+	})
+	list.Sort()
+	return list
+}
+
+// Stream returns a transformer like t that recognizes the metadata only in the
+// first tree it transforms, the start of the document; later trees get empty
+// metadata.
+//
+// Stream implements the transformer.Streamer interface.
+func (t Transformer) Stream() transformer.Transformer {
+	return &stream{t: t}
+}
+
+type stream struct {
+	t       Transformer
+	started bool // whether the first tree was transformed
+}
+
+// Transform implements the Transformer interface.
+func (s *stream) Transform(n *node.Node) *node.Node {
+	if !s.started {
+		s.started = true
+		return s.t.Transform(n)
+	}
+	if len(s.t.Metadata) == 0 {
+		return n
+	}
+	if n.Data == nil {
+		n.Data = node.Data{}
+	}
+	n.Data[Key] = map[string]interface{}{}
+	return n
+}
+
+// split splits the text into the key (first word) and the value (the rest),
+// both trimmed of spacing.
+func split(s string) (string, string) {
+	s = strings.Trim(s, " \t")
+	i := strings.IndexAny(s, " \t")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.Trim(s[i:], " \t")
+}
+
+func walk(n *node.Node, fn func(n *node.Node) bool) {
+	if fn(n) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, fn)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package metadata_test
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/transformer/metadata"
+)
+
+const testdata = "testdata"
+
+// use go test -update to create/update the golden files
+var update = flag.Bool("update", false, "update golden files")
+
+func TestGolden(t *testing.T) {
+	ef, err := os.Open(filepath.Join(testdata, "elements.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+
+	var elements parser.Elements
+	if err := json.NewDecoder(ef).Decode(&elements); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs, err := filepath.Glob(filepath.Join(testdata, "*.to"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, in := range inputs {
+		basePath := in[:len(in)-len(".to")]
+
+		t.Run(basePath[len(testdata)+1:], func(t *testing.T) {
+			runTest(t, elements, basePath)
+		})
+	}
+}
+
+func runTest(t *testing.T, elements parser.Elements, testPath string) {
+	src, err := os.ReadFile(testPath + ".to")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := parser.Parser{
+		Elements: elements,
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+	}
+	root, err := p.Parse(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root = metadata.Transformer{metadata.Map{
+		"GA": "A",
+	}}.Transform(root)
+
+	var b strings.Builder
+	if err := (node.Printer{node.PrintData}).Fprint(&b, root); err != nil {
+		t.Fatal(err)
+	}
+	res := b.String()
+
+	goldenPath := testPath + ".golden"
+	if *update {
+		if err := os.WriteFile(goldenPath, []byte(res), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bg, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := string(bg)
+
+	if res != golden {
+		t.Errorf("\nfrom input:\n%s\ngot:\n%s\nwant:\n%s", string(src), res, golden)
+	}
+}
+
+func TestTransformWithoutMetadata(t *testing.T) {
+	root := &node.Node{Type: node.TypeContainer}
+	root = metadata.Transformer{}.Transform(root)
+	if root.Data != nil {
+		t.Errorf("got data %v, want none", root.Data)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p := parser.Parser{
+		Elements: parser.Elements{
+			"A": {Name: "A", Type: node.TypeVerbatimLine, Delimiter: "%"},
+			"T": {Name: "T", Type: node.TypeLeaf},
+		},
+		TabWidth: 8,
+	}
+	root, err := p.Parse(nil, []byte("% a b\n\nc\n\n% d e"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := metadata.Transformer{metadata.Map{"GA": "A"}}
+	list := tr.Check(tr.Transform(root))
+	if len(list) != 1 {
+		t.Fatalf("got %v, want 1 problem", list)
+	}
+	if got, want := list[0].Error(), `5:1: warning: metadata "d e" not at the start of the document`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if list[0].Code != metadata.CodeStray {
+		t.Errorf("got code %q, want %q", list[0].Code, metadata.CodeStray)
+	}
+}
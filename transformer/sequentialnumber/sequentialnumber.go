@@ -20,7 +20,22 @@ const Key = "sequentialNumber"
 //
 // Transform implements the Transformer interface.
 func Transform(n *node.Node) *node.Node {
-	m := map[string]map[int]int{}
+	return (&Counter{}).Transform(n)
+}
+
+// Counter is a transformer like Transform that continues the sequential
+// numbers of the trees it transformed before, so the consecutive parts of a
+// document are numbered as the whole document. The zero value is ready to use.
+type Counter struct {
+	m map[string]map[int]int // element to rank to the last number
+}
+
+// Transform implements the Transformer interface.
+func (c *Counter) Transform(n *node.Node) *node.Node {
+	if c.m == nil {
+		c.m = map[string]map[int]int{}
+	}
+	m := c.m
 	walk(n, func(n *node.Node) bool {
 		rank, ok := n.Data[parser.KeyRank].(int)
 		if ok {
@@ -28,19 +43,16 @@ func Transform(n *node.Node) *node.Node {
 				m[n.Element] = map[int]int{}
 			}
 			m[n.Element][rank]++
-
 			for r, _ := range m[n.Element] {
 				if r > rank {
 					// clear deeper rank
 					m[n.Element][r] = 0
 				}
 			}
-
 			var sequentialNumbers []int
 			for i := 2; i <= rank; i++ {
 				sequentialNumbers = append(sequentialNumbers, m[n.Element][i])
 			}
-
 			n.Data[Key] = sequentialNumber(sequentialNumbers)
 		}
 		return true
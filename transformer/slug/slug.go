@@ -0,0 +1,122 @@
+// Package slug provides a transformer for attaching URL-safe anchor slugs to
+// headings. The slugs are used as the heading ids in HTML and as the targets of
+// the table of contents.
+package slug
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/template"
+	"github.com/touchmarine/to/transformer/sticky"
+)
+
+// Key is a key to the slug in node.Data.
+const Key = "slug"
+
+// Transform attaches a slug to each heading in node.Data[Key] (it mutates the
+// tree). Headings are the elements with a rank in node.Data[parser.KeyRank].
+//
+// A heading with an explicit id—given by a sticky placed before it whose text
+// content has an id attribute, e.g. Attributes—gets the id as its slug. Other
+// headings get the slug of their text content (see Slug). Slugs are unique
+// within the tree: a slug that is already used, also as an explicit id of any
+// element, gets a numeric suffix, e.g. "intro-1" for the second "Intro".
+//
+// Transform implements the Transformer interface.
+func Transform(n *node.Node) *node.Node {
+	return (&Slugger{}).Transform(n)
+}
+
+// Slugger is a transformer like Transform whose slugs are also unique among
+// the slugs and explicit ids of the trees it transformed before, so the
+// consecutive parts of a document get the slugs of the whole document. An
+// explicit id in a later tree does not change the slugs given before it. The
+// zero value is ready to use.
+type Slugger struct {
+	used map[string]bool
+}
+
+// Transform implements the Transformer interface.
+func (sg *Slugger) Transform(n *node.Node) *node.Node {
+	if sg.used == nil {
+		sg.used = map[string]bool{}
+	}
+	used := sg.used
+	walk(n, func(c *node.Node) bool {
+		if id, _ := explicitID(c); id != "" {
+			used[id] = true
+		}
+		return true
+	})
+
+	walk(n, func(c *node.Node) bool {
+		if _, ok := c.Data[parser.KeyRank].(int); !ok {
+			return true
+		}
+		if id, ok := explicitID(c.Parent); ok && id != "" && c.Parent.LastChild == c {
+			c.Data[Key] = id
+			return true
+		}
+
+		base := Slug(c.TextContent())
+		s := base
+		for i := 1; used[s]; i++ {
+			s = base + "-" + strconv.Itoa(i)
+		}
+		used[s] = true
+		c.Data[Key] = s
+		return true
+	})
+	return n
+}
+
+// Slug returns the slug of the text: lower-cased letters and numbers (of any
+// script) of the words joined by hyphens. Other characters are dropped. Hyphens
+// and underscores inside words are kept. If nothing remains, the slug is
+// "section".
+//
+// 	Hello, World!  -> hello-world
+// 	Čas & prostor  -> čas-prostor
+// 	1.2 Set-up     -> 12-set-up
+func Slug(text string) string {
+	var b strings.Builder
+	hyphen := false // whether a hyphen is pending
+	for _, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r) || r == '_':
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			hyphen = false
+			b.WriteRune(unicode.ToLower(r))
+		case unicode.IsSpace(r) || r == '-':
+			hyphen = true
+		}
+	}
+	if b.Len() == 0 {
+		return "section"
+	}
+	return b.String()
+}
+
+// explicitID returns the id attribute of the sticky n if n is a sticky placed
+// before its target. It reports whether n is such a sticky.
+func explicitID(n *node.Node) (string, bool) {
+	if n == nil || n.Data[sticky.Key] != "before" || n.FirstChild == nil || n.FirstChild == n.LastChild {
+		return "", false
+	}
+	id, _ := template.ParseAttributes(n.FirstChild.TextContent())["id"].(string)
+	return id, true
+}
+
+func walk(n *node.Node, fn func(n *node.Node) bool) {
+	if fn(n) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, fn)
+		}
+	}
+}
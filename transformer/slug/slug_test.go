@@ -0,0 +1,155 @@
+package slug_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/touchmarine/to/matcher"
+	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
+	"github.com/touchmarine/to/transformer"
+	"github.com/touchmarine/to/transformer/slug"
+	"github.com/touchmarine/to/transformer/sticky"
+)
+
+func TestSlug(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"a", "a"},
+		{"Hello, World!", "hello-world"},
+		{"  spaced   out  ", "spaced-out"},
+		{"set-up and snake_case", "set-up-and-snake_case"},
+		{"a - b", "a-b"},
+		{"1.2 Details", "12-details"},
+		{"Čas & prostor", "čas-prostor"},
+		{"日本語 テキスト", "日本語-テキスト"},
+		{"ÀB", "àb"},
+		{"?!", "section"},
+		{"", "section"},
+	}
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			if got := slug.Slug(c.in); got != c.out {
+				t.Errorf("got %q, want %q", got, c.out)
+			}
+		})
+	}
+}
+
+func TestTransform(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		out  []string // slugs of the headings in document order
+	}{
+		{
+			"single",
+			"== Intro",
+			[]string{"intro"},
+		},
+		{
+			"duplicates",
+			"== Intro\n== Intro\n=== Intro",
+			[]string{"intro", "intro-1", "intro-2"},
+		},
+		{
+			"suffix taken",
+			"== a-1\n== a\n== a",
+			[]string{"a-1", "a", "a-2"},
+		},
+		{
+			"explicit id",
+			"! id=\"start\"\n== Intro",
+			[]string{"start"},
+		},
+		{
+			"explicit id reserved",
+			"== Intro\n\n! id=\"intro\"\n> quote",
+			[]string{"intro-1"},
+		},
+		{
+			"sticky without id",
+			"! class=\"x\"\n== Intro",
+			[]string{"intro"},
+		},
+		{
+			"nested",
+			"> == Intro\n\n== Intro",
+			[]string{"intro", "intro-1"},
+		},
+	}
+
+	p := parser.Parser{
+		Elements: parser.Elements{
+			"H":  {Name: "H", Type: node.TypeRankedHanging, Delimiter: "="},
+			"A":  {Name: "A", Type: node.TypeVerbatimWalled, Delimiter: "!"},
+			"B":  {Name: "B", Type: node.TypeWalled, Delimiter: ">"},
+			"T":  {Name: "T", Type: node.TypeLeaf},
+			"MT": {Name: "MT", Type: node.TypeText},
+		},
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+	}
+	g := transformer.Group{
+		sticky.Transformer{sticky.Map{
+			"SA": {Element: "A"},
+		}},
+		transformer.Func(slug.Transform),
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			root, err := p.Parse(nil, []byte(c.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			root = g.Transform(root)
+
+			var slugs []string
+			walk(root, func(n *node.Node) {
+				if n.Element == "H" {
+					s, _ := n.Data[slug.Key].(string)
+					slugs = append(slugs, s)
+				}
+			})
+			if got, want := strings.Join(slugs, " "), strings.Join(c.out, " "); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func walk(n *node.Node, fn func(n *node.Node)) {
+	fn(n)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, fn)
+	}
+}
+
+func TestSlugger(t *testing.T) {
+	p := parser.Parser{
+		Elements: parser.Elements{
+			"H":  {Name: "H", Type: node.TypeRankedHanging, Delimiter: "="},
+			"MT": {Name: "MT", Type: node.TypeText},
+		},
+		Matchers: matcher.Defaults(),
+		TabWidth: 8,
+	}
+	var s slug.Slugger
+	var slugs []string
+	for _, in := range []string{"== Intro", "== Intro\n== a", "== Intro"} {
+		root, err := p.Parse(nil, []byte(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		walk(s.Transform(root), func(n *node.Node) {
+			if n.Element == "H" {
+				slugs = append(slugs, n.Data[slug.Key].(string))
+			}
+		})
+	}
+	if got, want := strings.Join(slugs, " "), "intro intro-1 a intro-2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
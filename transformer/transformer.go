@@ -5,6 +5,7 @@ package transformer
 
 import (
 	"github.com/touchmarine/to/node"
+	"github.com/touchmarine/to/parser"
 )
 
 // Transformer transforms the given node tree and returns it.
@@ -17,6 +18,23 @@ type Transformer interface {
 	Transform(node *node.Node) *node.Node
 }
 
+// Checker is implemented by transformers that can report problems in the node
+// trees they transformed, e.g. references to footnotes that are not defined.
+// Problems are not errors—the tree is still transformed—so they usually have
+// parser.SeverityWarning.
+type Checker interface {
+	Check(node *node.Node) parser.ErrorList
+}
+
+// Streamer is implemented by transformers whose results depend on the whole
+// document, e.g. numbering. Stream returns a transformer for the consecutive
+// parts of one document (such as the batches of blocks rendered while the
+// document is read) that keeps its state from part to part, so that each part
+// is transformed as within the whole document where possible.
+type Streamer interface {
+	Stream() Transformer
+}
+
 // Func is like what http.HandlerFunc is to http.Handler—an adapter to allow the
 // use of ordinary functions as Transformers. If f is a function with the
 // appropriate signature, Func(f) is a Transformer that calls and returns f(n).
@@ -38,3 +56,34 @@ func (g Group) Transform(n *node.Node) *node.Node {
 	}
 	return n
 }
+
+// Stream returns a group of the transformers in the group, each replaced by the
+// transformer its Stream method returns if it implements the Streamer
+// interface.
+//
+// Stream implements the Streamer interface.
+func (g Group) Stream() Transformer {
+	s := make(Group, len(g))
+	for i, t := range g {
+		if x, ok := t.(Streamer); ok {
+			t = x.Stream()
+		}
+		s[i] = t
+	}
+	return s
+}
+
+// Check returns the problems reported by the transformers in the group that
+// implement the Checker interface, sorted by location.
+//
+// Check implements the Checker interface.
+func (g Group) Check(n *node.Node) parser.ErrorList {
+	var list parser.ErrorList
+	for _, t := range g {
+		if c, ok := t.(Checker); ok {
+			list = append(list, c.Check(n)...)
+		}
+	}
+	list.Sort()
+	return list
+}